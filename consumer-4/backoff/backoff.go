@@ -0,0 +1,128 @@
+// Package backoff lets consumer-4 wait out the producer's rate limit and
+// load shedding.
+//
+// The producer answers clients over their limit with 429 Too Many Requests,
+// and requests it sheds under load with 503 Service Unavailable, both with a
+// Retry-After header. The transport here waits as long as it says and
+// retries, so a burst of CLI runs slows down instead of failing.
+//
+// A retried request other than a GET or HEAD carries the same
+// Idempotency-Key on every attempt, so the producer applies it at most once.
+package backoff
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults used by consumer-4.
+const (
+	DefaultRetries  = 3
+	DefaultMaxDelay = 30 * time.Second
+)
+
+// IdempotencyKeyHeader lets the producer recognise a retried request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// fallbackDelay is the first wait when a 429 carries no usable Retry-After;
+// it doubles with every retry.
+const fallbackDelay = time.Second
+
+// NewTransport returns a round tripper that retries requests answered with
+// 429, or with 503 and a Retry-After, up to retries times. It waits for the
+// response's Retry-After, in seconds or as an HTTP date, but never longer
+// than maxDelay; when the producer would have it wait longer, the response is
+// returned instead. Requests
+// with a body that cannot be replayed are not retried, and requests that
+// change state are given an Idempotency-Key unless they already have one.
+func NewTransport(base http.RoundTripper, retries int, maxDelay time.Duration) http.RoundTripper {
+	return &transport{base: base, retries: retries, maxDelay: maxDelay}
+}
+
+type transport struct {
+	base     http.RoundTripper
+	retries  int
+	maxDelay time.Duration
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.retries > 0 && req.Method != http.MethodGet && req.Method != http.MethodHead &&
+		req.Header.Get(IdempotencyKeyHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(IdempotencyKeyHeader, newKey())
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !retryable(resp) || attempt == t.retries {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay, ok := RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			delay = fallbackDelay << attempt
+		}
+		if delay > t.maxDelay {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether resp asks the client to come back later: a 429,
+// or a 503 from load shedding, which carries a Retry-After unlike a producer
+// that is down.
+func retryable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// newKey returns a random Idempotency-Key.
+func newKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RetryAfter parses a Retry-After header value, either delay-seconds or an
+// HTTP date, into how long to wait from now.
+func RetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
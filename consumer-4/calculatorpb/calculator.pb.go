@@ -0,0 +1,203 @@
+// gRPC flavour of the Calculator API. Each RPC is the operation with the
+// same operationId in calculator-api.yaml, with the same arithmetic and
+// errors: a missing operand or a zero divisor fails with INVALID_ARGUMENT
+// where the REST API answers 400.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: calculator.proto
+
+package calculatorpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// OperationRequest carries the operands. Both are required; they are
+// optional in the wire format only so that a missing one can be told apart
+// from zero.
+type OperationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             *float64               `protobuf:"fixed64,1,opt,name=x,proto3,oneof" json:"x,omitempty"`
+	Y             *float64               `protobuf:"fixed64,2,opt,name=y,proto3,oneof" json:"y,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OperationRequest) Reset() {
+	*x = OperationRequest{}
+	mi := &file_calculator_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OperationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OperationRequest) ProtoMessage() {}
+
+func (x *OperationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_calculator_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OperationRequest.ProtoReflect.Descriptor instead.
+func (*OperationRequest) Descriptor() ([]byte, []int) {
+	return file_calculator_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *OperationRequest) GetX() float64 {
+	if x != nil && x.X != nil {
+		return *x.X
+	}
+	return 0
+}
+
+func (x *OperationRequest) GetY() float64 {
+	if x != nil && x.Y != nil {
+		return *x.Y
+	}
+	return 0
+}
+
+// OperationResponse carries the result.
+type OperationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Result        float64                `protobuf:"fixed64,1,opt,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OperationResponse) Reset() {
+	*x = OperationResponse{}
+	mi := &file_calculator_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OperationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OperationResponse) ProtoMessage() {}
+
+func (x *OperationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_calculator_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OperationResponse.ProtoReflect.Descriptor instead.
+func (*OperationResponse) Descriptor() ([]byte, []int) {
+	return file_calculator_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *OperationResponse) GetResult() float64 {
+	if x != nil {
+		return x.Result
+	}
+	return 0
+}
+
+var File_calculator_proto protoreflect.FileDescriptor
+
+const file_calculator_proto_rawDesc = "" +
+	"\n" +
+	"\x10calculator.proto\x12\rcalculator.v1\"D\n" +
+	"\x10OperationRequest\x12\x11\n" +
+	"\x01x\x18\x01 \x01(\x01H\x00R\x01x\x88\x01\x01\x12\x11\n" +
+	"\x01y\x18\x02 \x01(\x01H\x01R\x01y\x88\x01\x01B\x04\n" +
+	"\x02_xB\x04\n" +
+	"\x02_y\"+\n" +
+	"\x11OperationResponse\x12\x16\n" +
+	"\x06result\x18\x01 \x01(\x01R\x06result2\xc8\x02\n" +
+	"\x11CalculatorService\x12H\n" +
+	"\x03Add\x12\x1f.calculator.v1.OperationRequest\x1a .calculator.v1.OperationResponse\x12M\n" +
+	"\bSubtract\x12\x1f.calculator.v1.OperationRequest\x1a .calculator.v1.OperationResponse\x12M\n" +
+	"\bMultiply\x12\x1f.calculator.v1.OperationRequest\x1a .calculator.v1.OperationResponse\x12K\n" +
+	"\x06Divide\x12\x1f.calculator.v1.OperationRequest\x1a .calculator.v1.OperationResponseB2Z0github.com/sahina/cvt-demo/producer/calculatorpbb\x06proto3"
+
+var (
+	file_calculator_proto_rawDescOnce sync.Once
+	file_calculator_proto_rawDescData []byte
+)
+
+func file_calculator_proto_rawDescGZIP() []byte {
+	file_calculator_proto_rawDescOnce.Do(func() {
+		file_calculator_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_calculator_proto_rawDesc), len(file_calculator_proto_rawDesc)))
+	})
+	return file_calculator_proto_rawDescData
+}
+
+var file_calculator_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_calculator_proto_goTypes = []any{
+	(*OperationRequest)(nil),  // 0: calculator.v1.OperationRequest
+	(*OperationResponse)(nil), // 1: calculator.v1.OperationResponse
+}
+var file_calculator_proto_depIdxs = []int32{
+	0, // 0: calculator.v1.CalculatorService.Add:input_type -> calculator.v1.OperationRequest
+	0, // 1: calculator.v1.CalculatorService.Subtract:input_type -> calculator.v1.OperationRequest
+	0, // 2: calculator.v1.CalculatorService.Multiply:input_type -> calculator.v1.OperationRequest
+	0, // 3: calculator.v1.CalculatorService.Divide:input_type -> calculator.v1.OperationRequest
+	1, // 4: calculator.v1.CalculatorService.Add:output_type -> calculator.v1.OperationResponse
+	1, // 5: calculator.v1.CalculatorService.Subtract:output_type -> calculator.v1.OperationResponse
+	1, // 6: calculator.v1.CalculatorService.Multiply:output_type -> calculator.v1.OperationResponse
+	1, // 7: calculator.v1.CalculatorService.Divide:output_type -> calculator.v1.OperationResponse
+	4, // [4:8] is the sub-list for method output_type
+	0, // [0:4] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_calculator_proto_init() }
+func file_calculator_proto_init() {
+	if File_calculator_proto != nil {
+		return
+	}
+	file_calculator_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_calculator_proto_rawDesc), len(file_calculator_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_calculator_proto_goTypes,
+		DependencyIndexes: file_calculator_proto_depIdxs,
+		MessageInfos:      file_calculator_proto_msgTypes,
+	}.Build()
+	File_calculator_proto = out.File
+	file_calculator_proto_goTypes = nil
+	file_calculator_proto_depIdxs = nil
+}
@@ -0,0 +1,79 @@
+// Package compression lets consumer-4 read compressed producer responses.
+//
+// The producer compresses responses with gzip or brotli when asked to.
+// net/http only decodes gzip on its own, so the transport here asks for both
+// and decodes them, leaving callers and CVT validation with the plain JSON.
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// AcceptEncoding is the Accept-Encoding header the transport sends.
+const AcceptEncoding = "br, gzip"
+
+// NewTransport returns a round tripper that asks base for compressed
+// responses and decodes them transparently. Requests that set their own
+// Accept-Encoding get the response exactly as base returns it.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded io.Reader
+	switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return resp, nil
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("decode gzip response: %w", err)
+		}
+		decoded = gz
+	case "br":
+		decoded = brotli.NewReader(resp.Body)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+
+	resp.Body = &decodedBody{Reader: decoded, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// decodedBody reads the decoded stream and closes the underlying body.
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
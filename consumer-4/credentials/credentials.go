@@ -0,0 +1,112 @@
+// Package credentials lets consumer-4 authenticate with the producer.
+//
+// When the producer requires authentication, its operations accept either a
+// static API key in the X-API-Key header or a JWT bearer token. Credentials
+// come from CONSUMER_API_KEY and CONSUMER_TOKEN, or from a JSON file such as
+//
+//	{"apiKey": "...", "token": "..."}
+//
+// named by CONSUMER_CREDENTIALS_FILE, by default credentials.json in a
+// consumer-4 directory under the user config directory. The environment wins
+// over the file.
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// APIKeyHeader carries the API key.
+const APIKeyHeader = "X-API-Key"
+
+// Credentials are what consumer-4 authenticates with. Either may be empty.
+type Credentials struct {
+	APIKey string `json:"apiKey,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// Empty reports whether there is nothing to send.
+func (c Credentials) Empty() bool {
+	return c.APIKey == "" && c.Token == ""
+}
+
+// Load reads the credentials from the environment and the credentials file.
+// A missing default file means no credentials; a missing file named by
+// CONSUMER_CREDENTIALS_FILE is an error.
+func Load() (Credentials, error) {
+	var c Credentials
+
+	path, required := os.Getenv("CONSUMER_CREDENTIALS_FILE"), true
+	if path == "" {
+		path, required = DefaultFile(), false
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &c); err != nil {
+				return Credentials{}, fmt.Errorf("%s: %w", path, err)
+			}
+		case required || !errors.Is(err, fs.ErrNotExist):
+			return Credentials{}, fmt.Errorf("read credentials: %w", err)
+		}
+	}
+
+	if key := os.Getenv("CONSUMER_API_KEY"); key != "" {
+		c.APIKey = key
+	}
+	if token := os.Getenv("CONSUMER_TOKEN"); token != "" {
+		c.Token = token
+	}
+	return c, nil
+}
+
+// DefaultFile returns the credentials file read when
+// CONSUMER_CREDENTIALS_FILE is not set, or "" if there is no user config
+// directory.
+func DefaultFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "consumer-4", "credentials.json")
+}
+
+// NewTransport returns a round tripper that adds creds to requests for host,
+// the producer's host and port, so they are not sent anywhere else. Headers
+// the caller set are left alone.
+func NewTransport(base http.RoundTripper, creds Credentials, host string) http.RoundTripper {
+	return &transport{base: base, creds: creds, host: host}
+}
+
+type transport struct {
+	base  http.RoundTripper
+	creds Credentials
+	host  string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	apiKey := t.creds.APIKey != "" && req.Header.Get(APIKeyHeader) == ""
+	token := t.creds.Token != "" && req.Header.Get("Authorization") == ""
+	if !apiKey && !token {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if apiKey {
+		req.Header.Set(APIKeyHeader, t.creds.APIKey)
+	}
+	if token {
+		req.Header.Set("Authorization", "Bearer "+t.creds.Token)
+	}
+	return t.base.RoundTrip(req)
+}
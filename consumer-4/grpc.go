@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sahina/cvt-demo/consumer-4/credentials"
+	"github.com/sahina/cvt-demo/consumer-4/grpcclient"
+	"github.com/sahina/cvt-demo/consumer-4/tlsclient"
+	"google.golang.org/grpc/status"
+)
+
+var grpcAddr = getEnv("CONSUMER_GRPC_ADDR", grpcclient.DefaultAddr)
+
+// runGRPC calls command on the producer's CalculatorService and prints the
+// result like the REST commands do. The gRPC API shares the REST contract's
+// semantics, so --validate validates the REST interaction the call
+// corresponds to.
+func runGRPC(command string, x, y float64, validate bool) {
+	creds, err := credentials.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := tlsclient.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	c, err := grpcclient.New(grpcAddr, tlsConfig, creds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	result, err := c.Call(context.Background(), command, x, y)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s (%s)\n", status.Convert(err).Message(), status.Code(err))
+		os.Exit(1)
+	}
+
+	if validate {
+		body, _ := json.Marshal(map[string]float64{"result": result})
+		runValidation(fmt.Sprintf("/%s?x=%s&y=%s", command, formatParam(x), formatParam(y)), 200, body)
+	}
+
+	printResult(command, x, y, result)
+}
@@ -0,0 +1,95 @@
+// Package grpcclient lets consumer-4 call the producer's gRPC
+// CalculatorService instead of its REST API.
+//
+// Calls carry the same credentials as REST requests, as x-api-key and
+// authorization metadata, and use the same TLS configuration; without one
+// the connection is plaintext.
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/sahina/cvt-demo/consumer-4/calculatorpb"
+	"github.com/sahina/cvt-demo/consumer-4/credentials"
+	"google.golang.org/grpc"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultAddr is where the producer serves gRPC in the demo.
+const DefaultAddr = "localhost:10002"
+
+// Client calls the producer's CalculatorService.
+type Client struct {
+	conn    *grpc.ClientConn
+	service calculatorpb.CalculatorServiceClient
+}
+
+// New connects to the CalculatorService at addr, over TLS when tlsConfig is
+// not nil, sending creds with every call.
+func New(addr string, tlsConfig *tls.Config, creds credentials.Credentials) (*Client, error) {
+	transport := insecure.NewCredentials()
+	if tlsConfig != nil {
+		transport = grpccredentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(transport),
+		grpc.WithUnaryInterceptor(withCredentials(creds)))
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	return &Client{conn: conn, service: calculatorpb.NewCalculatorServiceClient(conn)}, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call runs the named operation, such as "add", on x and y.
+func (c *Client) Call(ctx context.Context, operation string, x, y float64) (float64, error) {
+	req := &calculatorpb.OperationRequest{X: &x, Y: &y}
+
+	var resp *calculatorpb.OperationResponse
+	var err error
+	switch operation {
+	case "add":
+		resp, err = c.service.Add(ctx, req)
+	case "subtract":
+		resp, err = c.service.Subtract(ctx, req)
+	case "multiply":
+		resp, err = c.service.Multiply(ctx, req)
+	case "divide":
+		resp, err = c.service.Divide(ctx, req)
+	default:
+		return 0, fmt.Errorf("unknown operation %q", operation)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetResult(), nil
+}
+
+// withCredentials adds creds to the metadata of every call, under the
+// lower-case names of the headers the REST API reads them from.
+func withCredentials(creds credentials.Credentials) grpc.UnaryClientInterceptor {
+	var pairs []string
+	if creds.APIKey != "" {
+		pairs = append(pairs, strings.ToLower(credentials.APIKeyHeader), creds.APIKey)
+	}
+	if creds.Token != "" {
+		pairs = append(pairs, "authorization", "Bearer "+creds.Token)
+	}
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if len(pairs) > 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
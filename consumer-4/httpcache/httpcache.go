@@ -0,0 +1,208 @@
+// Package httpcache lets consumer-4 reuse producer results between runs.
+//
+// Calculator results carry a strong ETag and a Cache-Control max-age. The
+// transport here keeps successful GET responses on disk, one file per URL,
+// because the CLI exits after every request. A fresh entry is served without
+// contacting the producer; a stale one is revalidated with If-None-Match and
+// served from disk when the producer answers 304.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceHeader tells callers whether a response came from the cache: it is
+// Fresh for a response served without a request and Revalidated for one the
+// producer confirmed with a 304. Responses from the network do not have it.
+const SourceHeader = "X-Consumer-Cache"
+
+// Values of SourceHeader.
+const (
+	Fresh       = "fresh"
+	Revalidated = "revalidated"
+)
+
+// NewTransport returns a round tripper that caches responses from base in
+// dir, which is created if needed. Failing to read or write the cache never
+// fails a request; the request just goes to base.
+func NewTransport(base http.RoundTripper, dir string) http.RoundTripper {
+	return &transport{base: base, dir: dir}
+}
+
+type transport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+// entry is a cached response as stored on disk.
+type entry struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Expires    time.Time   `json:"expires"`
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	cached, ok := t.load(key)
+	if ok && time.Now().Before(cached.Expires) {
+		return cached.response(req, Fresh), nil
+	}
+
+	// A request with its own If-None-Match is the caller's to answer.
+	revalidating := ok && cached.Header.Get("ETag") != "" && req.Header.Get("If-None-Match") == ""
+	if revalidating {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.Header.Get("ETag"))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && revalidating {
+		resp.Body.Close()
+		for _, name := range []string{"ETag", "Cache-Control", "Expires", "Date"} {
+			if value := resp.Header.Get(name); value != "" {
+				cached.Header.Set(name, value)
+			}
+		}
+		cached.Expires = expires(cached.Header)
+		t.store(cached)
+		return cached.response(req, Revalidated), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !storable(resp.Header) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.store(&entry{
+		URL:        key,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		Expires:    expires(resp.Header),
+	})
+	return resp, nil
+}
+
+// response rebuilds the cached response for req.
+func (e *entry) response(req *http.Request, source string) *http.Response {
+	header := e.Header.Clone()
+	header.Set(SourceHeader, source)
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// storable reports whether a 200 may be cached: it must not be marked
+// no-store, and must be either revalidatable or fresh for a while.
+func storable(header http.Header) bool {
+	directives := cacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	return header.Get("ETag") != "" || maxAge(directives) > 0
+}
+
+// expires returns when a response with header stops being fresh. Responses
+// marked no-cache, or without a max-age, are stale straight away.
+func expires(header http.Header) time.Time {
+	directives := cacheControl(header)
+	if _, ok := directives["no-cache"]; ok {
+		return time.Now()
+	}
+	return time.Now().Add(maxAge(directives))
+}
+
+// cacheControl parses the Cache-Control directives of header.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func maxAge(directives map[string]string) time.Duration {
+	seconds, err := strconv.Atoi(directives["max-age"])
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// path returns the file an entry for url is stored in.
+func (t *transport) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (t *transport) load(url string) (*entry, bool) {
+	data, err := os.ReadFile(t.path(url))
+	if err != nil {
+		return nil, false
+	}
+	var e entry
+	if json.Unmarshal(data, &e) != nil || e.URL != url {
+		return nil, false
+	}
+	return &e, true
+}
+
+// store writes e through a temporary file, so a concurrent run never reads a
+// partial entry.
+func (t *transport) store(e *entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(t.dir, "entry-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), t.path(e.URL)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -4,10 +4,35 @@
 //
 //	./consumer4 add <x> <y> [--validate]
 //	./consumer4 subtract <x> <y> [--validate]
+//	./consumer4 grpc <add|subtract> <x> <y> [--validate]
+//	./consumer4 --version
 //
 // Options:
 //
 //	--validate  Enable CVT contract validation (default: off)
+//	--version   Print build, schema and CVT SDK versions as JSON and exit
+//
+// SCHEMA_PATH may be a file or an http(s) URL such as the producer's
+// /openapi.json, in which case the contract is fetched from the running service.
+//
+// Requests carry a W3C traceparent header; set OTEL_EXPORTER_OTLP_ENDPOINT to
+// export the consumer's spans alongside the producer's.
+//
+// Results are cached in CONSUMER_CACHE_DIR (by default a consumer-4 directory
+// under the user cache directory) and revalidated with the producer once
+// stale; set it to "off" to always ask the producer.
+//
+// When the producer requires authentication, set CONSUMER_API_KEY or
+// CONSUMER_TOKEN, or put them in the JSON file named by
+// CONSUMER_CREDENTIALS_FILE.
+//
+// An https PRODUCER_URL is verified against CONSUMER_TLS_CA_FILE, and
+// CONSUMER_TLS_CERT_FILE and CONSUMER_TLS_KEY_FILE hold a client certificate
+// for producers that require mutual TLS.
+//
+// The grpc command calls the producer's gRPC CalculatorService at
+// CONSUMER_GRPC_ADDR (default localhost:10002) with the same credentials and
+// TLS settings; --validate validates the equivalent REST interaction.
 package main
 
 import (
@@ -17,8 +42,11 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/sahina/cvt-demo/consumer-4/buildinfo"
 	"github.com/sahina/cvt/sdks/go/cvt"
 )
 
@@ -26,37 +54,37 @@ var (
 	producerURL = getEnv("PRODUCER_URL", "http://localhost:10001")
 	cvtAddr     = getEnv("CVT_SERVER_ADDR", "localhost:9550")
 	schemaPath  = getEnv("SCHEMA_PATH", "./calculator-api.json")
+	cacheDir    = resultCacheDir(os.Getenv("CONSUMER_CACHE_DIR"))
+
+	// client sends requests to the producer with trace context
+	client = http.DefaultClient
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Fprintln(os.Stderr, "Usage: consumer4 <add|subtract> <x> <y> [--validate]")
-		os.Exit(1)
-	}
-
-	command := os.Args[1]
-	if command != "add" && command != "subtract" {
-		fmt.Fprintf(os.Stderr, "Error: Unknown command '%s'. Use 'add' or 'subtract'.\n", command)
-		os.Exit(1)
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		printVersion()
+		return
 	}
 
-	x, err := strconv.ParseFloat(os.Args[2], 64)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error: Both arguments must be valid numbers")
-		os.Exit(1)
-	}
-	y, err := strconv.ParseFloat(os.Args[3], 64)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error: Both arguments must be valid numbers")
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "grpc" {
+		command, x, y, validate := parseArgs(os.Args[2:], "consumer4 grpc <add|subtract> <x> <y> [--validate]")
+		runGRPC(command, x, y, validate)
+		return
 	}
 
-	validate := len(os.Args) > 4 && os.Args[4] == "--validate"
+	command, x, y, validate := parseArgs(os.Args[1:], "consumer4 <add|subtract> <x> <y> [--validate]")
 
 	path := fmt.Sprintf("/%s?x=%s&y=%s", command, formatParam(x), formatParam(y))
 	url := producerURL + path
 
-	resp, err := http.Get(url) //nolint:gosec
+	var err error
+	client, err = newTracedClient(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := client.Get(url) //nolint:gosec
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -97,11 +125,52 @@ func main() {
 		os.Exit(1)
 	}
 
+	printResult(command, x, y, val)
+}
+
+// parseArgs parses "<add|subtract> <x> <y> [--validate]", exiting with usage
+// on bad arguments.
+func parseArgs(args []string, usage string) (command string, x, y float64, validate bool) {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "Usage: "+usage)
+		os.Exit(1)
+	}
+
+	command = args[0]
+	if command != "add" && command != "subtract" {
+		fmt.Fprintf(os.Stderr, "Error: Unknown command '%s'. Use 'add' or 'subtract'.\n", command)
+		os.Exit(1)
+	}
+
+	x, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Both arguments must be valid numbers")
+		os.Exit(1)
+	}
+	y, err = strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Both arguments must be valid numbers")
+		os.Exit(1)
+	}
+
+	return command, x, y, len(args) > 3 && args[3] == "--validate"
+}
+
+// printResult prints e.g. "5 + 3 = 8".
+func printResult(command string, x, y, result float64) {
 	op := "+"
 	if command == "subtract" {
 		op = "-"
 	}
-	fmt.Printf("%s %s %s = %s\n", formatParam(x), op, formatParam(y), formatNumber(val))
+	fmt.Printf("%s %s %s = %s\n", formatParam(x), op, formatParam(y), formatNumber(result))
+}
+
+// printVersion writes the build information as JSON. The schema version is
+// read from SCHEMA_PATH when it is a local file; URLs are not fetched.
+func printVersion() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(buildinfo.Read(buildinfo.SchemaVersion(schemaPath)))
 }
 
 // runValidation validates the interaction with CVT.
@@ -120,8 +189,14 @@ func doValidation(path string, statusCode int, body []byte) error {
 	}
 	defer validator.Close()
 
+	path, cleanup, err := resolveSchema(schemaPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	ctx := context.Background()
-	if err := validator.RegisterSchema(ctx, "calculator-api", schemaPath); err != nil {
+	if err := validator.RegisterSchema(ctx, "calculator-api", path); err != nil {
 		return err
 	}
 
@@ -146,6 +221,41 @@ func doValidation(path string, statusCode int, body []byte) error {
 	return nil
 }
 
+// resolveSchema returns a local path for the schema at location. URLs are
+// downloaded to a temporary file, which cleanup removes.
+func resolveSchema(location string) (path string, cleanup func(), err error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return location, func() {}, nil
+	}
+
+	resp, err := client.Get(location) //nolint:gosec
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch schema: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to fetch schema: HTTP %d", resp.StatusCode)
+	}
+
+	f, err := os.CreateTemp("", "calculator-api-*.json")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to fetch schema: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
 func formatParam(v float64) string {
 	if v == float64(int64(v)) {
 		return strconv.FormatInt(int64(v), 10)
@@ -160,6 +270,22 @@ func formatNumber(v float64) string {
 	return strconv.FormatFloat(v, 'f', -1, 64)
 }
 
+// resultCacheDir returns the directory results are cached in for the
+// CONSUMER_CACHE_DIR value dir, or "" if caching is off.
+func resultCacheDir(dir string) string {
+	switch dir {
+	case "off":
+		return ""
+	case "":
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(base, "consumer-4")
+	}
+	return dir
+}
+
 func getEnv(key, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/consumer-4/backoff"
+)
+
+// newLimitedServer answers the first `limited` requests with 429 and the
+// given Retry-After, and later ones with a result.
+func newLimitedServer(t *testing.T, limited int32, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if requests.Add(1) <= limited {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error":"Rate limit exceeded for add; retry in 1 seconds"}`)
+			return
+		}
+		io.WriteString(w, `{"result":8}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// TestBackoff_RetriesTooManyRequests verifies 429s are retried until the
+// producer answers, and that retries stop after the configured number.
+func TestBackoff_RetriesTooManyRequests(t *testing.T) {
+	testCases := []struct {
+		name           string
+		limited        int32
+		retryAfter     string
+		expectedStatus int
+		expectedCalls  int32
+	}{
+		{"delay-seconds", 2, "0", http.StatusOK, 3},
+		{"HTTP date", 1, time.Now().Add(-time.Second).UTC().Format(http.TimeFormat), http.StatusOK, 2},
+		{"retries exhausted", 5, "0", http.StatusTooManyRequests, 4},
+		{"delay above maximum", 1, "120", http.StatusTooManyRequests, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := newLimitedServer(t, tc.limited, tc.retryAfter)
+			client := &http.Client{Transport: backoff.NewTransport(http.DefaultTransport, 3, time.Minute)}
+
+			resp, err := client.Get(server.URL + "/add?x=5&y=3")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			if n := requests.Load(); n != tc.expectedCalls {
+				t.Errorf("Expected %d requests, got %d", tc.expectedCalls, n)
+			}
+			if body, _ := io.ReadAll(resp.Body); len(body) == 0 {
+				t.Error("Expected the last response's body to be readable")
+			}
+		})
+	}
+}
+
+// TestBackoff_ServiceUnavailable verifies 503s from load shedding, which
+// carry a Retry-After, are retried, and other 503s are not.
+func TestBackoff_ServiceUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		retryAfter    string
+		expectedCalls int32
+	}{
+		{"shed", "0", 2},
+		{"unavailable", "", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			t.Cleanup(server.Close)
+			client := &http.Client{Transport: backoff.NewTransport(http.DefaultTransport, 3, time.Minute)}
+
+			resp, err := client.Get(server.URL + "/divide?x=5&y=3")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if n := requests.Load(); n != tc.expectedCalls {
+				t.Errorf("Expected %d requests, got %d", tc.expectedCalls, n)
+			}
+		})
+	}
+}
+
+// TestBackoff_Cancellation verifies a wait ends when the request is cancelled.
+func TestBackoff_Cancellation(t *testing.T) {
+	server, requests := newLimitedServer(t, 1, "30")
+	client := &http.Client{Transport: backoff.NewTransport(http.DefaultTransport, 3, time.Minute)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/add?x=5&y=3", nil)
+
+	start := time.Now()
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("Expected the cancelled request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wait to end with the context, took %v", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+}
+
+// TestBackoff_IdempotencyKey verifies every attempt of a retried POST carries
+// the same Idempotency-Key, that a caller's own key is kept, and that GETs
+// get none.
+func TestBackoff_IdempotencyKey(t *testing.T) {
+	var keys []string
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(backoff.IdempotencyKeyHeader))
+		if requests.Add(1)%2 == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := &http.Client{Transport: backoff.NewTransport(http.DefaultTransport, 3, time.Minute)}
+
+	client.Post(server.URL+"/jobs", "application/json", strings.NewReader(`{"x":1}`))
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Expected both attempts to carry the same key, got %q", keys)
+	}
+
+	keys = nil
+	req, _ := http.NewRequest("POST", server.URL+"/jobs", strings.NewReader(`{"x":1}`))
+	req.Header.Set(backoff.IdempotencyKeyHeader, "mine")
+	client.Do(req)
+	if len(keys) != 2 || keys[0] != "mine" || keys[1] != "mine" {
+		t.Errorf("Expected the caller's key on both attempts, got %q", keys)
+	}
+
+	keys = nil
+	client.Get(server.URL + "/add?x=5&y=3")
+	if len(keys) != 2 || keys[0] != "" || keys[1] != "" {
+		t.Errorf("Expected GETs to carry no key, got %q", keys)
+	}
+}
+
+// TestBackoff_RetryAfter verifies Retry-After parsing.
+func TestBackoff_RetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"7", 7 * time.Second, true},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	} {
+		delay, ok := backoff.RetryAfter(tc.value, now)
+		if delay != tc.delay || ok != tc.ok {
+			t.Errorf("RetryAfter(%q) = %v, %v; want %v, %v", tc.value, delay, ok, tc.delay, tc.ok)
+		}
+	}
+}
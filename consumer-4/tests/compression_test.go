@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/sahina/cvt-demo/consumer-4/compression"
+	"github.com/sahina/cvt/sdks/go/cvt"
+)
+
+const compressedBody = `{"result":8}`
+
+// newEncodingServer answers every request with compressedBody, encoded with
+// the given Content-Encoding, and records the Accept-Encoding it was sent.
+func newEncodingServer(t *testing.T, encoding string, acceptEncoding *string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+
+		var body io.WriteCloser
+		switch encoding {
+		case "gzip":
+			body = gzip.NewWriter(w)
+		case "br":
+			body = brotli.NewWriter(w)
+		}
+		if body == nil {
+			io.WriteString(w, compressedBody)
+			return
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		io.WriteString(body, compressedBody)
+		body.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestCompression_DecodesResponses verifies the client asks for brotli and
+// gzip and hands callers the decoded JSON whichever the producer chose.
+func TestCompression_DecodesResponses(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip", ""} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			var acceptEncoding string
+			server := newEncodingServer(t, encoding, &acceptEncoding)
+			client := &http.Client{Transport: compression.NewTransport(http.DefaultTransport)}
+
+			resp, err := client.Get(server.URL + "/add?x=5&y=3")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if acceptEncoding != compression.AcceptEncoding {
+				t.Errorf("Expected Accept-Encoding %q, got %q", compression.AcceptEncoding, acceptEncoding)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != "" {
+				t.Errorf("Expected Content-Encoding to be removed, got %q", got)
+			}
+			if resp.Uncompressed != (encoding != "") {
+				t.Errorf("Expected Uncompressed %v, got %v", encoding != "", resp.Uncompressed)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if string(body) != compressedBody {
+				t.Errorf("Expected %s, got %q", compressedBody, body)
+			}
+		})
+	}
+}
+
+// TestCompression_CallerAcceptEncoding verifies a request that sets its own
+// Accept-Encoding gets the response bytes untouched.
+func TestCompression_CallerAcceptEncoding(t *testing.T) {
+	var acceptEncoding string
+	server := newEncodingServer(t, "gzip", &acceptEncoding)
+	client := &http.Client{Transport: compression.NewTransport(http.DefaultTransport)}
+
+	req, _ := http.NewRequest("GET", server.URL+"/add?x=5&y=3", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip to be kept, got %q", got)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Expected a gzip body: %v", err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != compressedBody {
+		t.Errorf("Expected %s, got %q", compressedBody, body)
+	}
+}
+
+// TestManual_CompressedAddValidation validates a compressed add response from
+// the real producer, started with COMPRESSION_MIN_SIZE=0 so that even small
+// results are compressed.
+func TestManual_CompressedAddValidation(t *testing.T) {
+	config := getTestConfig(t)
+	validator := newTestValidator(t, config)
+	defer validator.Close()
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: compression.NewTransport(http.DefaultTransport)}
+	resp, err := client.Get(fmt.Sprintf("%s/add?x=5&y=3", config.ProducerURL))
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if !resp.Uncompressed {
+		t.Errorf("Expected a compressed response; is the producer running with COMPRESSION_MIN_SIZE=0?")
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	result, err := validator.Validate(context.Background(),
+		cvt.ValidationRequest{Method: "GET", Path: "/add?x=5&y=3", Headers: map[string]string{}},
+		cvt.ValidationResponse{StatusCode: resp.StatusCode, Headers: headersToMap(resp.Header), Body: body},
+	)
+	if err != nil {
+		t.Fatalf("Validation error: %v", err)
+	}
+	if !result.Valid {
+		t.Errorf("Expected valid response: %v", result.Errors)
+	}
+	if val, ok := body["result"].(float64); !ok || val != 8 {
+		t.Errorf("Expected result=8, got: %v", body["result"])
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sahina/cvt-demo/consumer-4/credentials"
+)
+
+// TestCredentials_Load verifies credentials are read from the file and that
+// the environment overrides it.
+func TestCredentials_Load(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(file, []byte(`{"apiKey":"file-key","token":"file-token"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		expected credentials.Credentials
+		wantErr  bool
+	}{
+		{"file", map[string]string{"CONSUMER_CREDENTIALS_FILE": file},
+			credentials.Credentials{APIKey: "file-key", Token: "file-token"}, false},
+		{"environment wins", map[string]string{"CONSUMER_CREDENTIALS_FILE": file, "CONSUMER_API_KEY": "env-key"},
+			credentials.Credentials{APIKey: "env-key", Token: "file-token"}, false},
+		{"environment only", map[string]string{"CONSUMER_TOKEN": "env-token"},
+			credentials.Credentials{Token: "env-token"}, false},
+		{"missing default file", nil, credentials.Credentials{}, false},
+		{"missing named file", map[string]string{"CONSUMER_CREDENTIALS_FILE": file + ".missing"},
+			credentials.Credentials{}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			for _, key := range []string{"CONSUMER_CREDENTIALS_FILE", "CONSUMER_API_KEY", "CONSUMER_TOKEN"} {
+				t.Setenv(key, tc.env[key])
+			}
+
+			creds, err := credentials.Load()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if creds != tc.expected {
+				t.Errorf("Load() = %+v, want %+v", creds, tc.expected)
+			}
+		})
+	}
+}
+
+// TestCredentials_Transport verifies credentials are sent to the producer
+// only, without overriding headers the caller set.
+func TestCredentials_Transport(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result":8}`))
+	}))
+	defer server.Close()
+
+	producer, _ := url.Parse(server.URL)
+	creds := credentials.Credentials{APIKey: "key-1", Token: "token-1"}
+
+	testCases := []struct {
+		name          string
+		host          string
+		header        http.Header
+		expectedKey   string
+		expectedToken string
+	}{
+		{"producer", producer.Host, nil, "key-1", "Bearer token-1"},
+		{"caller headers kept", producer.Host, http.Header{"X-Api-Key": {"mine"}}, "mine", "Bearer token-1"},
+		{"other host", "elsewhere:80", nil, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: credentials.NewTransport(http.DefaultTransport, creds, tc.host)}
+			req, _ := http.NewRequest("GET", server.URL+"/add?x=5&y=3", nil)
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if got := received.Get(credentials.APIKeyHeader); got != tc.expectedKey {
+				t.Errorf("Expected X-API-Key %q, got %q", tc.expectedKey, got)
+			}
+			if got := received.Get("Authorization"); got != tc.expectedToken {
+				t.Errorf("Expected Authorization %q, got %q", tc.expectedToken, got)
+			}
+			if len(tc.header) == 0 && req.Header.Get(credentials.APIKeyHeader) != "" {
+				t.Error("Expected the caller's request not to be modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sahina/cvt-demo/consumer-4/calculatorpb"
+	"github.com/sahina/cvt-demo/consumer-4/credentials"
+	"github.com/sahina/cvt-demo/consumer-4/grpcclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCalculator adds and subtracts, rejects a missing operand like the
+// producer does, and records the metadata of the last call.
+type fakeCalculator struct {
+	calculatorpb.UnimplementedCalculatorServiceServer
+	metadata metadata.MD
+}
+
+func (f *fakeCalculator) Add(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	f.metadata, _ = metadata.FromIncomingContext(ctx)
+	if req.X == nil || req.Y == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing required parameters 'x' and 'y'")
+	}
+	return &calculatorpb.OperationResponse{Result: req.GetX() + req.GetY()}, nil
+}
+
+func (f *fakeCalculator) Subtract(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	f.metadata, _ = metadata.FromIncomingContext(ctx)
+	return nil, status.Error(codes.PermissionDenied, "dashboard may not call subtract")
+}
+
+// startFakeCalculator serves a fakeCalculator on a loopback port.
+func startFakeCalculator(t *testing.T) (*fakeCalculator, string) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeCalculator{}
+	server := grpc.NewServer()
+	calculatorpb.RegisterCalculatorServiceServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return fake, lis.Addr().String()
+}
+
+// TestGRPCClient_Call verifies results and status errors come back from the
+// CalculatorService.
+func TestGRPCClient_Call(t *testing.T) {
+	_, addr := startFakeCalculator(t)
+	client, err := grpcclient.New(addr, nil, credentials.Credentials{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	result, err := client.Call(ctx, "add", 5, 3)
+	if err != nil || result != 8 {
+		t.Errorf("Expected 8, got %v (%v)", result, err)
+	}
+
+	_, err = client.Call(ctx, "subtract", 5, 3)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected %v, got %v", codes.PermissionDenied, err)
+	}
+
+	if _, err := client.Call(ctx, "modulo", 5, 3); err == nil {
+		t.Error("Expected an unknown operation to fail")
+	}
+}
+
+// TestGRPCClient_Credentials verifies credentials are sent as the metadata
+// the producer reads, and nothing is sent without them.
+func TestGRPCClient_Credentials(t *testing.T) {
+	testCases := []struct {
+		name          string
+		creds         credentials.Credentials
+		expectedKey   string
+		expectedToken string
+	}{
+		{"none", credentials.Credentials{}, "", ""},
+		{"api key", credentials.Credentials{APIKey: "key-ci"}, "key-ci", ""},
+		{"token", credentials.Credentials{Token: "abc"}, "", "Bearer abc"},
+		{"both", credentials.Credentials{APIKey: "key-ci", Token: "abc"}, "key-ci", "Bearer abc"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, addr := startFakeCalculator(t)
+			client, err := grpcclient.New(addr, nil, tc.creds)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer client.Close()
+
+			if _, err := client.Call(context.Background(), "add", 1, 2); err != nil {
+				t.Fatalf("Call failed: %v", err)
+			}
+			if got := first(fake.metadata.Get("x-api-key")); got != tc.expectedKey {
+				t.Errorf("Expected x-api-key %q, got %q", tc.expectedKey, got)
+			}
+			if got := first(fake.metadata.Get("authorization")); got != tc.expectedToken {
+				t.Errorf("Expected authorization %q, got %q", tc.expectedToken, got)
+			}
+		})
+	}
+}
+
+// first returns the first of values, or "".
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
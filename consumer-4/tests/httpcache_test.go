@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sahina/cvt-demo/consumer-4/httpcache"
+)
+
+// cachingServer answers like the producer: a result with a strong ETag and
+// the given Cache-Control, or a 304 when If-None-Match matches.
+type cachingServer struct {
+	*httptest.Server
+	cacheControl string
+	etag         atomic.Value // string
+	body         atomic.Value // string
+	requests     atomic.Int32
+	conditional  atomic.Int32 // requests that carried If-None-Match
+}
+
+func newCachingServer(t *testing.T, cacheControl string) *cachingServer {
+	t.Helper()
+
+	s := &cachingServer{cacheControl: cacheControl}
+	s.etag.Store(`"result-8"`)
+	s.body.Store(`{"result":8}`)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		etag := s.etag.Load().(string)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", s.cacheControl)
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			s.conditional.Add(1)
+			if inm == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, s.body.Load().(string))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// cachedGet fetches url through a fresh caching transport on dir, as each
+// consumer-4 run does, and returns the body and cache source.
+func cachedGet(t *testing.T, dir, url string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{Transport: httpcache.NewTransport(http.DefaultTransport, dir)}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	return string(body), resp.Header.Get(httpcache.SourceHeader)
+}
+
+// TestHTTPCache_FreshResponses verifies a fresh result is served from disk by
+// a later run without contacting the producer.
+func TestHTTPCache_FreshResponses(t *testing.T) {
+	server := newCachingServer(t, "public, max-age=3600")
+	dir := t.TempDir()
+
+	body, source := cachedGet(t, dir, server.URL+"/add?x=5&y=3")
+	if source != "" {
+		t.Errorf("Expected the first response from the network, got source %q", source)
+	}
+	cached, source := cachedGet(t, dir, server.URL+"/add?x=5&y=3")
+	if source != httpcache.Fresh {
+		t.Errorf("Expected source %q, got %q", httpcache.Fresh, source)
+	}
+	if cached != body {
+		t.Errorf("Expected cached body %q, got %q", body, cached)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("Expected 1 request to the producer, got %d", n)
+	}
+
+	cachedGet(t, dir, server.URL+"/add?x=5&y=4")
+	if n := server.requests.Load(); n != 2 {
+		t.Errorf("Expected a different URL to reach the producer, got %d requests", n)
+	}
+}
+
+// TestHTTPCache_Revalidation verifies a stale result is revalidated with
+// If-None-Match, served from disk on a 304 and replaced when it changed.
+func TestHTTPCache_Revalidation(t *testing.T) {
+	server := newCachingServer(t, "no-cache")
+	dir := t.TempDir()
+	url := server.URL + "/add?x=5&y=3"
+
+	cachedGet(t, dir, url)
+	body, source := cachedGet(t, dir, url)
+	if source != httpcache.Revalidated {
+		t.Errorf("Expected source %q, got %q", httpcache.Revalidated, source)
+	}
+	if body != `{"result":8}` {
+		t.Errorf("Expected the cached body, got %q", body)
+	}
+	if n := server.conditional.Load(); n != 1 {
+		t.Errorf("Expected 1 conditional request, got %d", n)
+	}
+
+	server.etag.Store(`"result-9"`)
+	server.body.Store(`{"result":9}`)
+	if body, source := cachedGet(t, dir, url); body != `{"result":9}` || source != "" {
+		t.Errorf("Expected the changed result from the network, got %q from %q", body, source)
+	}
+	if body, source := cachedGet(t, dir, url); body != `{"result":9}` || source != httpcache.Revalidated {
+		t.Errorf("Expected the changed result to be cached, got %q from %q", body, source)
+	}
+}
+
+// TestHTTPCache_NotStored verifies no-store responses and errors are not
+// cached.
+func TestHTTPCache_NotStored(t *testing.T) {
+	server := newCachingServer(t, "no-store")
+	dir := t.TempDir()
+
+	cachedGet(t, dir, server.URL+"/add?x=5&y=3")
+	if _, source := cachedGet(t, dir, server.URL+"/add?x=5&y=3"); source != "" {
+		t.Errorf("Expected a no-store response not to be cached, got source %q", source)
+	}
+	if n := server.conditional.Load(); n != 0 {
+		t.Errorf("Expected no conditional requests, got %d", n)
+	}
+
+	errors := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"error"`)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.Error(w, `{"error":"Division by zero is not allowed"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(errors.Close)
+	client := &http.Client{Transport: httpcache.NewTransport(http.DefaultTransport, dir)}
+	for range 2 {
+		resp, err := client.Get(errors.URL + "/divide?x=1&y=0")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get(httpcache.SourceHeader) != "" {
+			t.Errorf("Expected an error response not to be cached")
+		}
+	}
+}
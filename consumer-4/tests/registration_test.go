@@ -28,7 +28,7 @@ func TestRegistration_CaptureInteractionsForAutoRegistration(t *testing.T) {
 		ConsumerID:      config.ConsumerID,
 		ConsumerVersion: config.Version,
 		Environment:     config.Environment,
-		SchemaVersion:   "1.0.0",
+		SchemaVersion:   config.SchemaVersion,
 		SchemaID:        "calculator-api",
 	})
 	if err != nil {
@@ -66,7 +66,7 @@ func TestRegistration_RegisterConsumerFromInteractions(t *testing.T) {
 		ConsumerID:      config.ConsumerID,
 		ConsumerVersion: config.Version,
 		Environment:     config.Environment,
-		SchemaVersion:   "1.0.0",
+		SchemaVersion:   config.SchemaVersion,
 		SchemaID:        "calculator-api",
 	})
 	if err != nil {
@@ -93,7 +93,7 @@ func TestRegistration_RegisterConsumerWithExplicitEndpoints(t *testing.T) {
 		ConsumerID:      config.ConsumerID,
 		ConsumerVersion: config.Version,
 		SchemaID:        "calculator-api",
-		SchemaVersion:   "1.0.0",
+		SchemaVersion:   config.SchemaVersion,
 		Environment:     config.Environment,
 		UsedEndpoints: []cvt.EndpointUsage{
 			{Method: "GET", Path: "/add", UsedFields: []string{"result"}},
@@ -125,7 +125,7 @@ func TestRegistration_ListRegisteredConsumers(t *testing.T) {
 		ConsumerID:      config.ConsumerID,
 		ConsumerVersion: config.Version,
 		SchemaID:        "calculator-api",
-		SchemaVersion:   "1.0.0",
+		SchemaVersion:   config.SchemaVersion,
 		Environment:     config.Environment,
 		UsedEndpoints: []cvt.EndpointUsage{
 			{Method: "GET", Path: "/add", UsedFields: []string{"result"}},
@@ -170,7 +170,7 @@ func TestRegistration_CanIDeploy(t *testing.T) {
 		ConsumerID:      config.ConsumerID,
 		ConsumerVersion: config.Version,
 		SchemaID:        "calculator-api",
-		SchemaVersion:   "1.0.0",
+		SchemaVersion:   config.SchemaVersion,
 		Environment:     config.Environment,
 		UsedEndpoints: []cvt.EndpointUsage{
 			{Method: "GET", Path: "/add", UsedFields: []string{"result"}},
@@ -181,7 +181,7 @@ func TestRegistration_CanIDeploy(t *testing.T) {
 		t.Fatalf("RegisterConsumer failed: %v", err)
 	}
 
-	result, err := validator.CanIDeploy(ctx, "calculator-api", "1.0.0", config.Environment)
+	result, err := validator.CanIDeploy(ctx, "calculator-api", config.SchemaVersion, config.Environment)
 	if err != nil {
 		t.Fatalf("CanIDeploy failed: %v", err)
 	}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/sahina/cvt-demo/consumer-4/buildinfo"
 	"github.com/sahina/cvt/sdks/go/cvt"
 )
 
@@ -17,6 +18,7 @@ type testConfig struct {
 	SchemaPath    string
 	ConsumerID    string
 	Version       string
+	SchemaVersion string
 	Environment   string
 }
 
@@ -44,12 +46,19 @@ func getTestConfig(t *testing.T) *testConfig {
 		env = "demo"
 	}
 
+	// Register with the real build and schema versions rather than constants
+	schemaVersion := buildinfo.SchemaVersion(schemaPath)
+	if schemaVersion == "" {
+		t.Fatalf("Failed to read info.version from %s", schemaPath)
+	}
+
 	return &testConfig{
 		CVTServerAddr: cvtAddr,
 		ProducerURL:   producerURL,
 		SchemaPath:    schemaPath,
 		ConsumerID:    "consumer-4",
-		Version:       "1.0.0",
+		Version:       buildinfo.Read(schemaVersion).ConsumerVersion("1.0.0"),
+		SchemaVersion: schemaVersion,
 		Environment:   env,
 	}
 }
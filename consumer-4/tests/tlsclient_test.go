@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/consumer-4/tlsclient"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir and returns the certificate and the file paths.
+func writeClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "consumer-4"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cert, certFile, keyFile
+}
+
+// TestTLSClient_MutualTLS verifies consumer-4 trusts the configured CA and
+// presents its client certificate.
+func TestTLSClient_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"result":8}`)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	testCases := []struct {
+		name     string
+		caFile   string
+		certFile string
+		keyFile  string
+		succeeds bool
+	}{
+		{"CA and client certificate", caFile, certFile, keyFile, true},
+		{"no client certificate", caFile, "", "", false},
+		{"untrusted server", "", certFile, keyFile, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := tlsclient.New(tc.caFile, tc.certFile, tc.keyFile)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			client := &http.Client{Transport: tlsclient.NewTransport(config)}
+
+			resp, err := client.Get(server.URL + "/add?x=5&y=3")
+			if !tc.succeeds {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("Expected the request to fail, got status %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestTLSClient_Config verifies the CONSUMER_TLS_* settings.
+func TestTLSClient_Config(t *testing.T) {
+	if config, err := tlsclient.New("", "", ""); config != nil || err != nil {
+		t.Errorf("Expected no configuration without settings, got %v, %v", config, err)
+	}
+	if tlsclient.NewTransport(nil) != http.DefaultTransport {
+		t.Error("Expected the default transport without a configuration")
+	}
+
+	dir := t.TempDir()
+	_, certFile, _ := writeClientCertificate(t, dir)
+	for name, files := range map[string][3]string{
+		"certificate without key": {"", certFile, ""},
+		"missing CA file":         {filepath.Join(dir, "missing.pem"), "", ""},
+		"CA file without PEM":     {writeFile(t, dir, "empty.pem", "nothing here"), "", ""},
+	} {
+		if _, err := tlsclient.New(files[0], files[1], files[2]); err == nil {
+			t.Errorf("%s: expected New to fail", name)
+		}
+	}
+}
+
+// writeFile writes data to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,65 @@
+// Package tlsclient lets consumer-4 reach a producer that serves TLS, or
+// requires client certificates (mutual TLS).
+//
+// The CA to trust comes from CONSUMER_TLS_CA_FILE, which may be the CA the
+// producer's cmd/gencerts writes; without it the system roots are used. A
+// client certificate comes from CONSUMER_TLS_CERT_FILE and
+// CONSUMER_TLS_KEY_FILE.
+package tlsclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Load builds the TLS configuration from the environment, or returns nil
+// when no CONSUMER_TLS_* variable is set.
+func Load() (*tls.Config, error) {
+	return New(os.Getenv("CONSUMER_TLS_CA_FILE"), os.Getenv("CONSUMER_TLS_CERT_FILE"), os.Getenv("CONSUMER_TLS_KEY_FILE"))
+}
+
+// New builds a TLS configuration trusting the CAs in caFile and presenting
+// the certificate in certFile and keyFile. Empty names are skipped; nil is
+// returned when all are empty.
+func New(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("CONSUMER_TLS_CERT_FILE and CONSUMER_TLS_KEY_FILE must be set together")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificates: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificates", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewTransport returns a copy of http.DefaultTransport that uses config, or
+// http.DefaultTransport itself when config is nil.
+func NewTransport(config *tls.Config) http.RoundTripper {
+	if config == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
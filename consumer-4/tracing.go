@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/sahina/cvt-demo/consumer-4/backoff"
+	"github.com/sahina/cvt-demo/consumer-4/compression"
+	"github.com/sahina/cvt-demo/consumer-4/credentials"
+	"github.com/sahina/cvt-demo/consumer-4/httpcache"
+	"github.com/sahina/cvt-demo/consumer-4/tlsclient"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTracedClient returns an HTTP client that starts a client span for every
+// request and sends its context as a traceparent header, so the producer's
+// spans join the consumer's trace. Spans are exported over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set; they are exported synchronously because
+// the CLI exits right after its request. Compressed responses are decoded
+// before the caller sees them, 429s are retried after their Retry-After,
+// credentials are added to requests for the producer, and results are cached
+// in cacheDir unless it is empty. CONSUMER_TLS_* configure HTTPS connections.
+func newTracedClient(ctx context.Context) (*http.Client, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("consumer-4"),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	creds, err := credentials.Load()
+	if err != nil {
+		return nil, err
+	}
+	producer, err := url.Parse(producerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse PRODUCER_URL: %w", err)
+	}
+	tlsConfig, err := tlsclient.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	base := backoff.NewTransport(compression.NewTransport(tlsclient.NewTransport(tlsConfig)),
+		backoff.DefaultRetries, backoff.DefaultMaxDelay)
+	if !creds.Empty() {
+		base = credentials.NewTransport(base, creds, producer.Host)
+	}
+	if cacheDir != "" {
+		base = httpcache.NewTransport(base, cacheDir)
+	}
+
+	return &http.Client{Transport: &tracingTransport{
+		base:       base,
+		tracer:     provider.Tracer("github.com/sahina/cvt-demo/consumer-4"),
+		propagator: propagation.TraceContext{},
+	}}, nil
+}
+
+// consumerID names consumer-4 to the producer in X-Consumer-ID, which the
+// producer's fault injection can be scoped to.
+const consumerID = "consumer-4"
+
+// tracingTransport injects traceparent and X-Consumer-ID into outbound
+// requests.
+type tracingTransport struct {
+	base       http.RoundTripper
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+		),
+	)
+	defer span.End()
+
+	req = req.Clone(ctx)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Set("X-Consumer-ID", consumerID)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	return resp, nil
+}
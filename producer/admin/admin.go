@@ -0,0 +1,46 @@
+// Package admin serves the producer's operational endpoints on a listener of
+// their own.
+//
+// Health and readiness probes, metrics scrapes, profiles and admin actions
+// are for the platform running the producer, not for its consumers. Keeping
+// them off the public listener leaves it with only the routes the contract
+// governs, and lets the admin listener stay off the public network: by
+// default it only listens on the loopback interface.
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// DefaultAddr is where the admin listener listens unless ADMIN_ADDR is set:
+// the loopback interface only, as it serves plain HTTP, the profiles and
+// admin actions.
+const DefaultAddr = "127.0.0.1:10003"
+
+// PprofPath is where the runtime profiles are served.
+const PprofPath = "/debug/pprof/"
+
+// NewMux returns the mux of the admin listener, serving routes and the pprof
+// profiles, and answering unmatched requests with a JSON 404 or 405 like the
+// public listener. Callers register further admin endpoints on it before
+// serving.
+func NewMux(routes ...handlers.Route) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	}
+
+	// pprof.Index also serves the named profiles, e.g. /debug/pprof/heap
+	mux.HandleFunc("GET "+PprofPath, pprof.Index)
+	mux.HandleFunc("GET "+PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET "+PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc("GET "+PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc("POST "+PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc("GET "+PprofPath+"trace", pprof.Trace)
+
+	handlers.RegisterFallback(mux)
+	return mux
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// APIKeyHeader carries a static API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates requests by a static key in the X-API-Key header.
+type APIKeys struct {
+	// keys are held by digest, so looking one up does not compare secrets
+	// byte by byte.
+	keys map[[sha256.Size]byte]Principal
+}
+
+// LoadAPIKeys reads an API key file, as named by AUTH_API_KEYS_FILE.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read API keys: %w", err)
+	}
+	keys, err := ParseAPIKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParseAPIKeys parses API keys, one per line as
+//
+//	<client> <key> [<operationId> ...]
+//
+// where the operationIds restrict the key to those operations. Blank lines
+// and lines starting with # are ignored.
+func ParseAPIKeys(data []byte) (*APIKeys, error) {
+	keys := &APIKeys{keys: make(map[[sha256.Size]byte]Principal)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want <client> <key> [<operationId> ...]", line)
+		}
+
+		digest := sha256.Sum256([]byte(fields[1]))
+		if _, ok := keys.keys[digest]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key for %s", line, fields[0])
+		}
+		keys.keys[digest] = Principal{Name: fields[0], Scheme: APIKeyScheme, Operations: splitFields(strings.Join(fields[2:], " "))}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys.keys) == 0 {
+		return nil, fmt.Errorf("no API keys")
+	}
+	return keys, nil
+}
+
+// Scheme returns APIKeyScheme.
+func (k *APIKeys) Scheme() string { return APIKeyScheme }
+
+// Challenge names the header the key is expected in.
+func (k *APIKeys) Challenge(invalid bool) string {
+	return fmt.Sprintf(`ApiKey realm="calculator-api", header=%q`, APIKeyHeader)
+}
+
+// Authenticate looks up the request's API key.
+func (k *APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	p, ok := k.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, fmt.Errorf("unknown API key")
+	}
+	return p, nil
+}
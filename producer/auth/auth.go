@@ -0,0 +1,270 @@
+// Package auth authenticates callers of the Calculator's operations.
+//
+// Which operations need credentials, and which kinds they accept, comes from
+// the security requirements in the contract: an operation without any is
+// public, as /version is. Each security scheme is served by an Authenticator;
+// the producer ships static API keys (APIKeys) and HS256/RS256 JWTs (JWT).
+// Requests without valid credentials get a 401 with a WWW-Authenticate
+// challenge per scheme, and callers whose credentials do not cover the
+// operation get a 403. Both are documented component responses, and the
+// middleware sits inside the CVT middleware so they are validated.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// Security scheme, component response and component header names in the
+// OpenAPI document.
+const (
+	APIKeyScheme             = "ApiKeyAuth"
+	BearerScheme             = "BearerAuth"
+	UnauthorizedResponse     = "Unauthorized"
+	ForbiddenResponse        = "Forbidden"
+	WWWAuthenticateComponent = "WWWAuthenticate"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials for its scheme.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Name is the API key's client name or the token's subject.
+	Name string
+	// Scheme is the security scheme that authenticated the caller.
+	Scheme string
+	// Operations lists the operationIds the caller may call; nil allows all.
+	Operations []string
+}
+
+// Allows reports whether p may call operation.
+func (p Principal) Allows(operation string) bool {
+	return p.Operations == nil || slices.Contains(p.Operations, operation)
+}
+
+// Authenticator verifies the credentials of one security scheme.
+type Authenticator interface {
+	// Scheme is the name of the security scheme in the contract.
+	Scheme() string
+	// Challenge is the WWW-Authenticate challenge sent with a 401. invalid
+	// tells whether the request carried credentials for this scheme.
+	Challenge(invalid bool) string
+	// Authenticate returns the caller r was sent by, ErrNoCredentials if r
+	// carries no credentials for the scheme, or why they are not valid.
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Config selects the authenticators, as read from AUTH_API_KEYS_FILE and the
+// AUTH_JWT_* variables.
+type Config struct {
+	APIKeysFile string
+	JWT         JWTConfig
+}
+
+// Load creates the authenticators config enables. None are returned when
+// authentication is not configured, and the producer stays open.
+func Load(config Config) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if config.APIKeysFile != "" {
+		keys, err := LoadAPIKeys(config.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if config.JWT.Enabled() {
+		j, err := LoadJWT(config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, j)
+	}
+	return authenticators, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the caller authenticated for the request ctx belongs
+// to, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// Middleware authenticates requests to operations with security
+// requirements in spec, using the authenticator for each required scheme.
+// Requirements naming a scheme without an authenticator cannot be met.
+func Middleware(spec *contract.Spec, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	byScheme := make(map[string]Authenticator, len(authenticators))
+	for _, a := range authenticators {
+		byScheme[a.Scheme()] = a
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op, ok := spec.Operation(r.Method, r.URL.Path)
+			if !ok || len(op.Security) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			offered := offeredAuthenticators(op.Security, byScheme)
+			principal, err := authenticate(r, offered)
+			if err != nil {
+				unauthorized(w, r, offered, err)
+				return
+			}
+			if !principal.Allows(op.OperationID) {
+				forbidden(w, r, principal, op.OperationID)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// Require authenticates every request with one of authenticators and lets
+// through only callers whose credentials explicitly grant grant. Unlike an
+// operation, which unrestricted credentials may call, grant must be listed
+// among the API key's operationIds or the token's scope. It guards endpoints
+// the contract does not describe, such as admin actions.
+func Require(grant string, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticate(r, authenticators)
+			if err != nil {
+				unauthorized(w, r, authenticators, err)
+				return
+			}
+			if !slices.Contains(principal.Operations, grant) {
+				forbidden(w, r, principal, grant)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// offeredAuthenticators returns the authenticators that can satisfy one of
+// the requirements on their own, in the contract's order.
+func offeredAuthenticators(requirements []contract.SecurityRequirement, byScheme map[string]Authenticator) []Authenticator {
+	var offered []Authenticator
+	for _, requirement := range requirements {
+		if len(requirement) != 1 {
+			continue
+		}
+		for scheme := range requirement {
+			if a, ok := byScheme[scheme]; ok && !slices.Contains(offered, a) {
+				offered = append(offered, a)
+			}
+		}
+	}
+	return offered
+}
+
+// Authenticate returns the caller identified by the first of authenticators
+// whose credentials r carries, ErrNoCredentials if r carries none, or why
+// they are not valid. Invalid credentials are not retried with the next
+// scheme. Other transports, such as gRPC, can use it with their credentials
+// copied into r's headers.
+func Authenticate(r *http.Request, authenticators ...Authenticator) (Principal, error) {
+	principal, err := authenticate(r, authenticators)
+	var invalid *invalidError
+	if errors.As(err, &invalid) {
+		return Principal{}, invalid.err
+	}
+	return principal, err
+}
+
+// authenticate returns the caller identified by the first authenticator
+// whose credentials r carries. Invalid credentials are not retried with the
+// next scheme.
+func authenticate(r *http.Request, authenticators []Authenticator) (Principal, error) {
+	for _, a := range authenticators {
+		principal, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return Principal{}, &invalidError{scheme: a.Scheme(), err: err}
+		}
+		return principal, nil
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+// invalidError records which scheme rejected the request's credentials.
+type invalidError struct {
+	scheme string
+	err    error
+}
+
+func (e *invalidError) Error() string { return e.err.Error() }
+func (e *invalidError) Unwrap() error { return e.err }
+
+// unauthorized answers a request without valid credentials with 401 and a
+// challenge for every scheme the operation accepts.
+func unauthorized(w http.ResponseWriter, r *http.Request, offered []Authenticator, err error) {
+	var invalid *invalidError
+	errors.As(err, &invalid)
+	for _, a := range offered {
+		w.Header().Add("WWW-Authenticate", a.Challenge(invalid != nil && invalid.scheme == a.Scheme()))
+	}
+
+	message := "Authentication required"
+	if invalid != nil {
+		message = "Invalid credentials: " + invalid.Error()
+	}
+	logging.FromContext(r.Context()).Debug("request unauthenticated",
+		"method", r.Method, "path", r.URL.Path, "error", err)
+	writeError(w, r, http.StatusUnauthorized, message)
+}
+
+// forbidden answers a caller whose credentials do not cover operation.
+func forbidden(w http.ResponseWriter, r *http.Request, p Principal, operation string) {
+	logging.FromContext(r.Context()).Debug("request forbidden",
+		"operation", operation, "principal", p.Name, "scheme", p.Scheme)
+	writeError(w, r, http.StatusForbidden, fmt.Sprintf("%s may not call %s", p.Name, operation))
+}
+
+// writeError writes a body with the shape of the contract's Error schema.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{Error: message, RequestID: logging.RequestID(r.Context())})
+}
+
+// SpecSecurity returns the security requirements of an operation: an API key
+// or a bearer token.
+func SpecSecurity() []contract.SecurityRequirement {
+	return []contract.SecurityRequirement{
+		{APIKeyScheme: {}},
+		{BearerScheme: {}},
+	}
+}
+
+// splitFields splits a space-separated list such as a token's scope claim.
+func splitFields(s string) []string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinHS256SecretSize is the shortest HS256 secret accepted, in bytes, as
+// RFC 7518 requires a key at least as long as the hash.
+const MinHS256SecretSize = 32
+
+// JWTConfig locates the keys tokens are verified with and the claims they
+// must carry. At least one key file must be set.
+type JWTConfig struct {
+	// HS256SecretFile holds the shared secret of HS256 tokens.
+	HS256SecretFile string
+	// RS256PublicKeyFile holds the PEM-encoded RSA public key of RS256 tokens.
+	RS256PublicKeyFile string
+	// Issuer, if set, must equal the iss claim.
+	Issuer string
+	// Audience, if set, must be in the aud claim.
+	Audience string
+}
+
+// Enabled reports whether any key file is set.
+func (c JWTConfig) Enabled() bool {
+	return c.HS256SecretFile != "" || c.RS256PublicKeyFile != ""
+}
+
+// JWT authenticates requests by a bearer token signed with HS256 or RS256.
+// Tokens must carry sub and exp claims. An optional scope claim lists the
+// operationIds the token may call, separated by spaces; without one every
+// operation is allowed.
+type JWT struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+// claims are the claims the producer reads.
+type claims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
+}
+
+// LoadJWT reads the keys named by config.
+func LoadJWT(config JWTConfig) (*JWT, error) {
+	if !config.Enabled() {
+		return nil, fmt.Errorf("no JWT key file configured")
+	}
+
+	j := &JWT{}
+	var methods []string
+	if config.HS256SecretFile != "" {
+		secret, err := os.ReadFile(config.HS256SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read HS256 secret: %w", err)
+		}
+		j.secret = bytes.TrimSpace(secret)
+		if len(j.secret) < MinHS256SecretSize {
+			return nil, fmt.Errorf("HS256 secret in %s is shorter than %d bytes", config.HS256SecretFile, MinHS256SecretSize)
+		}
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.RS256PublicKeyFile != "" {
+		pem, err := os.ReadFile(config.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read RS256 public key: %w", err)
+		}
+		if j.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("%s: %w", config.RS256PublicKeyFile, err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	j.parser = jwt.NewParser(options...)
+	return j, nil
+}
+
+// Scheme returns BearerScheme.
+func (j *JWT) Scheme() string { return BearerScheme }
+
+// Challenge is a Bearer challenge, flagging an invalid token as RFC 6750
+// describes.
+func (j *JWT) Challenge(invalid bool) string {
+	if invalid {
+		return `Bearer realm="calculator-api", error="invalid_token"`
+	}
+	return `Bearer realm="calculator-api"`
+}
+
+// Authenticate verifies the request's bearer token.
+func (j *JWT) Authenticate(r *http.Request) (Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, ErrNoCredentials
+	}
+
+	var c claims
+	if _, err := j.parser.ParseWithClaims(strings.TrimSpace(token), &c, j.key); err != nil {
+		return Principal{}, err
+	}
+	if c.Subject == "" {
+		return Principal{}, fmt.Errorf("token has no subject")
+	}
+	return Principal{Name: c.Subject, Scheme: BearerScheme, Operations: splitFields(c.Scope)}, nil
+}
+
+// key returns the verification key for the token's algorithm. The parser
+// has already rejected algorithms without a configured key.
+func (j *JWT) key(token *jwt.Token) (any, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return j.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		return j.publicKey, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
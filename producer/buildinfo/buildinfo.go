@@ -0,0 +1,61 @@
+// Package buildinfo reports which build of the producer is running, read from
+// the information the Go toolchain stamps into every binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// SDKModule is the module path of the CVT Go SDK.
+const SDKModule = "github.com/sahina/cvt/sdks/go"
+
+// Info is the body of /version and the output of --version.
+type Info struct {
+	// Version is the main module version, e.g. "v1.2.0" for a tagged build,
+	// a pseudo-version for an untagged commit or "(devel)" without VCS data.
+	Version string `json:"version"`
+	// Revision is the VCS commit the binary was built from, if known.
+	Revision string `json:"revision,omitempty"`
+	// Dirty is true when the working tree had uncommitted changes.
+	Dirty     bool   `json:"dirty"`
+	GoVersion string `json:"goVersion"`
+	// SchemaVersion is the info.version of the contract the producer enforces.
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+	// CVTSDKVersion is the version of the CVT SDK linked into the binary.
+	CVTSDKVersion string `json:"cvtSdkVersion,omitempty"`
+}
+
+// Read returns the running binary's build information together with the
+// given schema version. Fields the toolchain did not record are left empty;
+// go test binaries, for example, carry no VCS data.
+func Read(schemaVersion string) Info {
+	info := Info{Version: "(devel)", GoVersion: runtime.Version(), SchemaVersion: schemaVersion}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.modified":
+			info.Dirty = s.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path != SDKModule {
+			continue
+		}
+		info.CVTSDKVersion = dep.Version
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			info.CVTSDKVersion = dep.Replace.Version
+		}
+	}
+	return info
+}
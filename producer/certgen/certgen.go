@@ -0,0 +1,193 @@
+// Package certgen issues throwaway certificates for running the producer,
+// CVT and the consumers over TLS and mutual TLS without a real PKI.
+//
+// A CA is generated in memory and signs server and client certificates with
+// ECDSA P-256 keys. Nothing here is meant for production: keys are written
+// unencrypted and certificates are valid for Validity only.
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Validity is how long issued certificates are valid.
+const Validity = 7 * 24 * time.Hour
+
+// File names Generate writes.
+const (
+	CAFile         = "ca.pem"
+	ServerCertFile = "server.pem"
+	ServerKeyFile  = "server-key.pem"
+	ClientCertFile = "client.pem"
+	ClientKeyFile  = "client-key.pem"
+)
+
+// Pair is a PEM-encoded certificate and its private key.
+type Pair struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// TLSCertificate returns p for use in a tls.Config.
+func (p Pair) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(p.CertPEM, p.KeyPEM)
+}
+
+// CA is a certificate authority that exists only in memory.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// CertPEM is the CA certificate clients and servers must trust.
+	CertPEM []byte
+}
+
+// NewCA generates a self-signed CA named commonName.
+func NewCA(commonName string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key, CertPEM: encodeCert(der)}, nil
+}
+
+// Pool returns a certificate pool holding only ca.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Server issues a server certificate for hosts, which may be DNS names or IP
+// addresses. The first host is also the common name.
+func (ca *CA) Server(hosts ...string) (Pair, error) {
+	if len(hosts) == 0 {
+		return Pair{}, fmt.Errorf("a server certificate needs at least one host")
+	}
+	template, err := newTemplate(hosts[0])
+	if err != nil {
+		return Pair{}, err
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	return ca.issue(template)
+}
+
+// Client issues a client certificate whose common name is name.
+func (ca *CA) Client(name string) (Pair, error) {
+	template, err := newTemplate(name)
+	if err != nil {
+		return Pair{}, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+// issue signs template with a new key.
+func (ca *CA) issue(template *x509.Certificate) (Pair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Pair{}, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return Pair{}, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{
+		CertPEM: encodeCert(der),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Generate writes a new CA, a server certificate for hosts and a client
+// certificate named client to dir, which is created if needed.
+func Generate(dir string, hosts []string, client string) error {
+	ca, err := NewCA("cvt-demo throwaway CA")
+	if err != nil {
+		return err
+	}
+	server, err := ca.Server(hosts...)
+	if err != nil {
+		return err
+	}
+	clientPair, err := ca.Client(client)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, data := range map[string][]byte{
+		CAFile:         ca.CertPEM,
+		ServerCertFile: server.CertPEM,
+		ServerKeyFile:  server.KeyPEM,
+		ClientCertFile: clientPair.CertPEM,
+		ClientKeyFile:  clientPair.KeyPEM,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTemplate returns a certificate template with a random serial number,
+// valid from a minute ago to allow for clock skew.
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"cvt-demo"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(Validity),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,30 @@
+// Command gencerts writes a throwaway CA, a server certificate and a client
+// certificate for running the demo over TLS and mutual TLS locally.
+//
+// Usage:
+//
+//	go run ./cmd/gencerts [-dir certs] [-hosts localhost,127.0.0.1,producer,cvt,cvt-server] [-client consumer-4]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sahina/cvt-demo/producer/certgen"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory to write the certificates to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,producer,cvt,cvt-server", "comma-separated DNS names and IPs of the server certificate")
+	client := flag.String("client", "consumer-4", "common name of the client certificate")
+	flag.Parse()
+
+	if err := certgen.Generate(*dir, strings.Split(*hosts, ","), *client); err != nil {
+		fmt.Fprintf(os.Stderr, "gencerts: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s, %s, %s, %s and %s to %s\n", certgen.CAFile, certgen.ServerCertFile,
+		certgen.ServerKeyFile, certgen.ClientCertFile, certgen.ClientKeyFile, *dir)
+}
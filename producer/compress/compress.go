@@ -0,0 +1,311 @@
+// Package compress compresses responses with gzip or brotli, negotiated
+// through Accept-Encoding.
+//
+// The middleware belongs outside the CVT middleware: CVT captures and
+// validates the uncompressed JSON the handlers write, and only the bytes
+// leaving the producer are compressed. Responses smaller than the configured
+// minimum size are sent as they are, since compressing them costs more than
+// it saves.
+//
+// A compressed response is a different representation from the identity
+// one, so its strong ETag gets the coding appended: "abc" becomes "abc-gzip".
+// The suffix is removed from If-None-Match before the handler sees it, so
+// handlers only ever deal with their own tags.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings the middleware can produce.
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+)
+
+// Defaults applied by ParseConfig when a setting is empty.
+var (
+	DefaultEncodings = []string{Brotli, Gzip}
+	DefaultMinSize   = 256
+)
+
+// compressibleTypes are the media types worth compressing. Event streams are
+// deliberately absent: they are flushed event by event.
+var compressibleTypes = []string{"application/json", "application/yaml", "text/html", "text/plain"}
+
+// Config describes which encodings the producer offers and when.
+type Config struct {
+	// Encodings lists the offered codings in order of preference, used to
+	// break ties between codings the client accepts equally. No encodings
+	// disables compression.
+	Encodings []string
+	// MinSize is the smallest body, in bytes, that is compressed.
+	MinSize int
+}
+
+// ParseConfig builds a Config from a comma-separated list of encodings and a
+// minimum size in bytes, as read from COMPRESSION_ENCODINGS and
+// COMPRESSION_MIN_SIZE. Empty values select the defaults; "off" disables
+// compression.
+func ParseConfig(encodings, minSize string) (Config, error) {
+	config := Config{Encodings: DefaultEncodings, MinSize: DefaultMinSize}
+
+	switch strings.ToLower(strings.TrimSpace(encodings)) {
+	case "":
+	case "off", "none":
+		config.Encodings = nil
+	default:
+		config.Encodings = nil
+		for _, encoding := range strings.Split(strings.ToLower(encodings), ",") {
+			encoding = strings.TrimSpace(encoding)
+			if encoding != Gzip && encoding != Brotli {
+				return Config{}, fmt.Errorf("unsupported encoding %q (want %s or %s)", encoding, Brotli, Gzip)
+			}
+			config.Encodings = append(config.Encodings, encoding)
+		}
+	}
+
+	if minSize != "" {
+		size, err := strconv.Atoi(minSize)
+		if err != nil || size < 0 {
+			return Config{}, fmt.Errorf("invalid compression min size %q (want bytes)", minSize)
+		}
+		config.MinSize = size
+	}
+	return config, nil
+}
+
+// Enabled reports whether any encoding is offered.
+func (c Config) Enabled() bool {
+	return len(c.Encodings) > 0
+}
+
+// Negotiate picks the coding to use for an Accept-Encoding header value: the
+// offered coding with the highest q-value, ties broken by the order of
+// offered. It returns "" when the client accepts none of them, including
+// when the header is absent.
+func Negotiate(acceptEncoding string, offered []string) string {
+	quality := make(map[string]float64)
+	wildcard, hasWildcard := 0.0, false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard, hasWildcard = q, true
+			continue
+		}
+		quality[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, ok := quality[coding]
+		if !ok && hasWildcard {
+			q, ok = wildcard, true
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Middleware compresses responses for clients that accept one of the
+// configured encodings.
+func Middleware(config Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Responses differ by Accept-Encoding, so caches must key on it
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := Negotiate(r.Header.Get("Accept-Encoding"), config.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: config.MinSize}
+			if tags, coded := stripCodings(r.Header.Get("If-None-Match")); coded {
+				r = r.Clone(r.Context())
+				r.Header.Set("If-None-Match", tags)
+				cw.codedTags = true
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter buffers the start of the body until it either reaches the
+// minimum size, in which case the rest is compressed, or the handler
+// returns, in which case it is sent as is. The status is held back with the
+// buffer, since Content-Encoding must be decided before it is written.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser // nil when the body is sent uncompressed
+
+	// codedTags is set when If-None-Match named a compressed representation,
+	// so a 304 must name it too
+	codedTags bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been buffered so far, compressed if the response is
+// eligible, and flushes the underlying writer.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the held-back status and buffer, compressing from here on if
+// compress is set and the response is eligible.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compress && cw.status != http.StatusPartialContent && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		codeETag(h, cw.encoding)
+		cw.encoder = newEncoder(cw.encoding, cw.ResponseWriter)
+	} else if cw.status == http.StatusNotModified && cw.codedTags {
+		codeETag(h, cw.encoding)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.encoder != nil {
+		_, err := cw.encoder.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends a body that stayed below the minimum size uncompressed, or
+// finishes the compressed stream.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// codeETag appends the coding to a strong ETag.
+func codeETag(h http.Header, encoding string) {
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+	}
+}
+
+// stripCodings removes the coding suffixes codeETag adds from the tags of an
+// If-None-Match value, and reports whether there were any.
+func stripCodings(ifNoneMatch string) (string, bool) {
+	if ifNoneMatch == "" {
+		return "", false
+	}
+	tags := strings.Split(ifNoneMatch, ",")
+	coded := false
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		for _, encoding := range []string{Gzip, Brotli} {
+			if stripped, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok {
+				tag, coded = stripped+`"`, true
+				break
+			}
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", "), coded
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == Brotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	return gzip.NewWriter(w)
+}
+
+// compressible reports whether contentType is worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return slices.Contains(compressibleTypes, strings.ToLower(strings.TrimSpace(mediaType)))
+}
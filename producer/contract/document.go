@@ -0,0 +1,52 @@
+package contract
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Document is an OpenAPI document together with its JSON and YAML encodings.
+type Document struct {
+	Spec *Spec
+	JSON []byte
+	YAML []byte
+}
+
+// NewDocument builds a Document from matching JSON and YAML encodings.
+// Either may be nil, in which case it is converted from the other.
+func NewDocument(jsonData, yamlData []byte) (*Document, error) {
+	var err error
+	switch {
+	case jsonData == nil && yamlData == nil:
+		return nil, fmt.Errorf("OpenAPI document is empty")
+	case jsonData == nil:
+		if jsonData, err = yaml.YAMLToJSON(yamlData); err != nil {
+			return nil, fmt.Errorf("convert OpenAPI document to JSON: %w", err)
+		}
+	case yamlData == nil:
+		if yamlData, err = yaml.JSONToYAML(jsonData); err != nil {
+			return nil, fmt.Errorf("convert OpenAPI document to YAML: %w", err)
+		}
+	}
+
+	spec, err := Parse(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Spec: spec, JSON: jsonData, YAML: yamlData}, nil
+}
+
+// LoadDocument reads the OpenAPI document at path, which may be JSON or YAML.
+func LoadDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read OpenAPI document: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return NewDocument(data, nil)
+	}
+	return NewDocument(nil, data)
+}
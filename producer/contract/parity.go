@@ -0,0 +1,89 @@
+package contract
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Route is a method and path the producer serves.
+type Route struct {
+	Method string
+	Path   string
+}
+
+func (r Route) String() string {
+	return r.Method + " " + r.Path
+}
+
+// ParityError lists the differences between the registered routes and the
+// operations in the OpenAPI document.
+type ParityError struct {
+	// Undocumented routes are served but have no operation in the document.
+	Undocumented []Route
+	// Unimplemented operations are in the document but no route serves them.
+	Unimplemented []Route
+}
+
+func (e *ParityError) Error() string {
+	var b strings.Builder
+	b.WriteString("routes and OpenAPI document disagree:")
+	for _, r := range e.Undocumented {
+		fmt.Fprintf(&b, "\n  + %s (registered, missing from spec)", r)
+	}
+	for _, r := range e.Unimplemented {
+		fmt.Fprintf(&b, "\n  - %s (in spec, no handler)", r)
+	}
+	return b.String()
+}
+
+// CheckParity compares routes with the operations in spec. Paths listed in
+// exempt are operational endpoints (such as /metrics) that are deliberately
+// left out of the contract; they are ignored on both sides. It returns a
+// *ParityError describing every mismatch, or nil when they agree.
+func CheckParity(spec *Spec, routes []Route, exempt ...string) error {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	served := make(map[Route]bool, len(routes))
+	for _, r := range routes {
+		if !skip[r.Path] {
+			served[Route{Method: strings.ToUpper(r.Method), Path: r.Path}] = true
+		}
+	}
+
+	documented := make(map[Route]bool)
+	for _, e := range spec.Endpoints() {
+		if !skip[e.Path] {
+			documented[Route{Method: e.Method, Path: e.Path}] = true
+		}
+	}
+
+	parityErr := &ParityError{
+		Undocumented:  difference(served, documented),
+		Unimplemented: difference(documented, served),
+	}
+	if len(parityErr.Undocumented) == 0 && len(parityErr.Unimplemented) == 0 {
+		return nil
+	}
+	return parityErr
+}
+
+// difference returns the routes in a that are not in b, sorted by path then method.
+func difference(a, b map[Route]bool) []Route {
+	var out []Route
+	for r := range a {
+		if !b[r] {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out
+}
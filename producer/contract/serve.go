@@ -0,0 +1,131 @@
+package contract
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersionHeader carries info.version on every contract response.
+const SchemaVersionHeader = "X-Schema-Version"
+
+// Paths served by Handler. They describe the contract rather than being part
+// of it, so they are excluded from CVT validation.
+const (
+	JSONPath = "/openapi.json"
+	YAMLPath = "/openapi.yaml"
+	DocsPath = "/docs"
+)
+
+//go:embed docs.html.tmpl
+var docsTemplateText string
+
+var docsTemplate = template.Must(template.New("docs").Funcs(template.FuncMap{
+	"lower":           strings.ToLower,
+	"sortedResponses": sortedResponses,
+	"sortedProperties": func(s Schema) []string {
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	},
+	"isRequired": func(s Schema, name string) bool {
+		for _, r := range s.Required {
+			if r == name {
+				return true
+			}
+		}
+		return false
+	},
+}).Parse(docsTemplateText))
+
+// representation is one pre-rendered encoding of the document.
+type representation struct {
+	contentType string
+	body        []byte
+	etag        string
+}
+
+// Handler serves a Document as JSON, YAML and a self-contained HTML reference.
+type Handler struct {
+	version string
+	json    representation
+	yaml    representation
+	html    representation
+}
+
+// NewHandler renders doc once so every request is served from memory.
+func NewHandler(doc *Document) (*Handler, error) {
+	var page bytes.Buffer
+	if err := docsTemplate.Execute(&page, doc.Spec); err != nil {
+		return nil, fmt.Errorf("render API reference: %w", err)
+	}
+
+	return &Handler{
+		version: doc.Spec.Info.Version,
+		json:    newRepresentation("application/json", doc.JSON),
+		yaml:    newRepresentation("application/yaml", doc.YAML),
+		html:    newRepresentation("text/html; charset=utf-8", page.Bytes()),
+	}, nil
+}
+
+// RegisterRoutes registers the contract routes on the given mux and returns
+// them. They answer GET and HEAD; other methods are left to the mux.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) []Route {
+	routes := []struct {
+		path string
+		rep  representation
+	}{{JSONPath, h.json}, {YAMLPath, h.yaml}, {DocsPath, h.html}}
+
+	var registered []Route
+	for _, route := range routes {
+		mux.HandleFunc("GET "+route.path, h.serve(route.rep))
+		registered = append(registered, Route{Method: http.MethodGet, Path: route.path})
+	}
+	return registered
+}
+
+func (h *Handler) serve(rep representation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", rep.contentType)
+		w.Header().Set("ETag", rep.etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set(SchemaVersionHeader, h.version)
+
+		// ServeContent answers If-None-Match with 304 using the ETag above
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(rep.body))
+	}
+}
+
+func newRepresentation(contentType string, body []byte) representation {
+	sum := sha256.Sum256(body)
+	return representation{
+		contentType: contentType,
+		body:        body,
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+}
+
+// statusResponse pairs a status code with its response for ordered rendering.
+type statusResponse struct {
+	Status   string
+	Response Response
+}
+
+func sortedResponses(responses map[string]Response) []statusResponse {
+	sorted := make([]statusResponse, 0, len(responses))
+	for status, response := range responses {
+		sorted = append(sorted, statusResponse{Status: status, Response: response})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Status < sorted[j].Status })
+	return sorted
+}
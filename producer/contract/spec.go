@@ -0,0 +1,244 @@
+// Package contract loads the OpenAPI document the producer is built against.
+package contract
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Spec is the subset of an OpenAPI 3 document the producer relies on.
+type Spec struct {
+	OpenAPI string   `json:"openapi,omitempty" yaml:"openapi,omitempty"`
+	Info    Info     `json:"info,omitempty" yaml:"info,omitempty"`
+	Servers []Server `json:"servers,omitempty" yaml:"servers,omitempty"`
+	// Paths maps each path to its operations keyed by lower-case HTTP method.
+	Paths      map[string]map[string]Operation `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Components Components                      `json:"components,omitempty" yaml:"components,omitempty"`
+}
+
+// Info mirrors the OpenAPI info object.
+type Info struct {
+	Title       string `json:"title,omitempty" yaml:"title,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Version     string `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// Server mirrors the OpenAPI server object.
+type Server struct {
+	URL         string `json:"url,omitempty" yaml:"url,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Operation mirrors the OpenAPI operation object.
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty" yaml:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Description string              `json:"description,omitempty" yaml:"description,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Responses   map[string]Response `json:"responses,omitempty" yaml:"responses,omitempty"`
+	// Security lists alternative requirements; satisfying any one of them is
+	// enough. Operations without it are public.
+	Security []SecurityRequirement `json:"security,omitempty" yaml:"security,omitempty"`
+}
+
+// SecurityRequirement mirrors the OpenAPI security requirement object: the
+// security schemes, by component name, that must all be satisfied, with the
+// scopes each requires.
+type SecurityRequirement map[string][]string
+
+// Parameter mirrors the OpenAPI parameter object.
+type Parameter struct {
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	In          string `json:"in,omitempty" yaml:"in,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Schema      Schema `json:"schema,omitempty" yaml:"schema,omitempty"`
+}
+
+// Response mirrors the OpenAPI response object. A response may instead be a
+// $ref to one of the document's component responses.
+type Response struct {
+	Ref         string               `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Description string               `json:"description,omitempty" yaml:"description,omitempty"`
+	Headers     map[string]Header    `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+// RefName returns the component name a local $ref points at, e.g.
+// "MethodNotAllowed" for "#/components/responses/MethodNotAllowed", or "" if
+// the response is not a reference.
+func (r Response) RefName() string {
+	return strings.TrimPrefix(r.Ref, "#/components/responses/")
+}
+
+// Header mirrors the OpenAPI header object. A header may instead be a $ref
+// to one of the document's component headers.
+type Header struct {
+	Ref         string `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Schema      Schema `json:"schema,omitempty" yaml:"schema,omitempty"`
+}
+
+// RefName returns the component name a local $ref points at, e.g.
+// "AccessControlAllowOrigin" for "#/components/headers/AccessControlAllowOrigin",
+// or "" if the header is not a reference.
+func (h Header) RefName() string {
+	return strings.TrimPrefix(h.Ref, "#/components/headers/")
+}
+
+// MediaType mirrors the OpenAPI media type object.
+type MediaType struct {
+	Schema Schema `json:"schema,omitempty" yaml:"schema,omitempty"`
+}
+
+// Components mirrors the OpenAPI components object.
+type Components struct {
+	Schemas   map[string]Schema   `json:"schemas,omitempty" yaml:"schemas,omitempty"`
+	Responses map[string]Response `json:"responses,omitempty" yaml:"responses,omitempty"`
+	Headers   map[string]Header   `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// SecuritySchemes maps component names to security schemes.
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty" yaml:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme mirrors the OpenAPI security scheme object for the apiKey
+// and http types.
+type SecurityScheme struct {
+	Type         string `json:"type,omitempty" yaml:"type,omitempty"`
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"`
+	Name         string `json:"name,omitempty" yaml:"name,omitempty"`
+	In           string `json:"in,omitempty" yaml:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty" yaml:"bearerFormat,omitempty"`
+}
+
+// Schema is the subset of JSON Schema used by the calculator contract.
+type Schema struct {
+	Ref         string            `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Type        string            `json:"type,omitempty" yaml:"type,omitempty"`
+	Format      string            `json:"format,omitempty" yaml:"format,omitempty"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Properties  map[string]Schema `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Required    []string          `json:"required,omitempty" yaml:"required,omitempty"`
+	Items       *Schema           `json:"items,omitempty" yaml:"items,omitempty"`
+	Enum        []any             `json:"enum,omitempty" yaml:"enum,omitempty"`
+	// OneOf lists alternatives of which a value must match exactly one.
+	OneOf []Schema `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
+}
+
+// RefName returns the component name a local $ref points at, e.g. "Result"
+// for "#/components/schemas/Result", or "" if the schema is not a reference.
+func (s Schema) RefName() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+// Parse decodes an OpenAPI document. YAML is a superset of JSON, so both
+// calculator-api.yaml and calculator-api.json are accepted.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+	if spec.Info.Version == "" {
+		return nil, fmt.Errorf("OpenAPI document has no info.version")
+	}
+	return &spec, nil
+}
+
+// Load reads and parses the OpenAPI document at path.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read OpenAPI document: %w", err)
+	}
+	return Parse(data)
+}
+
+// PathNames returns the document's paths in sorted order.
+func (s *Spec) PathNames() []string {
+	names := make([]string, 0, len(s.Paths))
+	for path := range s.Paths {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// methodOrder is the order OpenAPI lists operations within a path item.
+var methodOrder = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Endpoint is one operation of the document, addressed by method and path.
+type Endpoint struct {
+	// Method is the upper-case HTTP method, e.g. "GET".
+	Method    string
+	Path      string
+	Operation Operation
+}
+
+// Endpoints returns every operation in the document, sorted by path and then
+// in OpenAPI method order.
+func (s *Spec) Endpoints() []Endpoint {
+	var endpoints []Endpoint
+	for _, path := range s.PathNames() {
+		for _, method := range methodOrder {
+			if op, ok := s.Paths[path][method]; ok {
+				endpoints = append(endpoints, Endpoint{Method: strings.ToUpper(method), Path: path, Operation: op})
+			}
+		}
+	}
+	return endpoints
+}
+
+// Operation returns the operation documented for method and path. The path
+// must not carry a query string.
+func (s *Spec) Operation(method, path string) (Operation, bool) {
+	op, ok := s.Paths[path][strings.ToLower(method)]
+	return op, ok
+}
+
+// OperationID returns the operationId documented for method and path. The
+// path must not carry a query string.
+func (s *Spec) OperationID(method, path string) (string, bool) {
+	op, ok := s.Operation(method, path)
+	if !ok {
+		return "", false
+	}
+	return op.OperationID, true
+}
+
+// ResolveResponse returns the component response r refers to, or r itself if
+// it is not a reference or the component does not exist.
+func (s *Spec) ResolveResponse(r Response) Response {
+	if r.Ref == "" {
+		return r
+	}
+	if resolved, ok := s.Components.Responses[r.RefName()]; ok {
+		return resolved
+	}
+	return r
+}
+
+// ResolveHeader returns the component header h refers to, or h itself if it
+// is not a reference or the component does not exist.
+func (s *Spec) ResolveHeader(h Header) Header {
+	if h.Ref == "" {
+		return h
+	}
+	if resolved, ok := s.Components.Headers[h.RefName()]; ok {
+		return resolved
+	}
+	return h
+}
+
+// SchemaNames returns the names of the document's component schemas in sorted order.
+func (s *Spec) SchemaNames() []string {
+	names := make([]string, 0, len(s.Components.Schemas))
+	for name := range s.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,151 @@
+package contract
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// Validate checks value, as decoded by encoding/json, against schema,
+// resolving $refs to the document's component schemas. It returns one
+// message per violation, each starting with the JSON path of the offending
+// value, or nil when value conforms.
+//
+// Only the keywords Schema models are checked: type, enum, properties,
+// required, items and oneOf. Messages exchanged outside HTTP, such as the
+// /ws frames, are validated with it, since CVT only sees HTTP interactions.
+func (s *Spec) Validate(schema Schema, value any) []string {
+	return s.validate(schema, value, "$", 0)
+}
+
+// ValidateComponent checks value against the named component schema.
+func (s *Spec) ValidateComponent(name string, value any) []string {
+	return s.Validate(Schema{Ref: "#/components/schemas/" + name}, value)
+}
+
+// maxRefDepth bounds $ref resolution, so a recursive schema cannot loop.
+const maxRefDepth = 32
+
+func (s *Spec) validate(schema Schema, value any, path string, depth int) []string {
+	if schema.Ref != "" {
+		if depth >= maxRefDepth {
+			return []string{fmt.Sprintf("%s: $ref %s nests too deeply", path, schema.Ref)}
+		}
+		resolved, ok := s.Components.Schemas[schema.RefName()]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", path, schema.Ref)}
+		}
+		return s.validate(resolved, value, path, depth+1)
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, alternative := range schema.OneOf {
+			if len(s.validate(alternative, value, path, depth)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return []string{fmt.Sprintf("%s: matches %d of the %d oneOf schemas, want exactly 1", path, matched, len(schema.OneOf))}
+		}
+		return nil
+	}
+
+	if schema.Type != "" && !hasType(value, schema.Type) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, schema.Type, typeName(value))}
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e any) bool { return enumEqual(e, value) }) {
+		return []string{fmt.Sprintf("%s: %v is not one of %v", path, value, schema.Enum)}
+	}
+
+	var violations []string
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, fmt.Sprintf("%s: missing required property %s", path, name))
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := v[name]; ok {
+				violations = append(violations, s.validate(schema.Properties[name], property, path+"."+name, depth)...)
+			}
+		}
+	case []any:
+		if schema.Items != nil {
+			for i, item := range v {
+				violations = append(violations, s.validate(*schema.Items, item, fmt.Sprintf("%s[%d]", path, i), depth)...)
+			}
+		}
+	}
+	return violations
+}
+
+// hasType reports whether value has the JSON Schema type t.
+func hasType(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// typeName names the JSON type of value for messages.
+func typeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// enumEqual compares an enum value from the document with a decoded value.
+// YAML decodes integers as integers where JSON decodes every number as a
+// float64, so numbers are compared by value.
+func enumEqual(e, value any) bool {
+	if f, ok := value.(float64); ok {
+		switch n := e.(type) {
+		case int:
+			return float64(n) == f
+		case int64:
+			return float64(n) == f
+		case uint64:
+			return float64(n) == f
+		}
+	}
+	return reflect.DeepEqual(e, value)
+}
@@ -0,0 +1,232 @@
+// Package cors lets browser-based clients call the Calculator API directly.
+//
+// Preflight requests are answered here, before the CVT middleware, because
+// OPTIONS is not an operation of the contract and must not be validated as
+// one. Actual requests pass through with the CORS response headers the
+// contract documents.
+package cors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// Response headers set on actual requests from an allowed origin.
+const (
+	AllowOriginHeader   = "Access-Control-Allow-Origin"
+	ExposeHeadersHeader = "Access-Control-Expose-Headers"
+)
+
+// Response headers set on preflight responses.
+const (
+	AllowMethodsHeader = "Access-Control-Allow-Methods"
+	AllowHeadersHeader = "Access-Control-Allow-Headers"
+	MaxAgeHeader       = "Access-Control-Max-Age"
+)
+
+// Request headers of a preflight.
+const (
+	RequestMethodHeader  = "Access-Control-Request-Method"
+	RequestHeadersHeader = "Access-Control-Request-Headers"
+)
+
+// Component headers documenting the actual-request headers in the OpenAPI
+// document.
+const (
+	AllowOriginComponent   = "AccessControlAllowOrigin"
+	ExposeHeadersComponent = "AccessControlExposeHeaders"
+)
+
+// Defaults applied by ParseConfig when a setting is empty.
+var (
+	DefaultMethods = []string{http.MethodGet, http.MethodHead}
+	DefaultHeaders = []string{"Content-Type", "X-Request-ID", "traceparent", "Authorization", "X-API-Key"}
+	DefaultExposed = []string{"X-Request-ID", "ETag", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed"}
+	DefaultMaxAge  = 10 * time.Minute
+)
+
+// Config describes which cross-origin requests are allowed.
+type Config struct {
+	// Origins lists the allowed origins, e.g. "https://app.example.com".
+	// "*" allows any origin. No origins disables CORS entirely.
+	Origins []string
+	// Methods lists the methods a preflight may ask for.
+	Methods []string
+	// Headers lists the request headers a preflight may ask for. Matching is
+	// case-insensitive.
+	Headers []string
+	// Exposed lists the response headers browsers may read.
+	Exposed []string
+	// MaxAge is how long browsers may cache a preflight result.
+	MaxAge time.Duration
+}
+
+// ParseConfig builds a Config from comma-separated origin, method and header
+// lists and a max-age in seconds, as read from CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS and CORS_MAX_AGE. Empty values
+// select the defaults; empty origins disable CORS.
+func ParseConfig(origins, methods, headers, maxAge string) (Config, error) {
+	config := Config{
+		Origins: splitList(origins),
+		Methods: DefaultMethods,
+		Headers: DefaultHeaders,
+		Exposed: DefaultExposed,
+		MaxAge:  DefaultMaxAge,
+	}
+	if methods != "" {
+		config.Methods = splitList(strings.ToUpper(methods))
+	}
+	if headers != "" {
+		config.Headers = splitList(headers)
+	}
+	if maxAge != "" {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds < 0 {
+			return Config{}, fmt.Errorf("invalid CORS max age %q (want seconds)", maxAge)
+		}
+		config.MaxAge = time.Duration(seconds) * time.Second
+	}
+	for _, origin := range config.Origins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return Config{}, fmt.Errorf("invalid CORS origin %q (want * or a scheme://host[:port] origin)", origin)
+		}
+	}
+	return config, nil
+}
+
+// Enabled reports whether any origin is allowed.
+func (c Config) Enabled() bool {
+	return len(c.Origins) > 0
+}
+
+// Middleware answers preflight requests and adds CORS headers to actual
+// requests from allowed origins. It belongs outside the CVT middleware and
+// the unmatched-route handling, so preflights never reach either. Requests
+// without an Origin header pass through untouched.
+func Middleware(config Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Responses differ by origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+
+			if isPreflight(r) {
+				config.preflight(w, r, origin)
+				return
+			}
+
+			if config.allowsOrigin(origin) {
+				w.Header().Set(AllowOriginHeader, config.allowOriginValue(origin))
+				if len(config.Exposed) > 0 {
+					w.Header().Set(ExposeHeadersHeader, strings.Join(config.Exposed, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SpecHeaders returns the response headers every documented response lists,
+// as references to the CORS component headers.
+func SpecHeaders() map[string]contract.Header {
+	return map[string]contract.Header{
+		AllowOriginHeader:   {Ref: "#/components/headers/" + AllowOriginComponent},
+		ExposeHeadersHeader: {Ref: "#/components/headers/" + ExposeHeadersComponent},
+	}
+}
+
+// isPreflight reports whether r is a CORS preflight rather than an actual
+// OPTIONS request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(RequestMethodHeader) != ""
+}
+
+// preflight answers a preflight with 204 and the allowed methods and headers,
+// or 403 if the origin, method or any requested header is not allowed.
+func (c Config) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", RequestMethodHeader)
+	w.Header().Add("Vary", RequestHeadersHeader)
+
+	method := r.Header.Get(RequestMethodHeader)
+	requested := splitList(r.Header.Get(RequestHeadersHeader))
+
+	switch {
+	case !c.allowsOrigin(origin):
+		reject(w, r, fmt.Sprintf("origin %s is not allowed", origin))
+		return
+	case !slices.Contains(c.Methods, strings.ToUpper(method)):
+		reject(w, r, fmt.Sprintf("method %s is not allowed", method))
+		return
+	}
+	for _, header := range requested {
+		if !c.allowsHeader(header) {
+			reject(w, r, fmt.Sprintf("header %s is not allowed", header))
+			return
+		}
+	}
+
+	w.Header().Set(AllowOriginHeader, c.allowOriginValue(origin))
+	w.Header().Set(AllowMethodsHeader, strings.Join(c.Methods, ", "))
+	if len(c.Headers) > 0 {
+		w.Header().Set(AllowHeadersHeader, strings.Join(c.Headers, ", "))
+	}
+	w.Header().Set(MaxAgeHeader, strconv.Itoa(int(c.MaxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c Config) allowsOrigin(origin string) bool {
+	return slices.Contains(c.Origins, "*") || slices.Contains(c.Origins, origin)
+}
+
+// allowOriginValue echoes the origin unless every origin is allowed.
+func (c Config) allowOriginValue(origin string) string {
+	if slices.Contains(c.Origins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+func (c Config) allowsHeader(header string) bool {
+	return slices.ContainsFunc(c.Headers, func(allowed string) bool {
+		return strings.EqualFold(allowed, header)
+	})
+}
+
+// reject answers a disallowed preflight without any Access-Control-Allow-*
+// headers, so the browser blocks the actual request. The body has the shape
+// of the contract's Error schema.
+func reject(w http.ResponseWriter, r *http.Request, message string) {
+	logging.FromContext(r.Context()).Debug("CORS preflight rejected",
+		"path", r.URL.Path, "origin", r.Header.Get("Origin"), "reason", message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{Error: "CORS preflight rejected: " + message, RequestID: logging.RequestID(r.Context())})
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
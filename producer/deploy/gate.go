@@ -0,0 +1,90 @@
+// Package deploy runs the can-i-deploy check before the producer starts serving.
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sahina/cvt/sdks/go/cvt"
+)
+
+// Mode controls how the producer reacts when registered consumers would break.
+type Mode string
+
+const (
+	// ModeEnforce refuses to start when the schema is not safe to deploy.
+	ModeEnforce Mode = "enforce"
+	// ModeDegraded starts anyway but reports a degraded status.
+	ModeDegraded Mode = "degraded"
+	// ModeOff skips the check entirely.
+	ModeOff Mode = "off"
+)
+
+// ErrUnsafe is returned by Check in enforce mode when consumers would break.
+var ErrUnsafe = errors.New("schema is not safe to deploy")
+
+// Checker is the part of cvt.Validator used by the gate.
+type Checker interface {
+	CanIDeploy(ctx context.Context, schemaID, version, environment string) (*cvt.CanIDeployResult, error)
+}
+
+// Config describes what to check and how strictly.
+type Config struct {
+	SchemaID    string
+	Version     string
+	Environment string
+	Mode        Mode
+}
+
+// Outcome is the result of running the gate.
+type Outcome struct {
+	// Checked is false when the gate was skipped or CVT could not answer.
+	Checked bool
+	// Degraded is true when the producer should start in degraded mode.
+	Degraded bool
+	// Result is the raw can-i-deploy answer, nil when Checked is false.
+	Result *cvt.CanIDeployResult
+}
+
+// ParseMode converts a configuration string into a Mode.
+// An empty string selects ModeEnforce.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		return ModeEnforce, nil
+	case ModeEnforce, ModeDegraded, ModeOff:
+		return Mode(s), nil
+	default:
+		return "", fmt.Errorf("unknown can-i-deploy mode %q (want enforce, degraded or off)", s)
+	}
+}
+
+// Check asks CVT whether config.Version of the schema can be deployed to
+// config.Environment. In enforce mode an unsafe result returns ErrUnsafe.
+// Any other error means the check could not run, including CVT answering
+// without a result; the caller decides whether that blocks startup.
+func Check(ctx context.Context, checker Checker, config Config) (Outcome, error) {
+	if config.Mode == ModeOff {
+		return Outcome{}, nil
+	}
+
+	result, err := checker.CanIDeploy(ctx, config.SchemaID, config.Version, config.Environment)
+	if err != nil {
+		return Outcome{}, fmt.Errorf("can-i-deploy check failed: %w", err)
+	}
+	if result == nil {
+		return Outcome{}, errors.New("can-i-deploy check failed: CVT returned no result")
+	}
+
+	outcome := Outcome{Checked: true, Result: result}
+	if result.SafeToDeploy {
+		return outcome, nil
+	}
+
+	if config.Mode == ModeDegraded {
+		outcome.Degraded = true
+		return outcome, nil
+	}
+	return outcome, fmt.Errorf("%w: %s@%s in %s: %s", ErrUnsafe, config.SchemaID, config.Version, config.Environment, result.Summary)
+}
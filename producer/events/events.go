@@ -0,0 +1,137 @@
+// Package events keeps the feed of completed calculations served at
+// /events: live delivery to subscribers, and a bounded buffer of recent
+// events that a reconnecting client resumes from with Last-Event-ID.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultReplaySize is how many recent events a feed keeps unless told
+// otherwise.
+const DefaultReplaySize = 256
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped. A dropped client reconnects and resumes from the replay buffer.
+const subscriberBuffer = 64
+
+// Calculation is a completed calculation, the data of an event. Exactly one
+// of Result and Error is set.
+type Calculation struct {
+	Operation string             `json:"operation"`
+	Inputs    map[string]float64 `json:"inputs"`
+	Result    *float64           `json:"result,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	RequestID string             `json:"requestId,omitempty"`
+	Time      time.Time          `json:"time"`
+}
+
+// Event is a published Calculation. IDs start at 1 and increase by one per
+// event.
+type Event struct {
+	ID          uint64
+	Calculation Calculation
+}
+
+// Feed publishes events to its subscribers and keeps the most recent ones.
+// It is safe for concurrent use.
+type Feed struct {
+	mu          sync.Mutex
+	size        int
+	recent      []Event // oldest first, at most size
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// NewFeed creates a feed that keeps the last size events for replay.
+func NewFeed(size int) *Feed {
+	return &Feed{size: max(size, 0), subscribers: make(map[*Subscription]struct{})}
+}
+
+// SetReplaySize changes how many recent events f keeps, dropping the oldest
+// ones if it now keeps fewer.
+func (f *Feed) SetReplaySize(size int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.size = max(size, 0)
+	if len(f.recent) > f.size {
+		f.recent = f.recent[len(f.recent)-f.size:]
+	}
+}
+
+// Subscription receives the events published after it was created.
+type Subscription struct {
+	// C delivers the events in order. It is closed when the subscription is
+	// closed, or when the subscriber fell too far behind.
+	C    <-chan Event
+	c    chan Event
+	feed *Feed
+}
+
+// Close stops delivery and closes C.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.drop(s)
+}
+
+// Publish records c as the next event and delivers it to every subscriber.
+func (f *Feed) Publish(c Calculation) Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	event := Event{ID: f.lastID, Calculation: c}
+	if f.size > 0 {
+		f.recent = append(f.recent, event)
+		if len(f.recent) > f.size {
+			f.recent = f.recent[1:]
+		}
+	}
+
+	for s := range f.subscribers {
+		select {
+		case s.c <- event:
+		default:
+			f.drop(s)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the buffered events after lastID, when resume is set,
+// and a subscription for the events published from then on. If lastID is no
+// longer buffered, because it is older than the oldest event kept or newer
+// than the latest, as after a restart, every buffered event is replayed.
+func (f *Feed) Subscribe(lastID uint64, resume bool) ([]Event, *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: c, c: c, feed: f}
+	f.subscribers[s] = struct{}{}
+
+	if !resume {
+		return nil, s
+	}
+	if lastID > f.lastID || (len(f.recent) > 0 && lastID < f.recent[0].ID-1) {
+		lastID = 0
+	}
+	var replay []Event
+	for _, event := range f.recent {
+		if event.ID > lastID {
+			replay = append(replay, event)
+		}
+	}
+	return replay, s
+}
+
+// drop removes s and closes its channel. f.mu must be held.
+func (f *Feed) drop(s *Subscription) {
+	if _, ok := f.subscribers[s]; ok {
+		delete(f.subscribers, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,329 @@
+// Package faults injects failures into the producer's responses, so
+// consumers can be tested against a producer that misbehaves the way real
+// ones do.
+//
+// Fault injection is opt-in. Each fault has a kind, a probability, and
+// optionally the route and the consumer, as named by its X-Consumer-ID
+// header, it applies to. A request gets the first fault that matches it and
+// fires. The faults are read from a JSON file at startup and can be replaced
+// at runtime through the admin API at /admin/faults.
+package faults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// AdminPath is where the admin API reads and replaces the faults.
+const AdminPath = "/admin/faults"
+
+// AdminGrant is what credentials must list, as an API key's operationId or in
+// a token's scope, to use the admin API when authentication is configured.
+const AdminGrant = "admin:faults"
+
+// ConsumerHeader names the consumer a request comes from.
+const ConsumerHeader = "X-Consumer-ID"
+
+// Kind is what a fault does to a response.
+type Kind string
+
+// Fault kinds.
+const (
+	// KindLatency delays the request by Latency, then handles it as usual.
+	KindLatency Kind = "latency"
+	// KindError answers with Status and an Error body without handling the
+	// request.
+	KindError Kind = "error"
+	// KindMalformed handles the request and sends the first half of its
+	// body, which is no longer valid JSON.
+	KindMalformed Kind = "malformed"
+	// KindDrop closes the connection without answering or handling the
+	// request.
+	KindDrop Kind = "drop"
+	// KindViolation handles the request and sends its JSON body wrapped in
+	// {"data": ...}, so the fields the contract requires are missing.
+	KindViolation Kind = "violation"
+)
+
+var kinds = []Kind{KindLatency, KindError, KindMalformed, KindDrop, KindViolation}
+
+// Duration is a time.Duration written in JSON as a Go duration string,
+// e.g. "250ms".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s (want a string such as \"250ms\")", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (want e.g. \"250ms\")", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Fault is a failure injected into matching requests.
+type Fault struct {
+	Kind Kind `json:"kind"`
+	// Route is the path the fault applies to, e.g. "/add"; empty applies it
+	// to every route.
+	Route string `json:"route,omitempty"`
+	// Consumer is the X-Consumer-ID the fault applies to; empty applies it
+	// to every consumer.
+	Consumer string `json:"consumer,omitempty"`
+	// Probability is the chance, from 0 to 1, that a matching request gets
+	// the fault. Omitted, it is 1.
+	Probability *float64 `json:"probability,omitempty"`
+	// Latency is the delay of a latency fault.
+	Latency Duration `json:"latency,omitempty"`
+	// Status is the status of an error fault, 500 unless set.
+	Status int `json:"status,omitempty"`
+}
+
+// validate checks f and fills in the defaults.
+func (f *Fault) validate() error {
+	if !slices.Contains(kinds, f.Kind) {
+		return fmt.Errorf("unknown fault kind %q (want latency, error, malformed, drop or violation)", f.Kind)
+	}
+	if f.Route != "" && !strings.HasPrefix(f.Route, "/") {
+		return fmt.Errorf("invalid fault route %q (want a path such as /add)", f.Route)
+	}
+	if f.Probability == nil {
+		always := 1.0
+		f.Probability = &always
+	}
+	if p := *f.Probability; p < 0 || p > 1 {
+		return fmt.Errorf("invalid fault probability %v (want 0 to 1)", p)
+	}
+	switch f.Kind {
+	case KindLatency:
+		if f.Latency <= 0 {
+			return fmt.Errorf("latency fault needs a positive latency")
+		}
+	case KindError:
+		if f.Status == 0 {
+			f.Status = http.StatusInternalServerError
+		}
+		if f.Status < 400 || f.Status > 599 {
+			return fmt.Errorf("invalid fault status %d (want 400 to 599)", f.Status)
+		}
+	}
+	return nil
+}
+
+// matches reports whether f applies to r.
+func (f Fault) matches(r *http.Request) bool {
+	return (f.Route == "" || f.Route == r.URL.Path) &&
+		(f.Consumer == "" || f.Consumer == r.Header.Get(ConsumerHeader))
+}
+
+// Config is the fault configuration, as read from FAULTS_FILE and sent to the
+// admin API.
+type Config struct {
+	Faults []Fault `json:"faults"`
+}
+
+// Parse decodes and validates a Config.
+func Parse(data []byte) (Config, error) {
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid fault configuration: %w", err)
+	}
+	for i := range config.Faults {
+		if err := config.Faults[i].validate(); err != nil {
+			return Config{}, fmt.Errorf("fault %d: %w", i, err)
+		}
+	}
+	return config, nil
+}
+
+// Load reads and validates the Config in the file at path.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read fault configuration: %w", err)
+	}
+	return Parse(data)
+}
+
+// Injector injects the configured faults. It is safe for concurrent use.
+type Injector struct {
+	excluded []string
+
+	mu     sync.RWMutex
+	faults []Fault
+}
+
+// NewInjector creates an injector without faults that never touches the
+// excluded paths, such as streams, whose responses cannot be held back.
+func NewInjector(excluded ...string) *Injector {
+	return &Injector{excluded: append([]string{AdminPath}, excluded...)}
+}
+
+// Config returns the faults being injected.
+func (i *Injector) Config() Config {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return Config{Faults: slices.Clone(i.faults)}
+}
+
+// Set replaces the faults with those of config, which must have been
+// validated by Parse or Load.
+func (i *Injector) Set(config Config) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults = slices.Clone(config.Faults)
+}
+
+// pick returns the fault to inject into r, if any.
+func (i *Injector) pick(r *http.Request) (Fault, bool) {
+	if slices.Contains(i.excluded, r.URL.Path) {
+		return Fault{}, false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, f := range i.faults {
+		if f.matches(r) && rand.Float64() < *f.Probability {
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+// Middleware injects faults into the requests to next. It belongs inside the
+// CVT middleware, so injected responses are validated like real ones.
+func (i *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := i.pick(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logging.FromContext(r.Context()).Info("fault injected",
+			"kind", f.Kind, "route", r.URL.Path, "consumer", r.Header.Get(ConsumerHeader))
+
+		switch f.Kind {
+		case KindLatency:
+			select {
+			case <-time.After(time.Duration(f.Latency)):
+			case <-r.Context().Done():
+				return
+			}
+			next.ServeHTTP(w, r)
+		case KindError:
+			writeError(w, r, f.Status, fmt.Sprintf("injected fault: %d %s", f.Status, http.StatusText(f.Status)))
+		case KindDrop:
+			// net/http closes the connection without a response
+			panic(http.ErrAbortHandler)
+		case KindMalformed:
+			status, body := capture(w, r, next)
+			w.WriteHeader(status)
+			w.Write(body[:len(body)/2])
+		case KindViolation:
+			status, body := capture(w, r, next)
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"data":%s}`, bytes.TrimSpace(body))
+		}
+	})
+}
+
+// capture handles r with next, which sets its headers on w, and returns the
+// status and body it wrote instead of sending them.
+func capture(w http.ResponseWriter, r *http.Request, next http.Handler) (int, []byte) {
+	buf := &bufferWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(buf, r)
+	w.Header().Del("Content-Length")
+	if len(bytes.TrimSpace(buf.body.Bytes())) == 0 {
+		buf.body.WriteString("null")
+	}
+	return buf.status, buf.body.Bytes()
+}
+
+// bufferWriter holds back the status and body written to it.
+type bufferWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferWriter) WriteHeader(status int) { b.status = status }
+
+func (b *bufferWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (b *bufferWriter) Unwrap() http.ResponseWriter { return b.ResponseWriter }
+
+// AdminHandler serves the admin API: GET returns the faults, PUT replaces
+// them with a Config body and DELETE removes them all.
+func (i *Injector) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "failed to read the fault configuration")
+				return
+			}
+			config, err := Parse(data)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			i.Set(config)
+			logging.FromContext(r.Context()).Info("faults replaced", "faults", len(config.Faults))
+		case http.MethodDelete:
+			i.Set(Config{})
+			logging.FromContext(r.Context()).Info("faults cleared")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i.Config())
+	})
+}
+
+// RegisterRoutes registers the admin API on mux, whose fallback answers
+// other methods with a 405. With authenticators, only callers whose
+// credentials list AdminGrant may use it; others get a 401 or 403.
+func (i *Injector) RegisterRoutes(mux *http.ServeMux, authenticators ...auth.Authenticator) {
+	admin := i.AdminHandler()
+	if len(authenticators) > 0 {
+		admin = auth.Require(AdminGrant, authenticators...)(admin)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		mux.Handle(method+" "+AdminPath, admin)
+	}
+}
+
+// writeError writes a JSON error response in the contract's Error shape.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{Error: message, RequestID: logging.RequestID(r.Context())})
+}
@@ -0,0 +1,181 @@
+// Package grpcapi serves the Calculator's operations as the gRPC
+// CalculatorService defined in calculator.proto.
+//
+// Every RPC runs the registry's Operation of the same name, so the
+// arithmetic and the domain errors are the REST API's, and errors map to
+// status codes the way the REST API maps them to HTTP statuses: a missing
+// operand or a zero divisor is INVALID_ARGUMENT where REST answers 400. Calls
+// count against the same rate and concurrency limits as REST requests, and
+// fail with RESOURCE_EXHAUSTED where REST answers 429 and UNAVAILABLE where
+// it answers 503, with the seconds to wait in retry-after metadata. CVT
+// validates OpenAPI contracts, so calls are validated as the REST
+// interaction they correspond to (see Interaction).
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterKey is the response metadata telling a limited caller how many
+// seconds to wait, as Retry-After does over HTTP.
+const RetryAfterKey = "retry-after"
+
+// Server implements calculatorpb.CalculatorServiceServer with the
+// operations of a handlers.Registry.
+type Server struct {
+	calculatorpb.UnimplementedCalculatorServiceServer
+	registry *handlers.Registry
+}
+
+// NewServer creates a server for the operations in registry.
+func NewServer(registry *handlers.Registry) *Server {
+	return &Server{registry: registry}
+}
+
+// Add implements calculatorpb.CalculatorServiceServer.
+func (s *Server) Add(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	return s.call(ctx, "add", req)
+}
+
+// Subtract implements calculatorpb.CalculatorServiceServer.
+func (s *Server) Subtract(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	return s.call(ctx, "subtract", req)
+}
+
+// Multiply implements calculatorpb.CalculatorServiceServer.
+func (s *Server) Multiply(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	return s.call(ctx, "multiply", req)
+}
+
+// Divide implements calculatorpb.CalculatorServiceServer.
+func (s *Server) Divide(ctx context.Context, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	return s.call(ctx, "divide", req)
+}
+
+// call runs the named operation on the request's operands.
+func (s *Server) call(ctx context.Context, name string, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	op, ok := s.registry.Lookup(name)
+	if !ok || op.Arity() > 2 {
+		return nil, status.Errorf(codes.Unimplemented, "operation %s is not served over gRPC", name)
+	}
+
+	operands := make([]float64, 0, op.Arity())
+	for _, operand := range requestOperands(req)[:op.Arity()] {
+		if operand == nil {
+			return nil, status.Error(codes.InvalidArgument, handlers.MissingOperandsMessage(op.Arity()))
+		}
+		operands = append(operands, *operand)
+	}
+
+	result, err := s.registry.Calculate(ctx, op, operands, clientKey(ctx))
+	var limited *handlers.LimitError
+	switch {
+	case errors.As(err, &limited):
+		grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, strconv.Itoa(limited.RetryAfter)))
+		code := codes.ResourceExhausted
+		if limited.Status == http.StatusServiceUnavailable {
+			code = codes.Unavailable
+		}
+		return nil, status.Error(code, limited.Message)
+	case errors.Is(err, handlers.ErrResultNotRepresentable):
+		return nil, status.Error(codes.Internal, handlers.InternalErrorMessage)
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &calculatorpb.OperationResponse{Result: result}, nil
+}
+
+// clientKey identifies the caller for the rate limit: by the principal
+// Authenticate stored in ctx, or otherwise by its address.
+func clientKey(ctx context.Context) string {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	return ratelimit.Client(ctx, addr)
+}
+
+// requestOperands returns the request's operands in order, nil where unset.
+func requestOperands(req *calculatorpb.OperationRequest) []*float64 {
+	return []*float64{req.X, req.Y}
+}
+
+// OperationName returns the operationId a CalculatorService method
+// corresponds to, e.g. "divide" for "/calculator.v1.CalculatorService/Divide".
+func OperationName(fullMethod string) (string, bool) {
+	service, method := path.Split(fullMethod)
+	if service != "/"+calculatorpb.CalculatorService_ServiceDesc.ServiceName+"/" {
+		return "", false
+	}
+	return strings.ToLower(method), true
+}
+
+// HTTPStatus returns the status the REST API answers with where the gRPC
+// API fails with code.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Interaction describes a call of the named operation as the REST
+// interaction it corresponds to: a GET of /<operation> with the operands as
+// query parameters, answered with a Result or an Error body and the status
+// HTTPStatus maps err to.
+func Interaction(operation string, req *calculatorpb.OperationRequest, resp *calculatorpb.OperationResponse, err error) *producer.Interaction {
+	query := url.Values{}
+	for i, operand := range requestOperands(req) {
+		if operand != nil {
+			query.Set(handlers.OperandNames(i + 1)[i], strconv.FormatFloat(*operand, 'f', -1, 64))
+		}
+	}
+	requestPath := "/" + operation
+	if len(query) > 0 {
+		requestPath += "?" + query.Encode()
+	}
+
+	var body any = handlers.ResultResponse{Result: resp.GetResult()}
+	if err != nil {
+		body = handlers.ErrorResponse{Error: status.Convert(err).Message()}
+	}
+	encoded, _ := json.Marshal(body)
+
+	return &producer.Interaction{
+		Method:          http.MethodGet,
+		Path:            requestPath,
+		Headers:         map[string]string{},
+		StatusCode:      HTTPStatus(status.Code(err)),
+		ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+		ResponseBody:    string(encoded),
+	}
+}
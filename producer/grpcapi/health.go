@@ -0,0 +1,94 @@
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultHealthWatchInterval is how often Watch re-checks the producer.
+const DefaultHealthWatchInterval = 5 * time.Second
+
+// HealthServer implements the grpc.health.v1 service from a health.Checker,
+// so gRPC clients see the status /ready reports: SERVING while the producer
+// is healthy or degraded, NOT_SERVING while it is unhealthy. It answers for
+// the overall server ("") and for the CalculatorService.
+type HealthServer struct {
+	healthpb.UnimplementedHealthServer
+	checker  *health.Checker
+	interval time.Duration
+}
+
+// NewHealthServer creates a health service reporting checker's status, which
+// Watch re-checks every interval.
+func NewHealthServer(checker *health.Checker, interval time.Duration) *HealthServer {
+	return &HealthServer{checker: checker, interval: interval}
+}
+
+// Check implements healthpb.HealthServer.
+func (s *HealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !knownService(req.GetService()) {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: s.status(ctx)}, nil
+}
+
+// List implements healthpb.HealthServer.
+func (s *HealthServer) List(ctx context.Context, req *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	serving := &healthpb.HealthCheckResponse{Status: s.status(ctx)}
+	return &healthpb.HealthListResponse{Statuses: map[string]*healthpb.HealthCheckResponse{
+		"": serving,
+		calculatorpb.CalculatorService_ServiceDesc.ServiceName: serving,
+	}}, nil
+}
+
+// Watch implements healthpb.HealthServer. It sends the current status, then
+// every change until the client goes away; an unknown service is reported
+// as SERVICE_UNKNOWN, as the protocol requires.
+func (s *HealthServer) Watch(req *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	ctx := stream.Context()
+	if !knownService(req.GetService()) {
+		if err := stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		if current := s.status(ctx); current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// status maps the checker's report to a serving status.
+func (s *HealthServer) status(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	if s.checker.Check(ctx).Status == health.StatusUnhealthy {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// knownService reports whether the health service answers for service.
+func knownService(service string) bool {
+	return service == "" || service == calculatorpb.CalculatorService_ServiceDesc.ServiceName
+}
@@ -0,0 +1,92 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Recover turns a panicking RPC into an INTERNAL error carrying the same
+// message as the REST API's 500, and logs the panic.
+func Recover() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				slog.ErrorContext(ctx, "RPC panicked",
+					"method", info.FullMethod, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+				resp, err = nil, status.Error(codes.Internal, handlers.InternalErrorMessage)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// Authenticate requires the same credentials of CalculatorService calls as
+// the REST API requires of its operations, sent as x-api-key or
+// authorization metadata. Without valid credentials a call fails with
+// UNAUTHENTICATED, and with credentials that do not cover the operation with
+// PERMISSION_DENIED.
+func Authenticate(authenticators ...auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		operation, ok := OperationName(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		// Authenticators read credentials from HTTP headers
+		md, _ := metadata.FromIncomingContext(ctx)
+		r := (&http.Request{Header: http.Header{}}).WithContext(ctx)
+		for _, key := range []string{auth.APIKeyHeader, "Authorization"} {
+			for _, value := range md.Get(key) {
+				r.Header.Add(key, value)
+			}
+		}
+
+		principal, err := auth.Authenticate(r, authenticators...)
+		switch {
+		case errors.Is(err, auth.ErrNoCredentials):
+			return nil, status.Error(codes.Unauthenticated, "Authentication required")
+		case err != nil:
+			return nil, status.Error(codes.Unauthenticated, "Invalid credentials: "+err.Error())
+		case !principal.Allows(operation):
+			return nil, status.Errorf(codes.PermissionDenied, "%s may not call %s", principal.Name, operation)
+		}
+		return handler(auth.NewContext(ctx, principal), req)
+	}
+}
+
+// Validate validates every CalculatorService call against the contract as
+// its REST interaction. In strict mode a call whose interaction does not
+// comply fails with INTERNAL; otherwise failures are only reported by
+// validator, as in the HTTP middleware's warn and shadow modes. Calls go
+// through when CVT cannot be reached.
+func Validate(validator producer.Validator, schemaID string, mode producer.ValidationMode) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		operation, ok := OperationName(info.FullMethod)
+		request, isOperation := req.(*calculatorpb.OperationRequest)
+		if !ok || !isOperation {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+		response, _ := resp.(*calculatorpb.OperationResponse)
+
+		result, verr := validator.Validate(ctx, schemaID, Interaction(operation, request, response, err))
+		if verr == nil && !result.Valid && mode == producer.ModeStrict {
+			return nil, status.Errorf(codes.Internal, "response does not comply with the contract: %v", result.Errors)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sahina/cvt-demo/producer/cache"
+	"github.com/sahina/cvt-demo/producer/contract"
+)
+
+// Operation results are pure functions of their operands, so a 200 result is
+// cacheable: it carries a strong ETag derived from its body and a
+// Cache-Control header, and a request whose If-None-Match matches the ETag
+// gets a 304 without a body. Error responses are not cacheable.
+
+// ResultCacheControl is the Cache-Control header of a successful result.
+const ResultCacheControl = "public, max-age=3600"
+
+// NotModifiedResponse is the component response describing the 304 in the
+// OpenAPI document.
+const NotModifiedResponse = "NotModified"
+
+// Component headers describing the caching headers in the OpenAPI document.
+const (
+	ETagComponent         = "ETag"
+	CacheControlComponent = "CacheControl"
+)
+
+// ResultCacheObserver is told about every lookup in the result cache.
+type ResultCacheObserver interface {
+	ResultCacheHit(operation string)
+	ResultCacheMiss(operation string)
+}
+
+// ErrResultNotRepresentable is returned for a result JSON cannot encode,
+// such as the infinity an overflowing addition yields. It is answered as an
+// internal error, as the Result schema has no value for it.
+var ErrResultNotRepresentable = errors.New("result is not a finite number")
+
+// cachedResult is a result, its encoding and its entity tag.
+type cachedResult struct {
+	result float64
+	body   []byte
+	etag   string
+}
+
+func newCachedResult(result float64) (cachedResult, error) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(ResultResponse{Result: result}); err != nil {
+		return cachedResult{}, fmt.Errorf("%w: %v", ErrResultNotRepresentable, err)
+	}
+	return cachedResult{result: result, body: body.Bytes(), etag: cache.ETag(body.Bytes())}, nil
+}
+
+// resultCache holds encoded results by operation and operands. Without an
+// LRU it is disabled.
+type resultCache struct {
+	lru      *cache.LRU[string, cachedResult]
+	observer ResultCacheObserver
+}
+
+// EnableResultCache keeps the last size results in memory, so repeated
+// calculations skip computing and encoding. observer, if not nil, is told
+// about every hit and miss. Call it before serving.
+func (r *Registry) EnableResultCache(size int, observer ResultCacheObserver) {
+	r.results.lru = cache.NewLRU[string, cachedResult](size)
+	r.results.observer = observer
+}
+
+// get returns the cached result of op for operands, computing and caching it
+// on a miss. A nil or disabled cache always computes. Results that cannot be
+// encoded are never cached.
+func (c *resultCache) get(op Operation, operands []float64) (cachedResult, bool, error) {
+	if c == nil || c.lru == nil {
+		result, err := newCachedResult(op.Compute(operands))
+		return result, false, err
+	}
+
+	key := resultKey(op.Name(), operands)
+	if result, ok := c.lru.Get(key); ok {
+		if c.observer != nil {
+			c.observer.ResultCacheHit(op.Name())
+		}
+		return result, true, nil
+	}
+	if c.observer != nil {
+		c.observer.ResultCacheMiss(op.Name())
+	}
+
+	result, err := newCachedResult(op.Compute(operands))
+	if err != nil {
+		return cachedResult{}, false, err
+	}
+	c.lru.Add(key, result)
+	return result, false, nil
+}
+
+// resultKey identifies a calculation. Operands are formatted canonically, so
+// x=5 and x=5.0 share an entry.
+func resultKey(operation string, operands []float64) string {
+	parts := make([]string, 0, len(operands)+1)
+	parts = append(parts, operation)
+	for _, operand := range operands {
+		parts = append(parts, strconv.FormatFloat(operand, 'g', -1, 64))
+	}
+	return strings.Join(parts, "|")
+}
+
+// writeCachedResult writes a successful result with its caching headers, or
+// a 304 if the client already has it.
+func writeCachedResult(w http.ResponseWriter, r *http.Request, result cachedResult) {
+	w.Header().Set("ETag", result.etag)
+	w.Header().Set("Cache-Control", ResultCacheControl)
+	if cache.NoneMatch(r.Header.Get("If-None-Match"), result.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.body)
+}
+
+// cacheableResponse adds the caching headers to a documented response.
+func cacheableResponse(response contract.Response) contract.Response {
+	return withHeaders(response, map[string]contract.Header{
+		"ETag":          {Ref: "#/components/headers/" + ETagComponent},
+		"Cache-Control": {Ref: "#/components/headers/" + CacheControlComponent},
+	})
+}
@@ -4,7 +4,12 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
+
+	"github.com/sahina/cvt-demo/producer/buildinfo"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/health"
+	"github.com/sahina/cvt-demo/producer/idempotency"
+	"github.com/sahina/cvt-demo/producer/logging"
 )
 
 // ResultResponse represents a successful calculation result.
@@ -14,138 +19,165 @@ type ResultResponse struct {
 
 // ErrorResponse represents an error response.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
-// HealthResponse represents a health check response.
-type HealthResponse struct {
-	Status string `json:"status"`
-}
+// Paths of the health and readiness probes.
+const (
+	HealthPath = "/health"
+	ReadyPath  = "/ready"
+)
+
+// HealthResponse represents a /health or /ready response.
+type HealthResponse = health.Report
+
+// VersionResponse represents a /version response.
+type VersionResponse = buildinfo.Info
 
 // Calculator handles all calculator operations.
-type Calculator struct{}
+type Calculator struct {
+	registry    *Registry
+	health      *health.Checker
+	build       buildinfo.Info
+	stream      StreamConfig
+	events      EventsConfig
+	idempotency *idempotency.Guard
+}
 
-// NewCalculator creates a new Calculator instance.
+// NewCalculator creates a new Calculator serving the built-in operations.
 func NewCalculator() *Calculator {
-	return &Calculator{}
+	registry, err := NewRegistry(BuiltinOperations()...)
+	if err != nil {
+		panic(err) // built-in operations are static; this is a programming error
+	}
+	return &Calculator{registry: registry, health: health.NewChecker(), build: buildinfo.Read(""),
+		stream: DefaultStreamConfig(), events: DefaultEventsConfig(),
+		idempotency: idempotency.New(idempotency.NewMemoryStore(), idempotency.DefaultTTL)}
 }
 
-// RegisterRoutes registers all calculator routes on the given mux.
-func (c *Calculator) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/add", c.Add)
-	mux.HandleFunc("/subtract", c.Subtract)
-	mux.HandleFunc("/multiply", c.Multiply)
-	mux.HandleFunc("/divide", c.Divide)
-	mux.HandleFunc("/health", c.Health)
+// Registry returns the operations the Calculator serves.
+func (c *Calculator) Registry() *Registry {
+	return c.registry
 }
 
-// Add handles the /add endpoint.
-func (c *Calculator) Add(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	x, y, ok := parseNumbers(w, r)
-	if !ok {
-		return
-	}
+// HealthChecker returns the checker behind /health and /ready, which the
+// producer updates as it starts up.
+func (c *Calculator) HealthChecker() *health.Checker {
+	return c.health
+}
 
-	writeResult(w, x+y)
+// SetBuildInfo replaces the build information served at /version. Call it
+// before serving, once the enforced schema version is known.
+func (c *Calculator) SetBuildInfo(info buildinfo.Info) {
+	c.build = info
 }
 
-// Subtract handles the /subtract endpoint.
-func (c *Calculator) Subtract(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// SetIdempotency replaces the guard that applies Idempotency-Key headers to
+// non-GET routes, which by default keeps responses in memory for
+// idempotency.DefaultTTL. Call it before serving.
+func (c *Calculator) SetIdempotency(guard *idempotency.Guard) {
+	c.idempotency = guard
+}
 
-	x, y, ok := parseNumbers(w, r)
-	if !ok {
-		return
-	}
+// Route is a single method and path served by the Calculator.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
 
-	writeResult(w, x-y)
+// Routes returns every route the Calculator serves to consumers, in
+// registration order.
+func (c *Calculator) Routes() []Route {
+	return append(c.registry.Routes(),
+		Route{Method: http.MethodGet, Path: "/version", Handler: c.Version},
+		Route{Method: http.MethodGet, Path: StreamPath, Handler: c.Stream},
+		Route{Method: http.MethodGet, Path: EventsPath, Handler: c.Events},
+	)
 }
 
-// Multiply handles the /multiply endpoint.
-func (c *Calculator) Multiply(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// OperationalRoutes returns the health and readiness probes, which are left
+// out of the contract and served on the admin listener instead.
+func (c *Calculator) OperationalRoutes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: HealthPath, Handler: c.Health},
+		{Method: http.MethodGet, Path: ReadyPath, Handler: c.Ready},
 	}
+}
 
-	x, y, ok := parseNumbers(w, r)
-	if !ok {
-		return
+// RegisterRoutes registers all calculator routes on the given mux as
+// method-aware patterns, along with the fallback that answers unmatched
+// requests with a JSON 404 or 405. Routes other than GETs honour
+// Idempotency-Key, so a retried request is never applied twice. It returns
+// the routes it registered, for the parity check against the contract.
+func (c *Calculator) RegisterRoutes(mux *http.ServeMux) []contract.Route {
+	var registered []contract.Route
+	for _, route := range c.Routes() {
+		handler := route.Handler
+		if route.Method != http.MethodGet {
+			handler = c.idempotent(handler)
+		}
+		mux.HandleFunc(route.Method+" "+route.Path, handler)
+		registered = append(registered, contract.Route{Method: route.Method, Path: route.Path})
 	}
-
-	writeResult(w, x*y)
+	RegisterFallback(mux)
+	return registered
 }
 
-// Divide handles the /divide endpoint.
-func (c *Calculator) Divide(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// idempotent applies the Calculator's idempotency guard to next, looking
+// it up per request so SetIdempotency may follow RegisterRoutes.
+func (c *Calculator) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.idempotency.Serve(w, r, next)
 	}
+}
 
-	x, y, ok := parseNumbers(w, r)
+// Handler returns the generated handler for the named operation, or nil if
+// no such operation is registered.
+func (c *Calculator) Handler(name string) http.HandlerFunc {
+	op, ok := c.registry.Lookup(name)
 	if !ok {
-		return
-	}
-
-	if y == 0 {
-		writeError(w, "division by zero is not allowed", http.StatusBadRequest)
-		return
+		return nil
 	}
-
-	writeResult(w, x/y)
+	return c.registry.Handler(op)
 }
 
-// Health handles the /health endpoint.
+// Health handles the /health endpoint. It reports the producer's status and
+// dependency checks, and returns 200 whenever the process can answer.
 func (c *Calculator) Health(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(HealthResponse{Status: "healthy"})
+	writeJSON(w, http.StatusOK, c.health.Check(r.Context()))
 }
 
-// parseNumbers extracts and validates query parameters x and y.
-func parseNumbers(w http.ResponseWriter, r *http.Request) (float64, float64, bool) {
-	xStr := r.URL.Query().Get("x")
-	yStr := r.URL.Query().Get("y")
-
-	if xStr == "" || yStr == "" {
-		writeError(w, "missing required parameters 'x' and 'y'", http.StatusBadRequest)
-		return 0, 0, false
-	}
-
-	x, err := strconv.ParseFloat(xStr, 64)
-	if err != nil {
-		writeError(w, "parameter 'x' must be a valid number", http.StatusBadRequest)
-		return 0, 0, false
-	}
+// Ready handles the /ready endpoint. It returns the same report as /health,
+// with 503 while the producer is unhealthy so it is taken out of rotation.
+func (c *Calculator) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.health.Check(r.Context())
 
-	y, err := strconv.ParseFloat(yStr, 64)
-	if err != nil {
-		writeError(w, "parameter 'y' must be a valid number", http.StatusBadRequest)
-		return 0, 0, false
+	status := http.StatusOK
+	if report.Status == health.StatusUnhealthy {
+		status = http.StatusServiceUnavailable
 	}
-
-	return x, y, true
+	writeJSON(w, status, report)
 }
 
-// writeResult writes a successful result response.
-func writeResult(w http.ResponseWriter, result float64) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(ResultResponse{Result: result})
+// Version handles the /version endpoint. It reports the build the producer
+// is running and the schema version it enforces.
+func (c *Calculator) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.build)
 }
 
-// writeError writes an error response.
-func writeError(w http.ResponseWriter, message string, statusCode int) {
+// writeJSON writes body as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error response tagged with the request ID and logs it.
+func writeError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
+	logging.FromContext(r.Context()).Debug("request rejected",
+		"method", r.Method, "path", r.URL.Path, "status", statusCode, "error", message)
+
+	writeJSON(w, statusCode, ErrorResponse{Error: message, RequestID: logging.RequestID(r.Context())})
 }
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/events"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// Events. GET /events is a Server-Sent Events stream with one event per
+// calculation the producer completes, over any API. Each event has an id, the
+// event type "calculation" and a CalculationEvent, a component schema of the
+// contract, as its JSON data. A client that reconnects with the id of the
+// last event it received in Last-Event-ID first gets the events it missed,
+// as far as the feed's replay buffer reaches. Comment lines keep an idle
+// stream open through proxies.
+//
+// The stream never ends, so CVT, which validates complete responses, does
+// not see /events; consumers hold the events to the CalculationEvent schema.
+
+// EventsPath is where the Calculator serves the calculation feed.
+const EventsPath = "/events"
+
+// CalculationEventType is the SSE event type of every calculation event.
+const CalculationEventType = "calculation"
+
+// InvalidLastEventIDMessage is the error sent with a 400 when Last-Event-ID
+// is not an event id.
+const InvalidLastEventIDMessage = "invalid Last-Event-ID: want the id of an event"
+
+// EventsConfig tunes the /events feed.
+type EventsConfig struct {
+	// ReplaySize is how many recent events a reconnecting client can catch
+	// up on.
+	ReplaySize int
+	// KeepaliveInterval is how often an idle stream gets a comment line.
+	KeepaliveInterval time.Duration
+}
+
+// DefaultEventsConfig returns the settings used unless SetEventsConfig is called.
+func DefaultEventsConfig() EventsConfig {
+	return EventsConfig{ReplaySize: events.DefaultReplaySize, KeepaliveInterval: 15 * time.Second}
+}
+
+// ParseEventsConfig parses the EVENTS_REPLAY_SIZE setting over the
+// defaults; empty keeps the default and 0 disables resumption.
+func ParseEventsConfig(replaySize string) (EventsConfig, error) {
+	config := DefaultEventsConfig()
+	if replaySize != "" {
+		n, err := strconv.Atoi(replaySize)
+		if err != nil || n < 0 {
+			return EventsConfig{}, fmt.Errorf("invalid event replay size %q (want a number of events)", replaySize)
+		}
+		config.ReplaySize = n
+	}
+	return config, nil
+}
+
+// SetEventsConfig replaces the /events settings. Call it before serving.
+func (c *Calculator) SetEventsConfig(config EventsConfig) {
+	c.events = config
+	c.registry.feed.SetReplaySize(config.ReplaySize)
+}
+
+// Events handles the /events endpoint, streaming calculation events until
+// the client goes away.
+func (c *Calculator) Events(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	header := r.Header.Get("Last-Event-ID")
+	resume := header != ""
+	if resume {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			writeError(w, r, InvalidLastEventIDMessage, http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	replay, subscription := c.registry.feed.Subscribe(lastID, resume)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	logger := logging.FromContext(r.Context())
+	logger.Debug("event stream opened", "last_event_id", header, "replayed", len(replay))
+	for _, event := range replay {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	rc.Flush()
+
+	keepalive := time.NewTicker(c.events.KeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			if !ok {
+				// Fell behind; the client resumes from the replay buffer
+				logger.Debug("event stream dropped a slow client")
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			logger.Debug("event stream closed")
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// writeEvent writes event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event.Calculation)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, CalculationEventType, data)
+	return err
+}
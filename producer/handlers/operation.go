@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Operation is a calculator operation served as GET /<name>, or with the
+// method its spec names, with its operands passed as the query parameters
+// x, y and z in order.
+type Operation interface {
+	// Name is the path segment and the spec operationId.
+	Name() string
+	// Arity is the number of operands, between 1 and len(operandNames).
+	Arity() int
+	// Validate checks domain constraints on parsed operands, e.g. a zero divisor.
+	// The returned error's message is sent to the client with a 400.
+	Validate(operands []float64) error
+	// Compute returns the result for validated operands.
+	Compute(operands []float64) float64
+	// Spec describes the operation for the OpenAPI document.
+	Spec() OperationSpec
+}
+
+// OperationSpec is the OpenAPI metadata of an Operation.
+type OperationSpec struct {
+	Summary string
+	// Operands describes each operand, in order.
+	Operands []string
+	// InvalidInput describes the 400 response.
+	InvalidInput string
+	// Method is the HTTP method the operation is served with; empty means
+	// GET. Operations served with any other method honour Idempotency-Key.
+	Method string
+}
+
+// method returns the HTTP method op is served with.
+func method(op Operation) string {
+	if m := op.Spec().Method; m != "" {
+		return m
+	}
+	return http.MethodGet
+}
+
+// ErrDivisionByZero is returned by the divide operation for a zero divisor.
+var ErrDivisionByZero = errors.New("division by zero is not allowed")
+
+// binaryOperation is an Operation on x and y built from plain functions.
+type binaryOperation struct {
+	name     string
+	compute  func(x, y float64) float64
+	validate func(x, y float64) error
+	spec     OperationSpec
+}
+
+func (o binaryOperation) Name() string { return o.name }
+
+func (o binaryOperation) Arity() int { return 2 }
+
+func (o binaryOperation) Validate(operands []float64) error {
+	if o.validate == nil {
+		return nil
+	}
+	return o.validate(operands[0], operands[1])
+}
+
+func (o binaryOperation) Compute(operands []float64) float64 {
+	return o.compute(operands[0], operands[1])
+}
+
+func (o binaryOperation) Spec() OperationSpec { return o.spec }
+
+// BuiltinOperations returns the four arithmetic operations of the Calculator API.
+func BuiltinOperations() []Operation {
+	return []Operation{
+		binaryOperation{
+			name:    "add",
+			compute: func(x, y float64) float64 { return x + y },
+			spec: OperationSpec{
+				Summary:      "Add two numbers",
+				Operands:     []string{"First number", "Second number"},
+				InvalidInput: "Invalid input",
+			},
+		},
+		binaryOperation{
+			name:    "subtract",
+			compute: func(x, y float64) float64 { return x - y },
+			spec: OperationSpec{
+				Summary:      "Subtract two numbers",
+				Operands:     []string{"First number (minuend)", "Second number (subtrahend)"},
+				InvalidInput: "Invalid input",
+			},
+		},
+		binaryOperation{
+			name:    "multiply",
+			compute: func(x, y float64) float64 { return x * y },
+			spec: OperationSpec{
+				Summary:      "Multiply two numbers",
+				Operands:     []string{"First number", "Second number"},
+				InvalidInput: "Invalid input",
+			},
+		},
+		binaryOperation{
+			name:    "divide",
+			compute: func(x, y float64) float64 { return x / y },
+			validate: func(x, y float64) error {
+				if y == 0 {
+					return ErrDivisionByZero
+				}
+				return nil
+			},
+			spec: OperationSpec{
+				Summary:      "Divide two numbers",
+				Operands:     []string{"Dividend", "Divisor (cannot be zero)"},
+				InvalidInput: "Invalid input or division by zero",
+			},
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt-demo/producer/response"
+)
+
+// InternalErrorMessage is the error sent to clients when a handler panics.
+// The panic value itself is only logged.
+const InternalErrorMessage = "internal server error"
+
+// InternalErrorDescription describes the 500 response in the OpenAPI document.
+const InternalErrorDescription = "Internal server error"
+
+// Recover turns a panicking handler into a 500 ErrorResponse, which the
+// contract documents for every operation, and logs the panic with its stack
+// and request ID. It belongs inside the CVT middleware so the 500 is
+// validated like any other response.
+//
+// If the handler already started its response, the status can no longer be
+// changed; the panic is logged and re-raised as http.ErrAbortHandler, so
+// net/http aborts the connection rather than let a truncated response pass
+// as complete. http.ErrAbortHandler itself is re-raised unlogged.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := response.NewRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			logging.FromContext(r.Context()).LogAttrs(r.Context(), slog.LevelError, "handler panicked",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("panic", fmt.Sprint(v)),
+				slog.String("stack", string(debug.Stack())),
+				slog.Bool("response_started", rec.Started()),
+			)
+			if rec.Started() {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSON(w, http.StatusInternalServerError,
+				ErrorResponse{Error: InternalErrorMessage, RequestID: logging.RequestID(r.Context())})
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
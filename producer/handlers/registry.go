@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/cors"
+	"github.com/sahina/cvt-demo/producer/events"
+	"github.com/sahina/cvt-demo/producer/idempotency"
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt-demo/producer/overload"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+)
+
+// operandNames are the query parameters operands are read from, in order.
+var operandNames = []string{"x", "y", "z"}
+
+// Registry holds the operations the Calculator serves and generates their
+// HTTP handlers, routes and OpenAPI path items.
+type Registry struct {
+	operations []Operation
+	byName     map[string]Operation
+	results    *resultCache       // disabled unless EnableResultCache is called
+	limiter    *ratelimit.Limiter // nil unless EnableRateLimit is called
+	overload   *overload.Limiter  // nil unless EnableConcurrencyLimit is called
+	feed       *events.Feed       // the calculations served at /events
+}
+
+// NewRegistry creates a registry holding the given operations.
+func NewRegistry(ops ...Operation) (*Registry, error) {
+	r := &Registry{byName: make(map[string]Operation), results: &resultCache{},
+		feed: events.NewFeed(events.DefaultReplaySize)}
+	for _, op := range ops {
+		if err := r.Register(op); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds an operation. Names must be unique and the arity supported.
+func (r *Registry) Register(op Operation) error {
+	if op.Name() == "" {
+		return fmt.Errorf("operation has no name")
+	}
+	if _, ok := r.byName[op.Name()]; ok {
+		return fmt.Errorf("operation %q already registered", op.Name())
+	}
+	if op.Arity() < 1 || op.Arity() > len(operandNames) {
+		return fmt.Errorf("operation %q: arity %d not supported", op.Name(), op.Arity())
+	}
+	if len(op.Spec().Operands) != op.Arity() {
+		return fmt.Errorf("operation %q: %d operand descriptions for arity %d", op.Name(), len(op.Spec().Operands), op.Arity())
+	}
+
+	r.operations = append(r.operations, op)
+	r.byName[op.Name()] = op
+	return nil
+}
+
+// Operations returns the registered operations in registration order.
+func (r *Registry) Operations() []Operation {
+	return append([]Operation(nil), r.operations...)
+}
+
+// Lookup returns the operation with the given name.
+func (r *Registry) Lookup(name string) (Operation, bool) {
+	op, ok := r.byName[name]
+	return op, ok
+}
+
+// Routes returns a route per operation, served with the operation's method.
+func (r *Registry) Routes() []Route {
+	routes := make([]Route, 0, len(r.operations))
+	for _, op := range r.operations {
+		routes = append(routes, Route{Method: method(op), Path: "/" + op.Name(), Handler: r.Handler(op)})
+	}
+	return routes
+}
+
+// SpecPaths returns the OpenAPI path items for every operation, keyed by path
+// and lower-case method, exactly as they must appear in calculator-api.yaml.
+func (r *Registry) SpecPaths() map[string]map[string]contract.Operation {
+	paths := make(map[string]map[string]contract.Operation, len(r.operations))
+	for _, op := range r.operations {
+		paths["/"+op.Name()] = map[string]contract.Operation{strings.ToLower(method(op)): specOperation(op)}
+	}
+	return paths
+}
+
+// Handler generates the HTTP handler for op, rate limiting requests,
+// limiting how many run at once and serving results from the result cache
+// when they are enabled.
+func (r *Registry) Handler(op Operation) http.HandlerFunc {
+	handler := operationHandler(op, r.results, r.feed)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.limiter.Admit(w, req, op.Name()) {
+			return
+		}
+		release, ok := r.overload.Admit(w, req, op.Name())
+		if !ok {
+			return
+		}
+		defer release()
+		handler(w, req)
+	}
+}
+
+// EnableRateLimit rate limits every operation with limiter, which answers
+// clients over their limit with a 429. Call it before serving.
+func (r *Registry) EnableRateLimit(limiter *ratelimit.Limiter) {
+	r.limiter = limiter
+}
+
+// EnableConcurrencyLimit limits how many requests to each operation run at
+// once with limiter, which sheds the requests it cannot admit in time with a
+// 503. Call it before serving.
+func (r *Registry) EnableConcurrencyLimit(limiter *overload.Limiter) {
+	r.overload = limiter
+}
+
+// OperationHandler generates the HTTP handler for op: it parses the operands, applies domain validation and writes the result.
+func OperationHandler(op Operation) http.HandlerFunc {
+	return operationHandler(op, nil, nil)
+}
+
+func operationHandler(op Operation, results *resultCache, feed *events.Feed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operands, ok := parseOperands(w, r, op.Arity())
+		if !ok {
+			return
+		}
+
+		result, err := calculate(r.Context(), op, operands, results, feed)
+		switch {
+		case errors.Is(err, ErrResultNotRepresentable):
+			writeError(w, r, InternalErrorMessage, http.StatusInternalServerError)
+			return
+		case err != nil:
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeCachedResult(w, r, result)
+	}
+}
+
+// LimitError is returned by Calculate when the rate or concurrency limit of
+// the operation rejects a calculation.
+type LimitError struct {
+	// Status is what the REST API answers with: 429 for a client over its
+	// rate limit, 503 for an overloaded operation.
+	Status int
+	// RetryAfter is how long to wait before retrying, in seconds.
+	RetryAfter int
+	Message    string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Calculate applies the operation's limits and domain validation to operands
+// and computes op, for APIs other than the generated HTTP handlers. client
+// identifies the caller for the rate limit, as ratelimit.ClientKey does for
+// HTTP requests. Like the handlers, it uses the result cache and publishes
+// the calculation to the feed served at /events. The error is a *LimitError,
+// ErrResultNotRepresentable or a domain validation error.
+func (r *Registry) Calculate(ctx context.Context, op Operation, operands []float64, client string) (float64, error) {
+	release, err := r.admit(ctx, op, client)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	result, err := calculate(ctx, op, operands, r.results, r.feed)
+	return result.result, err
+}
+
+// admit applies the rate and concurrency limits the generated HTTP handlers
+// apply to a calculation of op for client. The caller must call release once
+// the calculation is done.
+func (r *Registry) admit(ctx context.Context, op Operation, client string) (release func(), err error) {
+	if r.limiter != nil {
+		if d := r.limiter.Allow(op.Name(), client, time.Now()); !d.Allowed {
+			retryAfter := d.RetryAfterSeconds()
+			return nil, &LimitError{Status: http.StatusTooManyRequests, RetryAfter: retryAfter,
+				Message: ratelimit.ExceededMessage(op.Name(), retryAfter)}
+		}
+	}
+	if r.overload == nil {
+		return func() {}, nil
+	}
+	release, _, ok := r.overload.Acquire(ctx, op.Name())
+	if !ok {
+		retryAfter := r.overload.RetryAfter(op.Name())
+		return nil, &LimitError{Status: http.StatusServiceUnavailable, RetryAfter: retryAfter,
+			Message: overload.OverloadedMessage(op.Name(), retryAfter)}
+	}
+	return release, nil
+}
+
+// calculate validates and computes op, and publishes the outcome to feed,
+// which may be nil.
+func calculate(ctx context.Context, op Operation, operands []float64, results *resultCache, feed *events.Feed) (cachedResult, error) {
+	calculation := events.Calculation{
+		Operation: op.Name(),
+		Inputs:    make(map[string]float64, len(operands)),
+		RequestID: logging.RequestID(ctx),
+	}
+	for i, operand := range operands {
+		calculation.Inputs[operandNames[i]] = operand
+	}
+
+	if err := op.Validate(operands); err != nil {
+		calculation.Error = err.Error()
+		publish(feed, calculation)
+		return cachedResult{}, err
+	}
+
+	result, hit, err := results.get(op, operands)
+	if err != nil {
+		logging.FromContext(ctx).Error("operation result cannot be encoded",
+			"operation", op.Name(), "error", err)
+		calculation.Error = InternalErrorMessage
+		publish(feed, calculation)
+		return cachedResult{}, err
+	}
+	logging.FromContext(ctx).Debug("operation computed",
+		"operation", op.Name(), "operands", operands, "result", result.result, "cached", hit)
+	calculation.Result = &result.result
+	publish(feed, calculation)
+	return result, nil
+}
+
+// publish timestamps calculation and publishes it to feed, if there is one.
+func publish(feed *events.Feed, calculation events.Calculation) {
+	if feed != nil {
+		calculation.Time = time.Now().UTC()
+		feed.Publish(calculation)
+	}
+}
+
+// specOperation builds the OpenAPI operation object for op.
+func specOperation(op Operation) contract.Operation {
+	meta := op.Spec()
+
+	params := make([]contract.Parameter, op.Arity())
+	for i := range params {
+		params[i] = contract.Parameter{
+			Name:        operandNames[i],
+			In:          "query",
+			Required:    true,
+			Description: meta.Operands[i],
+			Schema:      contract.Schema{Type: "number"},
+		}
+	}
+
+	spec := contract.Operation{
+		OperationID: op.Name(),
+		Summary:     meta.Summary,
+		Parameters:  params,
+		Security:    auth.SpecSecurity(),
+		Responses: map[string]contract.Response{
+			"200": limitedResponse(cacheableResponse(jsonResponse("Successful operation", "Result"))),
+			"304": {Ref: "#/components/responses/" + NotModifiedResponse},
+			"400": limitedResponse(jsonResponse(meta.InvalidInput, "Error")),
+			"401": {Ref: "#/components/responses/" + auth.UnauthorizedResponse},
+			"403": {Ref: "#/components/responses/" + auth.ForbiddenResponse},
+			"405": {Ref: "#/components/responses/" + MethodNotAllowedResponse},
+			"429": {Ref: "#/components/responses/" + ratelimit.TooManyRequestsResponse},
+			"500": limitedResponse(jsonResponse(InternalErrorDescription, "Error")),
+			"503": {Ref: "#/components/responses/" + overload.ServiceUnavailableResponse},
+		},
+	}
+	if method(op) == http.MethodGet {
+		return spec
+	}
+
+	// RegisterRoutes puts operations served with other methods behind the
+	// idempotency guard, which replays 200s and 400s and answers on its own
+	spec.Parameters = append(spec.Parameters, idempotency.SpecParameter())
+	for _, status := range []string{"200", "400"} {
+		spec.Responses[status] = withHeaders(spec.Responses[status], idempotency.SpecHeaders())
+	}
+	invalid := spec.Responses["400"]
+	invalid.Description += ", or an " + idempotency.Header + " that is too long"
+	spec.Responses["400"] = invalid
+	for status, response := range idempotency.SpecResponses() {
+		spec.Responses[status] = response
+	}
+	return spec
+}
+
+// jsonResponse describes an application/json response with a component schema
+// and the CORS headers.
+func jsonResponse(description, schema string) contract.Response {
+	return contract.Response{
+		Description: description,
+		Headers:     cors.SpecHeaders(),
+		Content: map[string]contract.MediaType{
+			"application/json": {Schema: contract.Schema{Ref: "#/components/schemas/" + schema}},
+		},
+	}
+}
+
+// limitedResponse adds the rate limit headers to a documented response of an
+// operation.
+func limitedResponse(response contract.Response) contract.Response {
+	return withHeaders(response, ratelimit.SpecHeaders())
+}
+
+// withHeaders returns response with the given headers added.
+func withHeaders(response contract.Response, extra map[string]contract.Header) contract.Response {
+	headers := make(map[string]contract.Header, len(response.Headers)+len(extra))
+	for name, header := range response.Headers {
+		headers[name] = header
+	}
+	for name, header := range extra {
+		headers[name] = header
+	}
+	response.Headers = headers
+	return response
+}
+
+// parseOperands extracts and validates the first n operand query parameters.
+func parseOperands(w http.ResponseWriter, r *http.Request, n int) ([]float64, bool) {
+	names := operandNames[:n]
+	query := r.URL.Query()
+
+	for _, name := range names {
+		if query.Get(name) == "" {
+			writeError(w, r, missingParamsMessage(names), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	operands := make([]float64, n)
+	for i, name := range names {
+		v, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil {
+			writeError(w, r, fmt.Sprintf("parameter '%s' must be a valid number", name), http.StatusBadRequest)
+			return nil, false
+		}
+		operands[i] = v
+	}
+
+	return operands, true
+}
+
+// OperandNames returns the names of the first n operands: the query
+// parameters of an operation, and the request fields of its RPC.
+func OperandNames(n int) []string {
+	return slices.Clone(operandNames[:n])
+}
+
+// MissingOperandsMessage is the error message for a request that lacks some
+// of the n operands of an operation, as the REST and gRPC APIs both send it.
+func MissingOperandsMessage(n int) string {
+	return missingParamsMessage(operandNames[:n])
+}
+
+// missingParamsMessage renders e.g. "missing required parameters 'x' and 'y'".
+func missingParamsMessage(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
+	}
+	if len(quoted) == 1 {
+		return "missing required parameter " + quoted[0]
+	}
+	return "missing required parameters " + strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Routing policy. Routes are registered as method-aware Go 1.22 patterns such
+// as "GET /add", and a request must match one exactly:
+//
+//   - paths are case-sensitive, so /ADD is not /add;
+//   - trailing slashes are significant, so /add/ is not /add;
+//   - unclean paths such as //add or /x/../add are not redirected.
+//
+// Anything else gets a JSON 404, or a JSON 405 with an Allow header when the
+// path exists under other methods. Both are documented in the contract as the
+// NotFound and MethodNotAllowed component responses. A GET route also serves
+// HEAD, as net/http does.
+
+// fallbackPattern catches every request no route matches.
+const fallbackPattern = "/"
+
+// Messages of the routing error responses.
+const (
+	NotFoundMessage         = "not found"
+	MethodNotAllowedMessage = "method not allowed"
+)
+
+// Component responses describing the routing errors in the OpenAPI document.
+const (
+	NotFoundResponse         = "NotFound"
+	MethodNotAllowedResponse = "MethodNotAllowed"
+)
+
+// probeMethods are the methods checked when building an Allow header.
+var probeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// RegisterFallback registers the handler that answers unmatched requests on
+// mux with a JSON 404 or 405. Register it once, after or before the routes;
+// it only receives requests no other pattern matches.
+func RegisterFallback(mux *http.ServeMux) {
+	mux.Handle(fallbackPattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveUnmatched(mux, w, r)
+	}))
+}
+
+// Unmatched answers requests no route on mux matches before they reach next,
+// so the CVT middleware only ever sees requests the contract describes. It
+// belongs outside the CVT middleware, with next ending in mux.
+func Unmatched(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, pattern := mux.Handler(r); pattern == fallbackPattern || pattern == "" || !cleanPath(r.URL.Path) {
+				serveUnmatched(mux, w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// serveUnmatched writes the 404 or 405 for a request no route matches.
+func serveUnmatched(mux *http.ServeMux, w http.ResponseWriter, r *http.Request) {
+	if !cleanPath(r.URL.Path) {
+		writeError(w, r, NotFoundMessage, http.StatusNotFound)
+		return
+	}
+	if allowed := allowedMethods(mux, r); len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, MethodNotAllowedMessage, http.StatusMethodNotAllowed)
+		return
+	}
+	writeError(w, r, NotFoundMessage, http.StatusNotFound)
+}
+
+// allowedMethods returns the methods some route other than the fallback
+// serves for r's path.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, method := range probeMethods {
+		probe := *r
+		probe.Method = method
+		if _, pattern := mux.Handler(&probe); pattern != "" && pattern != fallbackPattern {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// cleanPath reports whether p is already in the canonical form ServeMux
+// would otherwise redirect to.
+func cleanPath(p string) bool {
+	if p == "" || p[0] != '/' {
+		return false
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned == p
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+)
+
+// Streaming. GET /ws upgrades to a WebSocket over which a client sends
+// StreamRequest frames and gets one StreamMessage frame back per request, in
+// order, plus a heartbeat frame every HeartbeatInterval. Every frame is a JSON
+// text message described by a component schema of the contract, and result
+// and error frames carry the same Result and Error bodies as the REST
+// operations, so frames are validated against the contract like HTTP
+// exchanges are: inbound frames that violate StreamRequest are answered with
+// an error frame, and outbound frames that violate StreamMessage are logged
+// and, in strict mode, replaced by an internal error frame.
+//
+// Backpressure: a single reader queues at most MaxPending frames for the
+// single writer, and stops reading while the queue is full, so a client that
+// sends faster than it reads is slowed down by TCP flow control. A frame that
+// cannot be written within WriteTimeout closes the connection.
+//
+// Authentication happens once, on the handshake; each operation frame is
+// then checked against the operations the caller may call, and takes a token
+// from the caller's rate limit and a slot of the operation's concurrency
+// limit like a REST request does. A frame either limit rejects is answered
+// with an error frame saying when to retry. The HTTP caching headers apply
+// to the REST operations only.
+
+// StreamPath is where the Calculator accepts WebSocket sessions.
+const StreamPath = "/ws"
+
+// Frame types, the type property of every frame.
+const (
+	FrameOperation = "operation"
+	FramePing      = "ping"
+	FrameResult    = "result"
+	FrameError     = "error"
+	FramePong      = "pong"
+	FrameHeartbeat = "heartbeat"
+)
+
+// Component schemas of the inbound and outbound frames.
+const (
+	StreamRequestSchema = "StreamRequest"
+	StreamMessageSchema = "StreamMessage"
+)
+
+// UpgradeRequiredMessage is the error sent with a 426 to requests for /ws
+// that are not WebSocket handshakes.
+const UpgradeRequiredMessage = "websocket upgrade required"
+
+// OriginNotAllowedMessage is the error sent with a 403 to handshakes from an
+// origin the producer does not allow.
+const OriginNotAllowedMessage = "origin not allowed"
+
+// StreamConfig tunes the /ws sessions.
+type StreamConfig struct {
+	// HeartbeatInterval is how often a heartbeat frame is sent.
+	HeartbeatInterval time.Duration
+	// MaxPending is how many received frames may await their answer before
+	// the producer stops reading.
+	MaxPending int
+	// WriteTimeout bounds writing one frame to a client.
+	WriteTimeout time.Duration
+	// MaxMessageSize is the largest frame accepted, in bytes; larger ones
+	// close the connection.
+	MaxMessageSize int64
+	// Origins lists the cross-origin pages that may open a session, as the
+	// CORS configuration does; "*" allows any. Same-origin handshakes and
+	// clients that send no Origin are always allowed.
+	Origins []string
+	// Spec, if set, holds the frame schemas the frames are validated against.
+	Spec *contract.Spec
+	// Strict replaces an outbound frame that violates the contract with an
+	// internal error frame, as the strict validation mode rejects responses.
+	Strict bool
+}
+
+// DefaultStreamConfig returns the settings used unless SetStreamConfig is called.
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		HeartbeatInterval: 30 * time.Second,
+		MaxPending:        16,
+		WriteTimeout:      10 * time.Second,
+		MaxMessageSize:    4096,
+	}
+}
+
+// ParseStreamConfig parses the WS_HEARTBEAT_INTERVAL and WS_MAX_PENDING
+// settings, e.g. "15s" and "32", over the defaults. Empty values keep the
+// defaults.
+func ParseStreamConfig(heartbeatInterval, maxPending string) (StreamConfig, error) {
+	config := DefaultStreamConfig()
+	if heartbeatInterval != "" {
+		d, err := time.ParseDuration(heartbeatInterval)
+		if err != nil || d <= 0 {
+			return StreamConfig{}, fmt.Errorf("invalid heartbeat interval %q (want a positive duration)", heartbeatInterval)
+		}
+		config.HeartbeatInterval = d
+	}
+	if maxPending != "" {
+		n, err := strconv.Atoi(maxPending)
+		if err != nil || n < 1 {
+			return StreamConfig{}, fmt.Errorf("invalid max pending frames %q (want a positive number)", maxPending)
+		}
+		config.MaxPending = n
+	}
+	return config, nil
+}
+
+// StreamMessage is a frame the producer sends over /ws.
+type StreamMessage struct {
+	Type      string     `json:"type"`
+	ID        string     `json:"id,omitempty"`
+	Operation string     `json:"operation,omitempty"`
+	Body      any        `json:"body,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// SetStreamConfig replaces the /ws settings. Call it before serving.
+func (c *Calculator) SetStreamConfig(config StreamConfig) {
+	c.stream = config
+}
+
+// Stream handles the /ws endpoint, serving a streaming session until the
+// client goes away.
+func (c *Calculator) Stream(w http.ResponseWriter, r *http.Request) {
+	if !isWebSocketHandshake(r) {
+		w.Header().Set("Upgrade", "websocket")
+		writeError(w, r, UpgradeRequiredMessage, http.StatusUpgradeRequired)
+		return
+	}
+	if !c.stream.allowsOrigin(r) {
+		writeError(w, r, OriginNotAllowedMessage, http.StatusForbidden)
+		return
+	}
+
+	// The origin is checked above, with the CORS semantics
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		logging.FromContext(r.Context()).Debug("websocket handshake failed", "error", err)
+		return
+	}
+	conn.SetReadLimit(c.stream.MaxMessageSize)
+
+	s := &streamSession{calc: c, conn: conn, config: c.stream,
+		logger: logging.FromContext(r.Context()), requestID: logging.RequestID(r.Context()),
+		client: ratelimit.ClientKey(r)}
+	s.principal, s.authenticated = auth.FromContext(r.Context())
+	s.serve(r.Context())
+}
+
+// isWebSocketHandshake reports whether r asks to upgrade to a WebSocket.
+func isWebSocketHandshake(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsOrigin reports whether the page r comes from may open a session.
+func (c StreamConfig) allowsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(c.Origins, "*") || slices.Contains(c.Origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// inboundFrame is a message read from the client.
+type inboundFrame struct {
+	typ  websocket.MessageType
+	data []byte
+}
+
+// streamSession is one /ws connection.
+type streamSession struct {
+	calc          *Calculator
+	conn          *websocket.Conn
+	config        StreamConfig
+	logger        *slog.Logger
+	requestID     string
+	client        string // the rate limit key
+	principal     auth.Principal
+	authenticated bool
+}
+
+// serve reads frames into the bounded queue and answers them, and sends the
+// heartbeats, from this goroutine alone so frames are written in order.
+func (s *streamSession) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make(chan inboundFrame, max(s.config.MaxPending, 1))
+	var readErr error
+	go func() {
+		defer close(pending)
+		for {
+			typ, data, err := s.conn.Read(ctx)
+			if err != nil {
+				readErr = err
+				return
+			}
+			select {
+			case pending <- inboundFrame{typ: typ, data: data}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s.logger.Debug("stream opened", "principal", s.principal.Name)
+	heartbeat := time.NewTicker(s.config.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var message StreamMessage
+		select {
+		case frame, ok := <-pending:
+			if !ok {
+				// readErr is set before pending is closed
+				s.logger.Debug("stream closed", "status", websocket.CloseStatus(readErr), "error", readErr)
+				s.conn.CloseNow()
+				return
+			}
+			message = s.answer(ctx, frame)
+		case now := <-heartbeat.C:
+			now = now.UTC()
+			message = StreamMessage{Type: FrameHeartbeat, Time: &now}
+		}
+
+		if err := s.send(ctx, message); err != nil {
+			s.logger.Debug("stream write failed", "error", err)
+			if errors.Is(err, context.DeadlineExceeded) {
+				s.conn.Close(websocket.StatusPolicyViolation, "client too slow")
+			}
+			s.conn.CloseNow()
+			return
+		}
+	}
+}
+
+// answer returns the frame answering an inbound frame. A panic while
+// answering is logged and becomes an internal error frame.
+func (s *streamSession) answer(ctx context.Context, frame inboundFrame) (message StreamMessage) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		s.logger.LogAttrs(context.Background(), slog.LevelError, "stream frame panicked",
+			slog.String("panic", fmt.Sprint(v)),
+			slog.String("stack", string(debug.Stack())),
+		)
+		message = s.errorFrame(message.ID, message.Operation, InternalErrorMessage)
+	}()
+
+	if frame.typ != websocket.MessageText {
+		return s.errorFrame("", "", "frames must be text messages")
+	}
+	var value any
+	if err := json.Unmarshal(frame.data, &value); err != nil {
+		return s.errorFrame("", "", "frame is not valid JSON")
+	}
+	request, _ := value.(map[string]any)
+	id, _ := request["id"].(string)
+	name, _ := request["operation"].(string)
+	// Identifies the frame should the operation panic
+	message = StreamMessage{ID: id, Operation: name}
+
+	if s.config.Spec != nil {
+		if violations := s.config.Spec.ValidateComponent(StreamRequestSchema, value); len(violations) > 0 {
+			return s.errorFrame(id, name, "frame does not match "+StreamRequestSchema+": "+strings.Join(violations, "; "))
+		}
+	}
+
+	switch kind, _ := request["type"].(string); kind {
+	case FramePing:
+		return StreamMessage{Type: FramePong, ID: id}
+	case FrameOperation:
+		return s.operate(ctx, id, name, request)
+	default:
+		return s.errorFrame(id, name, fmt.Sprintf("unknown frame type %q", kind))
+	}
+}
+
+// operate runs the operation an operation frame names.
+func (s *streamSession) operate(ctx context.Context, id, name string, request map[string]any) StreamMessage {
+	op, ok := s.calc.registry.Lookup(name)
+	if !ok {
+		return s.errorFrame(id, name, fmt.Sprintf("unknown operation %q", name))
+	}
+	if s.authenticated && !s.principal.Allows(name) {
+		return s.errorFrame(id, name, fmt.Sprintf("%s may not call %s", s.principal.Name, name))
+	}
+
+	operands := make([]float64, op.Arity())
+	for i, operand := range operandNames[:op.Arity()] {
+		v, ok := request[operand].(float64)
+		if !ok {
+			return s.errorFrame(id, name, MissingOperandsMessage(op.Arity()))
+		}
+		operands[i] = v
+	}
+	result, err := s.calc.registry.Calculate(ctx, op, operands, s.client)
+	switch {
+	case errors.Is(err, ErrResultNotRepresentable):
+		return s.errorFrame(id, name, InternalErrorMessage)
+	case err != nil:
+		return s.errorFrame(id, name, err.Error())
+	}
+	return StreamMessage{Type: FrameResult, ID: id, Operation: name, Body: ResultResponse{Result: result}}
+}
+
+// errorFrame builds an error frame tagged with the handshake's request ID.
+func (s *streamSession) errorFrame(id, operation, message string) StreamMessage {
+	return StreamMessage{Type: FrameError, ID: id, Operation: operation,
+		Body: ErrorResponse{Error: message, RequestID: s.requestID}}
+}
+
+// send validates message against the contract and writes it within the
+// write timeout.
+func (s *streamSession) send(ctx context.Context, message StreamMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if violations := s.violations(data); len(violations) > 0 {
+		s.logger.Warn("stream frame violates the contract",
+			"type", message.Type, "operation", message.Operation, "violations", violations, "strict", s.config.Strict)
+		if s.config.Strict {
+			if data, err = json.Marshal(s.errorFrame(message.ID, message.Operation, InternalErrorMessage)); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.WriteTimeout)
+	defer cancel()
+	return s.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// violations validates an encoded outbound frame against StreamMessage.
+func (s *streamSession) violations(data []byte) []string {
+	if s.config.Spec == nil {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []string{err.Error()}
+	}
+	return s.config.Spec.ValidateComponent(StreamMessageSchema, value)
+}
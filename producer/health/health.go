@@ -0,0 +1,223 @@
+// Package health tracks the producer's dependencies and reports whether it is
+// healthy, degraded or unhealthy.
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Status is the overall state of the producer.
+type Status string
+
+const (
+	// StatusHealthy means every dependency is working as configured.
+	StatusHealthy Status = "healthy"
+	// StatusDegraded means requests are served, but not as configured, e.g.
+	// without contract validation.
+	StatusDegraded Status = "degraded"
+	// StatusUnhealthy means requests cannot be served correctly.
+	StatusUnhealthy Status = "unhealthy"
+)
+
+// probeTimeout bounds each CVT connection check.
+const probeTimeout = 2 * time.Second
+
+// Prober checks that a dependency is reachable.
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// GRPCProber checks a gRPC server through the standard grpc.health.v1 service,
+// which the CVT server implements.
+type GRPCProber struct {
+	conn *grpc.ClientConn
+}
+
+// NewGRPCProber creates a prober for the server at addr, over TLS with
+// config unless it is nil. The connection is established lazily, so an
+// unreachable server is reported by Probe.
+func NewGRPCProber(addr string, config *tls.Config) (*GRPCProber, error) {
+	creds := insecure.NewCredentials()
+	if config != nil {
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCProber{conn: conn}, nil
+}
+
+// Probe implements Prober.
+func (p *GRPCProber) Probe(ctx context.Context) error {
+	resp, err := healthpb.NewHealthClient(p.conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("status %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// Close releases the prober's connection.
+func (p *GRPCProber) Close() error {
+	return p.conn.Close()
+}
+
+// Report is the body of /health and /ready.
+type Report struct {
+	Status Status `json:"status"`
+	// Reasons explains a status other than healthy.
+	Reasons    []string         `json:"reasons,omitempty"`
+	Validation ValidationReport `json:"validation"`
+	Schema     SchemaReport     `json:"schema"`
+	CVT        CVTReport        `json:"cvt"`
+}
+
+// ValidationReport describes runtime contract validation.
+type ValidationReport struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+// SchemaReport describes the enforced contract.
+type SchemaReport struct {
+	ID         string `json:"id,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Registered bool   `json:"registered"`
+}
+
+// CVTReport describes the connection to the CVT server.
+type CVTReport struct {
+	Address   string `json:"address,omitempty"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Checker collects what the producer learns while starting up and probes CVT
+// whenever a report is requested. It is safe for concurrent use.
+type Checker struct {
+	mu         sync.RWMutex
+	schema     SchemaReport
+	validation ValidationReport
+	expected   bool
+	failure    string
+	cvtAddress string
+	prober     Prober
+	degraded   []string
+}
+
+// NewChecker creates a checker for a producer without contract validation.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// SetSchema records the contract the producer serves.
+func (c *Checker) SetSchema(id, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schema.ID = id
+	c.schema.Version = version
+}
+
+// ExpectValidation records that contract validation was configured, so that
+// failing to enable it is reported rather than silently ignored. prober checks
+// the CVT server at address and may be nil.
+func (c *Checker) ExpectValidation(address string, prober Prober) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expected = true
+	c.cvtAddress = address
+	c.prober = prober
+}
+
+// ValidationFailed records why contract validation could not be enabled.
+func (c *Checker) ValidationFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failure = err.Error()
+}
+
+// SchemaRegistered records that CVT accepted the schema.
+func (c *Checker) SchemaRegistered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schema.Registered = true
+}
+
+// EnableValidation records that the CVT middleware is active in mode.
+func (c *Checker) EnableValidation(mode string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.validation = ValidationReport{Enabled: true, Mode: mode}
+}
+
+// Degrade marks the producer degraded for the given reason, e.g. when it was
+// started despite a failed can-i-deploy check.
+func (c *Checker) Degrade(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.degraded = append(c.degraded, reason)
+}
+
+// Check probes CVT and reports the producer's status:
+//
+//   - unhealthy when strict validation is enabled but CVT is unreachable, as
+//     every validated request would then be rejected;
+//   - degraded when validation was configured but is not running, CVT is
+//     unreachable in a lenient mode, or Degrade was called;
+//   - healthy otherwise, including when validation is disabled on purpose.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	report := Report{
+		Status:     StatusHealthy,
+		Validation: c.validation,
+		Schema:     c.schema,
+		CVT:        CVTReport{Address: c.cvtAddress},
+		Reasons:    append([]string(nil), c.degraded...),
+	}
+	expected, failure, prober := c.expected, c.failure, c.prober
+	c.mu.RUnlock()
+
+	if len(report.Reasons) > 0 {
+		report.Status = StatusDegraded
+	}
+
+	if expected && !report.Validation.Enabled {
+		reason := "contract validation is configured but not running"
+		if failure != "" {
+			reason += ": " + failure
+		}
+		report.Reasons = append(report.Reasons, reason)
+		report.Status = StatusDegraded
+	}
+
+	if prober != nil {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		err := prober.Probe(probeCtx)
+		cancel()
+
+		report.CVT.Connected = err == nil
+		if err != nil {
+			report.CVT.Error = err.Error()
+			report.Reasons = append(report.Reasons, "CVT server is unreachable")
+			if report.Validation.Enabled && report.Validation.Mode == "strict" {
+				report.Status = StatusUnhealthy
+			} else {
+				report.Status = StatusDegraded
+			}
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,480 @@
+// Package idempotency makes retried mutating requests safe.
+//
+// A client that sends an Idempotency-Key header with a non-GET request may
+// retry it with the same key as often as it likes: the first request is
+// handled and its response recorded, and every repeat within the TTL gets the
+// recorded response back, marked with Idempotent-Replayed, without reaching
+// the handler again. Reusing a key for a different request, i.e. another
+// query or body, is a client error answered with a 422, and repeating a
+// request whose first attempt is still being handled gets a 409.
+//
+// Keys are scoped to the client that sent them and to the method and path,
+// so clients cannot replay each other's responses. Requests without a key are
+// handled as usual.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+)
+
+// Request and response headers.
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// Component header and responses documenting idempotency keys in the OpenAPI
+// document.
+const (
+	ReplayedComponent = "IdempotentReplayed"
+	ConflictResponse  = "IdempotencyConflict"
+	TooLargeResponse  = "IdempotentRequestTooLarge"
+	KeyReusedResponse = "IdempotencyKeyReused"
+)
+
+// DefaultTTL is how long a response is replayed unless configured otherwise.
+const DefaultTTL = 24 * time.Hour
+
+// MaxKeyLength is the longest Idempotency-Key accepted.
+const MaxKeyLength = 255
+
+// MaxBodySize is the largest request body fingerprinted. Larger requests with
+// an Idempotency-Key are rejected with a 413, as the body is read into memory.
+const MaxBodySize = 1 << 20
+
+// sweepEvery is how many records are saved between sweeps of expired ones.
+const sweepEvery = 1024
+
+// Response is a recorded response.
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Record is what a store keeps per key: the fingerprint of the request that
+// first used it, and that request's response.
+type Record struct {
+	Fingerprint string    `json:"fingerprint"`
+	Response    Response  `json:"response"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Expired reports whether r is no longer replayed at now.
+func (r Record) Expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// Store keeps records by key until they expire. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// Get returns the unexpired record for key.
+	Get(key string, now time.Time) (Record, bool, error)
+	// Put records r for key, replacing any earlier record.
+	Put(key string, r Record) error
+}
+
+// Config selects the store and TTL.
+type Config struct {
+	// TTL is how long a response is replayed for.
+	TTL time.Duration
+	// Dir, if set, keeps records in files there so they survive restarts;
+	// otherwise they are kept in memory. Requests in flight are tracked per
+	// process, so replicas must not share it.
+	Dir string
+}
+
+// ParseConfig builds a Config from a TTL, as a Go duration, and a directory,
+// as read from IDEMPOTENCY_TTL and IDEMPOTENCY_DIR. An empty TTL selects
+// DefaultTTL.
+func ParseConfig(ttl, dir string) (Config, error) {
+	config := Config{TTL: DefaultTTL, Dir: dir}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid idempotency TTL %q (want a positive duration, e.g. 24h)", ttl)
+		}
+		config.TTL = d
+	}
+	return config, nil
+}
+
+// Open returns the store config selects.
+func (c Config) Open() (Store, error) {
+	if c.Dir == "" {
+		return NewMemoryStore(), nil
+	}
+	return NewFileStore(c.Dir)
+}
+
+// Guard applies idempotency keys to requests using a store. It is safe for
+// concurrent use.
+type Guard struct {
+	store Store
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// New creates a guard recording responses in store for ttl.
+func New(store Store, ttl time.Duration) *Guard {
+	return &Guard{store: store, ttl: ttl, inFlight: make(map[string]struct{})}
+}
+
+// Serve handles r with next, unless r repeats an earlier request with the
+// same Idempotency-Key, in which case the recorded response is replayed.
+// Only successful and client error responses are recorded: a request that
+// failed on the server, or was rate limited, may be retried for real.
+func (g *Guard) Serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	key := r.Header.Get(Header)
+	if key == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+	logger := logging.FromContext(r.Context())
+	if len(key) > MaxKeyLength {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", Header, MaxKeyLength))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request bodies sent with an %s must be at most %d bytes", Header, MaxBodySize))
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to read the request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	scoped := digest(ratelimit.ClientKey(r), r.Method, r.URL.Path, key)
+	fingerprint := digest(r.URL.RawQuery, string(body))
+
+	if !g.begin(scoped) {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("a request with %s %q is still being processed", Header, key))
+		return
+	}
+	defer g.end(scoped)
+
+	record, ok, err := g.store.Get(scoped, time.Now())
+	if err != nil {
+		// Handling the request again might apply it twice
+		logger.Error("idempotency store failed", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "failed to look up the "+Header)
+		return
+	}
+	if ok {
+		if record.Fingerprint != fingerprint {
+			writeError(w, r, http.StatusUnprocessableEntity,
+				fmt.Sprintf("%s %q was already used for a different request", Header, key))
+			return
+		}
+		logger.Debug("idempotent response replayed", "status", record.Response.Status)
+		replay(w, record.Response)
+		return
+	}
+
+	rec := &recorder{ResponseWriter: w, before: w.Header().Clone()}
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 || rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+		return
+	}
+	record = Record{
+		Fingerprint: fingerprint,
+		Response:    Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()},
+		Expires:     time.Now().Add(g.ttl),
+	}
+	if err := g.store.Put(scoped, record); err != nil {
+		logger.Warn("failed to record idempotent response", "error", err)
+	}
+}
+
+// begin marks key in flight, reporting false if it already was.
+func (g *Guard) begin(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.inFlight[key]; ok {
+		return false
+	}
+	g.inFlight[key] = struct{}{}
+	return true
+}
+
+func (g *Guard) end(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.inFlight, key)
+}
+
+// replay writes a recorded response.
+func replay(w http.ResponseWriter, response Response) {
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+}
+
+// perRequest lists headers the handler sets that describe the request rather
+// than its outcome: the rate limiter's quota and retry hints, which would be
+// stale in a replay.
+var perRequest = []string{ratelimit.LimitHeader, ratelimit.RemainingHeader, ratelimit.ResetHeader,
+	ratelimit.RetryAfterHeader, "Date"}
+
+// recorder passes a response through and keeps a copy of its status, body
+// and the headers the handler set, leaving out those set before it ran, such
+// as X-Request-ID and the CORS headers, and the perRequest headers, which
+// belong to each request.
+type recorder struct {
+	http.ResponseWriter
+	before http.Header
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = make(http.Header)
+		for name, values := range r.Header() {
+			if !slices.Equal(values, r.before[name]) && !isPerRequest(name) {
+				r.header[name] = slices.Clone(values)
+			}
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// isPerRequest reports whether the header name is one of perRequest.
+func isPerRequest(name string) bool {
+	return slices.ContainsFunc(perRequest, func(h string) bool { return strings.EqualFold(h, name) })
+}
+
+// SpecParameter returns the Idempotency-Key header parameter of an operation
+// the guard applies to.
+func SpecParameter() contract.Parameter {
+	return contract.Parameter{
+		Name: Header,
+		In:   "header",
+		Description: fmt.Sprintf("Up to %d characters identifying the request, so a retry with the same key "+
+			"gets the first response replayed instead of being handled again", MaxKeyLength),
+		Schema: contract.Schema{Type: "string"},
+	}
+}
+
+// SpecHeaders returns the header every response the guard may replay lists,
+// as a reference to the component header.
+func SpecHeaders() map[string]contract.Header {
+	return map[string]contract.Header{ReplayedHeader: {Ref: "#/components/headers/" + ReplayedComponent}}
+}
+
+// SpecResponses returns the responses the guard answers with itself, keyed by
+// status, as references to the component responses. A malformed key gets
+// the operation's own 400.
+func SpecResponses() map[string]contract.Response {
+	return map[string]contract.Response{
+		"409": {Ref: "#/components/responses/" + ConflictResponse},
+		"413": {Ref: "#/components/responses/" + TooLargeResponse},
+		"422": {Ref: "#/components/responses/" + KeyReusedResponse},
+	}
+}
+
+// writeError writes a JSON error response in the contract's Error shape.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	logging.FromContext(r.Context()).Debug("idempotency key rejected",
+		"method", r.Method, "path", r.URL.Path, "status", status, "error", message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{Error: message, RequestID: logging.RequestID(r.Context())})
+}
+
+// digest hashes parts, so keys and bodies are neither held nor logged.
+func digest(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryStore keeps records in memory; they are lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	records  map[string]Record
+	sincePut int
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(key string, now time.Time) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[key]
+	if ok && r.Expired(now) {
+		delete(s.records, key)
+		return Record{}, false, nil
+	}
+	return r, ok, nil
+}
+
+// Put implements Store, dropping expired records every sweepEvery puts.
+func (s *MemoryStore) Put(key string, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sincePut++; s.sincePut >= sweepEvery {
+		s.sincePut = 0
+		now := time.Now()
+		for k, old := range s.records {
+			if old.Expired(now) {
+				delete(s.records, k)
+			}
+		}
+	}
+	s.records[key] = r
+	return nil
+}
+
+// Len returns the number of records held, expired or not.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// FileStore keeps each record as a JSON file in a directory. Files are
+// written atomically, so a crash never leaves a partial record.
+type FileStore struct {
+	dir string
+
+	mu       sync.Mutex
+	sincePut int
+}
+
+// NewFileStore creates a store in dir, creating the directory if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("idempotency store: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Get implements Store, removing the record's file once it has expired.
+func (s *FileStore) Get(key string, now time.Time) (Record, bool, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("idempotency store: %w", err)
+	}
+
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Record{}, false, fmt.Errorf("idempotency store: record %s: %w", key, err)
+	}
+	if r.Expired(now) {
+		os.Remove(s.path(key))
+		return Record{}, false, nil
+	}
+	return r, true, nil
+}
+
+// Put implements Store, removing expired files every sweepEvery puts.
+func (s *FileStore) Put(key string, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("idempotency store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".record-*")
+	if err != nil {
+		return fmt.Errorf("idempotency store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("idempotency store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("idempotency store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("idempotency store: %w", err)
+	}
+
+	s.mu.Lock()
+	s.sincePut++
+	sweep := s.sincePut >= sweepEvery
+	if sweep {
+		s.sincePut = 0
+	}
+	s.mu.Unlock()
+	if sweep {
+		s.sweep(time.Now())
+	}
+	return nil
+}
+
+// sweep removes the files of expired records.
+func (s *FileStore) sweep(now time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if key, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			s.Get(key, now)
+		}
+	}
+}
+
+// path returns the file of key. Keys are hex digests, so they are safe as
+// file names.
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
@@ -0,0 +1,182 @@
+// Package logging configures structured logging for the Calculator API and
+// ties every log line and error response to the request that caused it.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/response"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs; longer ones are replaced.
+const maxRequestIDLength = 128
+
+// Format selects the log encoding.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatText Format = "text"
+)
+
+// ParseLevel converts a configuration string such as "debug" or "WARN" into
+// a level. An empty string selects info.
+func ParseLevel(s string) (slog.Level, error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// ParseFormat converts a configuration string into a Format. An empty string
+// selects JSON.
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case "", FormatJSON:
+		return FormatJSON, nil
+	case FormatText:
+		return FormatText, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q (want json or text)", s)
+	}
+}
+
+// New creates a logger writing to w in the given format.
+func New(w io.Writer, level slog.Level, format Format) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatText {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+type contextKey struct{}
+
+// requestContext is what Middleware stores in the request context.
+type requestContext struct {
+	id     string
+	logger *slog.Logger
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside a request.
+func RequestID(ctx context.Context) string {
+	if rc, ok := ctx.Value(contextKey{}).(*requestContext); ok {
+		return rc.id
+	}
+	return ""
+}
+
+// FromContext returns the request's logger, which adds request_id to every
+// line, or slog.Default() outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if rc, ok := ctx.Value(contextKey{}).(*requestContext); ok {
+		return rc.logger
+	}
+	return slog.Default()
+}
+
+// Middleware propagates the client's X-Request-ID, or generates one, echoes it
+// on the response and logs each request once it completes. It belongs
+// outermost so every other middleware and handler can log with the ID.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			reqLogger := logger.With("request_id", id)
+			ctx := context.WithValue(r.Context(), contextKey{}, &requestContext{id: id, logger: reqLogger})
+
+			rec := response.NewRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			reqLogger.LogAttrs(ctx, slog.LevelInfo, "request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.Status()),
+				slog.Duration("duration", time.Since(start)),
+			)
+		})
+	}
+}
+
+// validRequestID accepts IDs of printable ASCII up to maxRequestIDLength, so
+// clients cannot inject arbitrary content into logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Validator wraps v so contract violations and CVT errors are logged with
+// structured fields: operation, errors, schema version and mode. The request
+// is still allowed or rejected by the CVT middleware according to its mode.
+func Validator(v producer.Validator, spec *contract.Spec, mode producer.ValidationMode) producer.Validator {
+	return &loggingValidator{next: v, spec: spec, mode: mode}
+}
+
+type loggingValidator struct {
+	next producer.Validator
+	spec *contract.Spec
+	mode producer.ValidationMode
+}
+
+// Validate implements the producer.Validator interface.
+func (v *loggingValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	result, err := v.next.Validate(ctx, schemaID, interaction)
+
+	path, _, _ := strings.Cut(interaction.Path, "?")
+	operation, _ := v.spec.OperationID(interaction.Method, path)
+	attrs := []slog.Attr{
+		slog.String("operation", operation),
+		slog.String("method", interaction.Method),
+		slog.String("path", path),
+		slog.String("schema_id", schemaID),
+		slog.String("schema_version", v.spec.Info.Version),
+		slog.String("mode", string(v.mode)),
+	}
+
+	switch {
+	case err != nil:
+		attrs = append(attrs, slog.String("error", err.Error()))
+		FromContext(ctx).LogAttrs(ctx, slog.LevelError, "contract validation error", attrs...)
+	case !result.Valid:
+		attrs = append(attrs, slog.Int("status", interaction.StatusCode), slog.Any("errors", result.Errors))
+		FromContext(ctx).LogAttrs(ctx, slog.LevelWarn, "contract validation failed", attrs...)
+	}
+	return result, err
+}
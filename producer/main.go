@@ -3,16 +3,43 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 
+	"github.com/sahina/cvt-demo/producer/admin"
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/buildinfo"
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/compress"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/cors"
+	"github.com/sahina/cvt-demo/producer/deploy"
+	"github.com/sahina/cvt-demo/producer/faults"
+	"github.com/sahina/cvt-demo/producer/grpcapi"
 	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/health"
+	"github.com/sahina/cvt-demo/producer/idempotency"
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt-demo/producer/metrics"
+	"github.com/sahina/cvt-demo/producer/overload"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+	"github.com/sahina/cvt-demo/producer/tlsconfig"
+	"github.com/sahina/cvt-demo/producer/tracing"
 	"github.com/sahina/cvt/sdks/go/cvt"
 	"github.com/sahina/cvt/sdks/go/cvt/producer"
 	"github.com/sahina/cvt/sdks/go/cvt/producer/adapters"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 // validatorAdapter adapts cvt.Validator to producer.Validator interface.
@@ -67,19 +94,161 @@ func (a *validatorAdapter) Validate(ctx context.Context, schemaID string, intera
 }
 
 func main() {
+	showVersion := flag.Bool("version", false, "print build and schema version information and exit")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		fatal("Invalid LOG_LEVEL", "error", err)
+	}
+	format, err := logging.ParseFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		fatal("Invalid LOG_FORMAT", "error", err)
+	}
+	// Also routes the standard logger, and so the CVT SDK's output, through slog
+	logger := logging.New(os.Stderr, level, format)
+	slog.SetDefault(logger)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "10001"
 	}
 
+	// The gRPC API is served only when GRPC_PORT is set
+	grpcPort := os.Getenv("GRPC_PORT")
+
+	// Probes, metrics, profiles and admin actions have a listener of their
+	// own, reachable from this host only unless ADMIN_ADDR says otherwise
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = admin.DefaultAddr
+	}
+
 	cvtServerAddr := os.Getenv("CVT_SERVER_ADDR")
 	if cvtServerAddr == "" {
 		cvtServerAddr = "localhost:9550"
 	}
 
-	schemaPath := os.Getenv("SCHEMA_PATH")
-	if schemaPath == "" {
-		schemaPath = "./calculator-api.yaml"
+	// SCHEMA_PATH overrides the embedded contract
+	schema, err := loadSchema(os.Getenv("SCHEMA_PATH"))
+	if err != nil {
+		fatal("Failed to load schema", "error", err)
+	}
+
+	build := buildinfo.Read(schema.Version())
+	if *showVersion {
+		printVersion(build)
+		return
+	}
+
+	environment := os.Getenv("CVT_ENVIRONMENT")
+	if environment == "" {
+		environment = "demo"
+	}
+
+	deployMode, err := deploy.ParseMode(os.Getenv("CAN_I_DEPLOY"))
+	if err != nil {
+		fatal("Invalid CAN_I_DEPLOY", "error", err)
+	}
+	// Only an explicitly enforced gate refuses to start when the check cannot
+	// run; by default the producer starts degraded without validation
+	deployFailClosed := deployMode == deploy.ModeEnforce && os.Getenv("CAN_I_DEPLOY") != ""
+
+	validationMode, err := parseValidationMode(os.Getenv("CVT_MODE"))
+	if err != nil {
+		fatal("Invalid CVT_MODE", "error", err)
+	}
+
+	corsConfig, err := cors.ParseConfig(os.Getenv("CORS_ALLOWED_ORIGINS"), os.Getenv("CORS_ALLOWED_METHODS"),
+		os.Getenv("CORS_ALLOWED_HEADERS"), os.Getenv("CORS_MAX_AGE"))
+	if err != nil {
+		fatal("Invalid CORS configuration", "error", err)
+	}
+
+	compressConfig, err := compress.ParseConfig(os.Getenv("COMPRESSION_ENCODINGS"), os.Getenv("COMPRESSION_MIN_SIZE"))
+	if err != nil {
+		fatal("Invalid compression configuration", "error", err)
+	}
+
+	resultCacheSize, err := parseResultCacheSize(os.Getenv("RESULT_CACHE_SIZE"))
+	if err != nil {
+		fatal("Invalid RESULT_CACHE_SIZE", "error", err)
+	}
+
+	rateLimitConfig, err := ratelimit.ParseConfig(os.Getenv("RATE_LIMIT"), os.Getenv("RATE_LIMIT_OPERATIONS"))
+	if err != nil {
+		fatal("Invalid rate limit configuration", "error", err)
+	}
+
+	overloadConfig, err := overload.ParseConfig(os.Getenv("CONCURRENCY_LIMIT"),
+		os.Getenv("CONCURRENCY_LIMIT_OPERATIONS"), os.Getenv("SHED_LATENCY_TARGET"))
+	if err != nil {
+		fatal("Invalid concurrency limit configuration", "error", err)
+	}
+
+	streamConfig, err := handlers.ParseStreamConfig(os.Getenv("WS_HEARTBEAT_INTERVAL"), os.Getenv("WS_MAX_PENDING"))
+	if err != nil {
+		fatal("Invalid WebSocket configuration", "error", err)
+	}
+
+	eventsConfig, err := handlers.ParseEventsConfig(os.Getenv("EVENTS_REPLAY_SIZE"))
+	if err != nil {
+		fatal("Invalid EVENTS_REPLAY_SIZE", "error", err)
+	}
+
+	idempotencyConfig, err := idempotency.ParseConfig(os.Getenv("IDEMPOTENCY_TTL"), os.Getenv("IDEMPOTENCY_DIR"))
+	if err != nil {
+		fatal("Invalid idempotency configuration", "error", err)
+	}
+	idempotencyStore, err := idempotencyConfig.Open()
+	if err != nil {
+		fatal("Failed to open idempotency store", "error", err)
+	}
+
+	// Fault injection is opt-in: FAULTS_FILE starts with its faults, and
+	// FAULT_INJECTION=true with none until they are set through the admin API
+	var injector *faults.Injector
+	if faultsFile := os.Getenv("FAULTS_FILE"); faultsFile != "" || os.Getenv("FAULT_INJECTION") == "true" {
+		injector = faults.NewInjector(handlers.StreamPath, handlers.EventsPath)
+		if faultsFile != "" {
+			faultsConfig, err := faults.Load(faultsFile)
+			if err != nil {
+				fatal("Invalid FAULTS_FILE", "error", err)
+			}
+			injector.Set(faultsConfig)
+		}
+	}
+
+	authenticators, err := auth.Load(auth.Config{
+		APIKeysFile: os.Getenv("AUTH_API_KEYS_FILE"),
+		JWT: auth.JWTConfig{
+			HS256SecretFile:    os.Getenv("AUTH_JWT_HS256_SECRET_FILE"),
+			RS256PublicKeyFile: os.Getenv("AUTH_JWT_RS256_PUBLIC_KEY_FILE"),
+			Issuer:             os.Getenv("AUTH_JWT_ISSUER"),
+			Audience:           os.Getenv("AUTH_JWT_AUDIENCE"),
+		},
+	})
+	if err != nil {
+		fatal("Invalid authentication configuration", "error", err)
+	}
+
+	tlsConfig, err := tlsconfig.ParseServerConfig(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"),
+		os.Getenv("TLS_CLIENT_CA_FILE"), os.Getenv("TLS_CLIENT_AUTH"))
+	if err != nil {
+		fatal("Invalid TLS configuration", "error", err)
+	}
+	var certificates *tlsconfig.Reloader
+	if tlsConfig.Enabled() {
+		if certificates, err = tlsconfig.NewReloader(tlsConfig); err != nil {
+			fatal("Failed to load TLS certificate", "error", err)
+		}
+	}
+
+	cvtTLS := tlsconfig.ClientConfig{
+		CAFile:     os.Getenv("CVT_TLS_CA_FILE"),
+		CertFile:   os.Getenv("CVT_TLS_CERT_FILE"),
+		KeyFile:    os.Getenv("CVT_TLS_KEY_FILE"),
+		ServerName: os.Getenv("CVT_TLS_SERVER_NAME"),
 	}
 
 	// Create the HTTP mux
@@ -87,50 +256,423 @@ func main() {
 
 	// Register handlers
 	calc := handlers.NewCalculator()
-	calc.RegisterRoutes(mux)
+	calc.SetBuildInfo(build)
+	routes := calc.RegisterRoutes(mux)
+
+	// The admin listener serves /health, /ready, /metrics and the profiles
+	adminMux := admin.NewMux(calc.OperationalRoutes()...)
+
+	// /health and /ready report what happens to CVT below
+	checker := calc.HealthChecker()
+	checker.SetSchema("calculator-api", schema.Version())
+
+	// Serve the enforced contract and its HTML reference
+	docs, err := contract.NewHandler(schema.Doc)
+	if err != nil {
+		fatal("Failed to render API reference", "error", err)
+	}
+	routes = append(routes, docs.RegisterRoutes(mux)...)
+
+	// Refuse to serve routes the contract does not describe (and vice versa)
+	if err := checkRouteParity(schema.Doc.Spec, routes); err != nil {
+		fatal("Route/spec parity check failed", "error", err)
+	}
+
+	// With authentication configured, only credentials granting
+	// faults.AdminGrant may read or change the faults
+	if injector != nil {
+		injector.RegisterRoutes(adminMux, authenticators...)
+	}
+
+	// /ws frames are validated against the contract here, as CVT only sees
+	// the handshake; cross-origin pages may connect as CORS allows them
+	streamConfig.Origins = corsConfig.Origins
+	streamConfig.Spec = schema.Doc.Spec
+	streamConfig.Strict = validationMode == producer.ModeStrict
+	calc.SetStreamConfig(streamConfig)
+
+	// /events replays recent calculations to clients that reconnect
+	calc.SetEventsConfig(eventsConfig)
+
+	// Retried non-GET requests with the same Idempotency-Key get the first
+	// response back; IDEMPOTENCY_DIR keeps the responses across restarts
+	calc.SetIdempotency(idempotency.New(idempotencyStore, idempotencyConfig.TTL))
+
+	// Expose request, validation and schema metrics labelled by operationId
+	m := metrics.New(schema.Doc.Spec, "calculator-api")
+	if resultCacheSize > 0 {
+		calc.Registry().EnableResultCache(resultCacheSize, m)
+		slog.Info("Result cache enabled", "size", resultCacheSize)
+	}
+
+	// Operations answer clients over their limit with a 429, which CVT
+	// validates like any other response
+	if rateLimitConfig.Enabled() {
+		calc.Registry().EnableRateLimit(ratelimit.New(rateLimitConfig))
+		slog.Info("Rate limiting enabled",
+			"default", os.Getenv("RATE_LIMIT"), "operations", os.Getenv("RATE_LIMIT_OPERATIONS"))
+	}
+
+	// A saturated operation sheds its excess requests with a 503 instead of
+	// starving the others; CVT validates the 503 like any other response
+	if overloadConfig.Enabled() {
+		calc.Registry().EnableConcurrencyLimit(overload.New(overloadConfig, m))
+		slog.Info("Concurrency limits enabled", "default", os.Getenv("CONCURRENCY_LIMIT"),
+			"operations", os.Getenv("CONCURRENCY_LIMIT_OPERATIONS"), "latency_target", overloadConfig.LatencyTarget)
+	}
+	adminMux.Handle("GET "+metrics.Path, m.Handler())
+
+	// Trace requests, handlers and CVT calls
+	provider, err := tracing.NewProvider(context.Background(), "calculator-api")
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+	defer provider.Shutdown(context.Background())
+	if tracing.Exporting() {
+		slog.Info("Exporting traces over OTLP")
+	}
+	tr := tracing.New(schema.Doc.Spec, provider)
 
 	// Determine if CVT validation is enabled
 	cvtEnabled := os.Getenv("CVT_ENABLED") != "false"
 
-	var handler http.Handler = mux
+	// Recover panics inside the CVT middleware, so the 500 is validated too
+	var handler http.Handler = handlers.Recover(tr.Handler(mux))
+
+	// Authenticate operations the contract secures inside the CVT middleware,
+	// so 401s and 403s are validated; without authenticators they stay open
+	if len(authenticators) > 0 {
+		handler = auth.Middleware(schema.Doc.Spec, authenticators...)(handler)
+		schemes := make([]string, len(authenticators))
+		for i, a := range authenticators {
+			schemes[i] = a.Scheme()
+		}
+		slog.Info("Authentication enabled", "schemes", schemes)
+	}
+
+	// Inject faults inside the CVT middleware, which validates the injected
+	// responses like real ones and so catches the contract-violating payloads
+	if injector != nil {
+		handler = injector.Middleware(handler)
+		slog.Warn("Fault injection enabled",
+			"faults", len(injector.Config().Faults), "admin", faults.AdminPath,
+			"admin_authenticated", len(authenticators) > 0)
+	}
+
+	// What the CVT middleware wraps, for the routes it cannot validate
+	unvalidated := handler
+
+	// Validates gRPC calls once the schema is registered
+	var grpcValidator producer.Validator
 
 	if cvtEnabled {
+		// The CVT SDK takes no transport credentials, so it reaches a
+		// TLS-only CVT through a tunnel; the probe dials CVT over TLS itself
+		cvtDialAddr := cvtServerAddr
+		var cvtProbeTLS *tls.Config
+		if cvtTLS.Enabled() {
+			config, err := cvtTLS.Load(cvtServerAddr)
+			if err != nil {
+				fatal("Invalid CVT TLS configuration", "error", err)
+			}
+			config.NextProtos = []string{"h2"}
+			cvtProbeTLS = config
+			tunnel, err := tlsconfig.NewTunnel(cvtServerAddr, config)
+			if err != nil {
+				fatal("Failed to open CVT TLS tunnel", "error", err)
+			}
+			defer tunnel.Close()
+			cvtDialAddr = tunnel.Addr()
+			slog.Info("Connecting to CVT over TLS", "addr", cvtServerAddr,
+				"server_name", config.ServerName, "client_certificate", cvtTLS.CertFile != "",
+				"tunnel", tunnel.Path())
+		}
+
+		// Probe CVT independently, so /health notices when it goes away later
+		prober, err := health.NewGRPCProber(cvtServerAddr, cvtProbeTLS)
+		if err != nil {
+			slog.Warn("Failed to create CVT health probe", "error", err)
+			checker.ExpectValidation(cvtServerAddr, nil)
+		} else {
+			defer prober.Close()
+			checker.ExpectValidation(cvtServerAddr, prober)
+		}
+
 		// Create CVT validator
-		validator, err := cvt.NewValidator(cvtServerAddr)
+		validator, err := cvt.NewValidator(cvtDialAddr)
 		if err != nil {
-			log.Printf("Warning: Failed to create CVT validator: %v. Running without validation.", err)
+			slog.Warn("Failed to create CVT validator", "error", err)
+			checker.ValidationFailed(err)
+			deployGateUnavailable(calc, deployMode, deployFailClosed, err)
+			slog.Warn("Running without validation")
 		} else {
 			// Register schema
 			ctx := context.Background()
-			if err := validator.RegisterSchema(ctx, "calculator-api", schemaPath); err != nil {
-				log.Printf("Warning: Failed to register schema: %v. Running without validation.", err)
+			if err := registerSchema(ctx, validator, schema); err != nil {
+				slog.Warn("Failed to register schema", "error", err)
+				checker.ValidationFailed(err)
+				deployGateUnavailable(calc, deployMode, deployFailClosed, err)
+				slog.Warn("Running without validation")
 			} else {
-				log.Printf("CVT validation enabled with schema from %s", schemaPath)
+				checker.SchemaRegistered()
+				slog.Info("CVT validation enabled",
+					"schema", schema.Origin, "schema_version", schema.Version(), "mode", validationMode)
 
-				// Create adapter that implements producer.Validator
-				adapter := &validatorAdapter{validator: validator}
+				// Refuse to start (or start degraded) if registered consumers would break
+				checkDeploy(ctx, validator, calc, schema.Version(), environment, deployMode, deployFailClosed)
+
+				// Create adapter that implements producer.Validator, recording
+				// validation outcomes, CVT latency and validation spans, and
+				// logging failures with structured fields
+				adapter := m.Validator(tr.Validator(logging.Validator(
+					&validatorAdapter{validator: validator}, schema.Doc.Spec, validationMode)))
+
+				grpcValidator = adapter
 
 				// Create producer config
 				config := producer.Config{
 					SchemaID:         "calculator-api",
 					Validator:        adapter,
-					Mode:             producer.ModeStrict,
+					Mode:             validationMode,
 					ValidateRequest:  true,
 					ValidateResponse: true,
-					ExcludePaths:     []producer.PathFilter{"/health"},
 				}
 
-				// Wrap with CVT middleware
-				handler = adapters.NetHTTPMiddleware(config)(mux)
+				// Wrap with CVT middleware, except for the exempt paths
+				handler = bypassValidation(adapters.NetHTTPMiddleware(config)(handler), unvalidated)
+				slog.Info("CVT validation exempts paths", "paths", unvalidatedPaths())
+				checker.EnableValidation(string(validationMode))
 			}
 		}
 	} else {
-		log.Println("CVT validation disabled")
+		slog.Info("CVT validation disabled")
+
+		// The gate needs CVT: an explicitly configured gate cannot run, while
+		// the default one is skipped with a warning so local runs still start
+		if os.Getenv("CAN_I_DEPLOY") != "" {
+			deployGateUnavailable(calc, deployMode, deployFailClosed, errors.New("CVT validation is disabled"))
+		} else {
+			slog.Warn("Can-i-deploy check skipped: CVT validation is disabled")
+		}
 	}
 
+	// Answer unknown paths and methods with the contract's JSON 404 and 405
+	// before CVT, which would otherwise reject them as undocumented
+	handler = handlers.Unmatched(mux)(handler)
+
+	// Compress outside CVT, which must capture and validate the uncompressed
+	// JSON the handlers write
+	if compressConfig.Enabled() {
+		handler = compress.Middleware(compressConfig)(handler)
+	}
+
+	// Answer CORS preflights before routing and CVT: OPTIONS is not an
+	// operation of the contract
+	if corsConfig.Enabled() {
+		handler = cors.Middleware(corsConfig)(handler)
+		slog.Info("CORS enabled", "origins", corsConfig.Origins, "methods", corsConfig.Methods)
+	}
+
+	// Outermost, so latency and the server span include validation and the
+	// final status is recorded; the request ID wraps everything
+	handler = logging.Middleware(logger)(tr.Middleware(m.Middleware(handler)))
+
+	if grpcPort != "" {
+		go serveGRPC(grpcPort, calc.Registry(), calc.HealthChecker(), authenticators, grpcValidator, validationMode, certificates)
+	}
+	go serveAdmin(adminAddr, logging.Middleware(logger)(adminMux))
+
 	addr := fmt.Sprintf(":%s", port)
-	log.Printf("Calculator API starting on %s", addr)
+	server := &http.Server{Addr: addr, Handler: handler}
+	slog.Info("Calculator API starting", "addr", addr, "tls", tlsConfig.Enabled(), "mutual_tls", tlsConfig.MutualTLS(),
+		"version", build.Version, "revision", build.Revision, "dirty", build.Dirty, "cvt_sdk_version", build.CVTSDKVersion)
+	if tlsConfig.Enabled() {
+		// Certificates rotated on disk are picked up without a restart
+		server.TLSConfig = certificates.TLSConfig()
+		go certificates.Watch(context.Background(), tlsconfig.DefaultReloadInterval)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		fatal("Server failed", "error", err)
+	}
+}
+
+// serveGRPC serves the calculator operations as the gRPC CalculatorService,
+// with the gRPC health service and reflection. The health service reports
+// checker's status, as /ready does. Calls are authenticated like the REST
+// operations, and validated as their REST interactions when validator is
+// set. certificates, if set, serve TLS.
+func serveGRPC(port string, registry *handlers.Registry, checker *health.Checker, authenticators []auth.Authenticator,
+	validator producer.Validator, mode producer.ValidationMode, certificates *tlsconfig.Reloader) {
+	// Validation sees the errors authentication and recovery turn into
+	// statuses, as the CVT middleware sees the REST API's 401s and 500s
+	var interceptors []grpc.UnaryServerInterceptor
+	if validator != nil {
+		interceptors = append(interceptors, grpcapi.Validate(validator, "calculator-api", mode))
+	}
+	if len(authenticators) > 0 {
+		interceptors = append(interceptors, grpcapi.Authenticate(authenticators...))
+	}
+	interceptors = append(interceptors, grpcapi.Recover())
+
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+	if certificates != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(certificates.TLSConfig())))
+	}
+	server := grpc.NewServer(options...)
+	calculatorpb.RegisterCalculatorServiceServer(server, grpcapi.NewServer(registry))
+	healthpb.RegisterHealthServer(server, grpcapi.NewHealthServer(checker, grpcapi.DefaultHealthWatchInterval))
+	reflection.Register(server)
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		fatal("gRPC server failed", "error", err)
+	}
+	slog.Info("Calculator gRPC API starting", "addr", lis.Addr().String(),
+		"tls", certificates != nil, "validation", validator != nil)
+	if err := server.Serve(lis); err != nil {
+		fatal("gRPC server failed", "error", err)
+	}
+}
+
+// serveAdmin serves the admin listener on addr over plain HTTP, so probes
+// and scrapers need neither client certificates nor credentials; keep addr
+// off the public network.
+func serveAdmin(addr string, handler http.Handler) {
+	slog.Info("Admin listener starting", "addr", addr)
 	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed: %v", err)
+		fatal("Admin listener failed", "error", err)
+	}
+}
+
+// fatal logs at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// printVersion writes the build information as JSON, in the same shape as /version.
+func printVersion(info buildinfo.Info) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(info)
+}
+
+// parseValidationMode converts CVT_MODE into a middleware mode.
+// An empty string selects strict.
+func parseValidationMode(s string) (producer.ValidationMode, error) {
+	switch producer.ValidationMode(s) {
+	case "":
+		return producer.ModeStrict, nil
+	case producer.ModeStrict, producer.ModeWarn, producer.ModeShadow:
+		return producer.ValidationMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown validation mode %q (want strict, warn or shadow)", s)
+	}
+}
+
+// parseResultCacheSize parses RESULT_CACHE_SIZE; empty or 0 disables the
+// result cache.
+func parseResultCacheSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid result cache size %q (want a number of entries)", s)
+	}
+	return size, nil
+}
+
+// documentPaths are the public routes serving the contract itself. They are
+// not operations of it, so the parity check and CVT validation exempt them;
+// consumers fetch the contract from the public listener.
+func documentPaths() []string {
+	return []string{contract.JSONPath, contract.YAMLPath, contract.DocsPath}
+}
+
+// unvalidatedPaths are the public routes exempt from the CVT middleware: the
+// contract documents, the WebSocket, whose frames the Calculator validates
+// itself as CVT only sees the handshake, and the event stream, which never
+// completes and so never yields an interaction to validate.
+func unvalidatedPaths() []string {
+	return append(documentPaths(), handlers.StreamPath, handlers.EventsPath)
+}
+
+// bypassValidation serves unvalidatedPaths with unvalidated and everything
+// else with validated.
+func bypassValidation(validated, unvalidated http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", validated)
+	for _, path := range unvalidatedPaths() {
+		mux.Handle("GET "+path, unvalidated)
+	}
+	return mux
+}
+
+// checkRouteParity compares the routes registered on the public mux with the
+// spec's operations. Operational endpoints live on the admin listener; the
+// contract documents are the only public routes it exempts.
+func checkRouteParity(spec *contract.Spec, routes []contract.Route) error {
+	return contract.CheckParity(spec, routes, documentPaths()...)
+}
+
+// registerSchema registers the producer's schema with CVT.
+func registerSchema(ctx context.Context, validator *cvt.Validator, schema *schemaSource) error {
+	path, cleanup, err := schema.registrationPath()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return validator.RegisterSchema(ctx, "calculator-api", path)
+}
+
+// deployGateUnavailable handles a can-i-deploy check that could not run. A
+// gate explicitly set to enforce fails closed, so the producer refuses to
+// start rather than deploy unchecked; otherwise it starts degraded.
+func deployGateUnavailable(calc *handlers.Calculator, mode deploy.Mode, failClosed bool, err error) {
+	switch {
+	case mode == deploy.ModeOff:
+	case failClosed:
+		fatal("Refusing to start: can-i-deploy check could not run", "error", err)
+	default:
+		slog.Warn("Can-i-deploy check could not run. Starting in degraded mode.", "error", err)
+		calc.HealthChecker().Degrade("can-i-deploy check could not run")
+	}
+}
+
+// checkDeploy runs the can-i-deploy gate for the given schema version.
+// It exits the process when the gate is enforced and consumers would break,
+// or when failClosed is set and CVT cannot answer.
+func checkDeploy(ctx context.Context, validator *cvt.Validator, calc *handlers.Calculator, version, environment string, mode deploy.Mode, failClosed bool) {
+	if mode == deploy.ModeOff {
+		slog.Info("Can-i-deploy check disabled")
+		return
+	}
+
+	outcome, err := deploy.Check(ctx, validator, deploy.Config{
+		SchemaID:    "calculator-api",
+		Version:     version,
+		Environment: environment,
+		Mode:        mode,
+	})
+	if outcome.Result != nil {
+		slog.Info("Can-i-deploy result",
+			"schema_id", "calculator-api", "schema_version", version, "environment", environment,
+			"safe", outcome.Result.SafeToDeploy, "summary", outcome.Result.Summary,
+			"breaking_changes", outcome.Result.BreakingChanges)
+	}
+
+	switch {
+	case errors.Is(err, deploy.ErrUnsafe):
+		fatal("Refusing to start", "error", err)
+	case err != nil:
+		deployGateUnavailable(calc, mode, failClosed, err)
+	case outcome.Degraded:
+		slog.Warn("Registered consumers would break. Starting in degraded mode.")
+		calc.HealthChecker().Degrade("registered consumers would break (can-i-deploy)")
 	}
 }
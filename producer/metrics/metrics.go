@@ -0,0 +1,226 @@
+// Package metrics exposes Prometheus metrics for the Calculator API.
+//
+// Requests are labelled by the operationId the OpenAPI document assigns to
+// their method and path, so query strings and unknown paths never create new
+// label values.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/response"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// Path is where the metrics endpoint is served.
+const Path = "/metrics"
+
+// Unmatched is the operation label for requests the contract does not
+// describe, such as /openapi.json or unknown paths.
+const Unmatched = "unmatched"
+
+// Validation outcomes recorded per operation.
+const (
+	OutcomeValid   = "valid"
+	OutcomeInvalid = "invalid"
+	OutcomeError   = "error"
+	OutcomeSkipped = "skipped"
+)
+
+// Result cache lookup outcomes recorded per operation.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+const namespace = "calculator"
+
+// Metrics holds the producer's collectors and the registry they are exposed from.
+type Metrics struct {
+	spec     *contract.Spec
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	validations *prometheus.CounterVec
+	cvtDuration *prometheus.HistogramVec
+	schemaInfo  *prometheus.GaugeVec
+	resultCache *prometheus.CounterVec
+	inFlight    *prometheus.GaugeVec
+	concurrency *prometheus.GaugeVec
+	shed        *prometheus.CounterVec
+}
+
+// New creates the producer metrics for the given contract. Each instance has
+// its own registry, so tests can create as many as they like.
+func New(spec *contract.Spec, schemaID string) *Metrics {
+	m := &Metrics{
+		spec:     spec,
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by operationId, method and status code.",
+		}, []string{"operation", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by operationId and status code, including CVT validation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "code"}),
+		validations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cvt_validations_total",
+			Help:      "CVT validation outcomes (valid, invalid, error, skipped) by operationId.",
+		}, []string{"operation", "outcome"}),
+		cvtDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cvt_validation_duration_seconds",
+			Help:      "Latency of CVT validation calls by operationId.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation"}),
+		schemaInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "schema_info",
+			Help:      "The contract the producer enforces; always 1.",
+		}, []string{"schema_id", "version"}),
+		resultCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "result_cache_lookups_total",
+			Help:      "Result cache lookups (hit, miss) by operationId; absent while the cache is disabled.",
+		}, []string{"operation", "result"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "operation_requests_in_flight",
+			Help:      "Requests running by operationId, for operations with a concurrency limit.",
+		}, []string{"operation"}),
+		concurrency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "operation_concurrency_limit",
+			Help:      "Current concurrency limit by operationId, lowered while the operation is slower than the latency target.",
+		}, []string{"operation"}),
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shed_requests_total",
+			Help:      "Requests shed with a 503 by operationId and reason (limit, adaptive).",
+		}, []string{"operation", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration, m.validations, m.cvtDuration, m.schemaInfo, m.resultCache,
+		m.inFlight, m.concurrency, m.shed,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.schemaInfo.WithLabelValues(schemaID, spec.Info.Version).Set(1)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Operation returns the label for a request: the operationId documented for
+// its method and path, or Unmatched. Query strings are ignored.
+func (m *Metrics) Operation(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	if id, ok := m.spec.OperationID(method, path); ok {
+		return id
+	}
+	return Unmatched
+}
+
+// Middleware records request counts and latency. It belongs outside the CVT
+// middleware so latency includes validation and the status is the one the
+// client receives. Requests whose validator was never called are counted as
+// skipped, e.g. excluded paths or CVT disabled.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		operation := m.Operation(r.Method, r.URL.Path)
+
+		obs := &observation{}
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), observationKey{}, obs)))
+
+		code := strconv.Itoa(rec.Status())
+		m.requests.WithLabelValues(operation, r.Method, code).Inc()
+		m.duration.WithLabelValues(operation, code).Observe(time.Since(start).Seconds())
+		if !obs.validated.Load() {
+			m.validations.WithLabelValues(operation, OutcomeSkipped).Inc()
+		}
+	})
+}
+
+// ResultCacheHit records a result served from the result cache.
+func (m *Metrics) ResultCacheHit(operation string) {
+	m.resultCache.WithLabelValues(operation, CacheHit).Inc()
+}
+
+// ResultCacheMiss records a result the result cache did not hold.
+func (m *Metrics) ResultCacheMiss(operation string) {
+	m.resultCache.WithLabelValues(operation, CacheMiss).Inc()
+}
+
+// ConcurrencyChanged records how many requests to a limited operation are
+// running and its current limit.
+func (m *Metrics) ConcurrencyChanged(operation string, inFlight int, limit float64) {
+	m.inFlight.WithLabelValues(operation).Set(float64(inFlight))
+	m.concurrency.WithLabelValues(operation).Set(limit)
+}
+
+// RequestShed records a request shed by the concurrency limiter.
+func (m *Metrics) RequestShed(operation, reason string) {
+	m.shed.WithLabelValues(operation, reason).Inc()
+}
+
+// Validator wraps v so every call records its outcome and latency.
+func (m *Metrics) Validator(v producer.Validator) producer.Validator {
+	return &recordingValidator{next: v, metrics: m}
+}
+
+type recordingValidator struct {
+	next    producer.Validator
+	metrics *Metrics
+}
+
+// Validate implements the producer.Validator interface.
+func (v *recordingValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	if obs, ok := ctx.Value(observationKey{}).(*observation); ok {
+		obs.validated.Store(true)
+	}
+
+	operation := v.metrics.Operation(interaction.Method, interaction.Path)
+	start := time.Now()
+	result, err := v.next.Validate(ctx, schemaID, interaction)
+	v.metrics.cvtDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	outcome := OutcomeValid
+	switch {
+	case err != nil:
+		outcome = OutcomeError
+	case !result.Valid:
+		outcome = OutcomeInvalid
+	}
+	v.metrics.validations.WithLabelValues(operation, outcome).Inc()
+
+	return result, err
+}
+
+// observation tracks, per request, whether the validator was called.
+type observation struct {
+	validated atomic.Bool
+}
+
+type observationKey struct{}
@@ -0,0 +1,350 @@
+// Package overload keeps slow operations from starving the others.
+//
+// Each operation may have a concurrency limit: at most Max requests run at
+// once, and further requests queue for up to QueueTimeout for a slot. A
+// request that gets none is shed with a 503 and Retry-After, as the contract
+// documents, instead of piling up behind the slow ones. Operations are
+// limited independently, so a saturated /divide leaves /add serving.
+//
+// With a latency target set, the limit also adapts: each request that takes
+// longer than the target lowers the operation's limit by a tenth, and each
+// faster one raises it again, back up to Max. An operation that slows down,
+// say because its dependencies do, so admits fewer requests at once and sheds
+// the rest early.
+package overload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// RetryAfterHeader is set on every shed response.
+const RetryAfterHeader = "Retry-After"
+
+// ServiceUnavailableResponse is the component response documenting the 503
+// in the OpenAPI document.
+const ServiceUnavailableResponse = "ServiceUnavailable"
+
+// DefaultQueueTimeout is how long a request waits for a slot unless the
+// limit says otherwise.
+const DefaultQueueTimeout = 250 * time.Millisecond
+
+// Reasons a request is shed, as recorded by an Observer.
+const (
+	// ReasonLimit means every slot of the configured limit was taken.
+	ReasonLimit = "limit"
+	// ReasonAdaptive means the limit had been lowered because the operation
+	// was slower than the latency target.
+	ReasonAdaptive = "adaptive"
+)
+
+// Limit is the concurrency limit of an operation. The zero Limit is
+// unlimited.
+type Limit struct {
+	// Max is how many requests may run at once.
+	Max int
+	// QueueTimeout is how long a request waits for a slot before it is shed;
+	// zero sheds requests that find every slot taken at once.
+	QueueTimeout time.Duration
+}
+
+// Unlimited reports whether l does not limit requests.
+func (l Limit) Unlimited() bool {
+	return l.Max <= 0
+}
+
+// ParseLimit parses a limit written as <max>[:<queue timeout>], e.g. "8" or
+// "2:1s". The queue timeout defaults to DefaultQueueTimeout. "off" and ""
+// are unlimited.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	count, timeout, hasTimeout := strings.Cut(s, ":")
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid concurrency limit %q (want <max>[:<queue timeout>])", s)
+	}
+
+	limit := Limit{Max: n, QueueTimeout: DefaultQueueTimeout}
+	if hasTimeout {
+		limit.QueueTimeout, err = time.ParseDuration(timeout)
+		if err != nil || limit.QueueTimeout < 0 {
+			return Limit{}, fmt.Errorf("invalid queue timeout in concurrency limit %q (want e.g. 250ms)", s)
+		}
+	}
+	return limit, nil
+}
+
+// Config holds the limit of every operation and the latency target.
+type Config struct {
+	// Default applies to operations without an entry in Operations.
+	Default Limit
+	// Operations overrides Default by operationId.
+	Operations map[string]Limit
+	// LatencyTarget, if positive, adapts each limit to keep requests faster
+	// than it.
+	LatencyTarget time.Duration
+}
+
+// ParseConfig builds a Config from a default limit, a comma-separated list of
+// operationId=limit overrides and a latency target, as read from
+// CONCURRENCY_LIMIT, CONCURRENCY_LIMIT_OPERATIONS and SHED_LATENCY_TARGET,
+// e.g. "64", "divide=4:1s,add=off" and "200ms".
+func ParseConfig(limit, operations, latencyTarget string) (Config, error) {
+	def, err := ParseLimit(limit)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{Default: def, Operations: make(map[string]Limit)}
+	for _, entry := range strings.Split(operations, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return Config{}, fmt.Errorf("invalid operation concurrency limit %q (want operationId=limit)", entry)
+		}
+		l, err := ParseLimit(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("operation %s: %w", strings.TrimSpace(name), err)
+		}
+		config.Operations[strings.TrimSpace(name)] = l
+	}
+
+	if latencyTarget != "" {
+		config.LatencyTarget, err = time.ParseDuration(latencyTarget)
+		if err != nil || config.LatencyTarget <= 0 {
+			return Config{}, fmt.Errorf("invalid shed latency target %q (want a positive duration, e.g. 200ms)", latencyTarget)
+		}
+	}
+	return config, nil
+}
+
+// For returns the limit of the named operation.
+func (c Config) For(operation string) Limit {
+	if l, ok := c.Operations[operation]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// Enabled reports whether any operation is limited.
+func (c Config) Enabled() bool {
+	if !c.Default.Unlimited() {
+		return true
+	}
+	for _, l := range c.Operations {
+		if !l.Unlimited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Observer is told how busy each limited operation is and about every request
+// that is shed.
+type Observer interface {
+	ConcurrencyChanged(operation string, inFlight int, limit float64)
+	RequestShed(operation, reason string)
+}
+
+// Limiter applies a Config to requests. It is safe for concurrent use.
+type Limiter struct {
+	config   Config
+	observer Observer
+
+	mu    sync.Mutex
+	gates map[string]*gate
+}
+
+// New creates a limiter applying config. observer, if not nil, is told about
+// every change.
+func New(config Config, observer Observer) *Limiter {
+	return &Limiter{config: config, observer: observer, gates: make(map[string]*gate)}
+}
+
+// gate holds the slots of one operation.
+type gate struct {
+	max     int
+	limit   float64 // adaptive, from 1 to max
+	running int
+	queue   []chan struct{} // waiting requests, oldest first
+}
+
+// Acquire waits for a slot for operation, up to its queue timeout or until
+// ctx is done. It returns a release function to call once the request is
+// done, or false and the reason if the request is shed. Unlimited operations
+// always get a slot.
+func (l *Limiter) Acquire(ctx context.Context, operation string) (release func(), reason string, ok bool) {
+	limit := l.config.For(operation)
+	if limit.Unlimited() {
+		return func() {}, "", true
+	}
+
+	l.mu.Lock()
+	g, ok := l.gates[operation]
+	if !ok {
+		g = &gate{max: limit.Max, limit: float64(limit.Max)}
+		l.gates[operation] = g
+	}
+
+	start := time.Now()
+	release = func() { l.release(operation, g, time.Since(start)) }
+	if g.running < g.slots() && len(g.queue) == 0 {
+		g.running++
+		l.changed(operation, g)
+		l.mu.Unlock()
+		return release, "", true
+	}
+	reason = g.reason()
+	if limit.QueueTimeout <= 0 {
+		l.shed(operation, reason)
+		l.mu.Unlock()
+		return nil, reason, false
+	}
+	admitted := make(chan struct{})
+	g.queue = append(g.queue, admitted)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-admitted:
+		start = time.Now()
+		return release, "", true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, waiting := range g.queue {
+		if waiting == admitted {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			reason = g.reason()
+			l.shed(operation, reason)
+			return nil, reason, false
+		}
+	}
+	// Admitted as the wait ended
+	start = time.Now()
+	return release, "", true
+}
+
+// release frees a slot after a request that took latency, adapts the limit
+// and admits the requests now within it. l.mu must not be held.
+func (l *Limiter) release(operation string, g *gate, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	g.running--
+	if target := l.config.LatencyTarget; target > 0 {
+		if latency > target {
+			g.limit = math.Max(1, g.limit*0.9)
+		} else {
+			g.limit = math.Min(float64(g.max), g.limit+1/g.limit)
+		}
+	}
+	for len(g.queue) > 0 && g.running < g.slots() {
+		close(g.queue[0])
+		g.queue = g.queue[1:]
+		g.running++
+	}
+	l.changed(operation, g)
+}
+
+// slots is how many requests g admits at once.
+func (g *gate) slots() int {
+	return max(int(g.limit), 1)
+}
+
+// reason explains why g sheds a request.
+func (g *gate) reason() string {
+	if g.slots() < g.max {
+		return ReasonAdaptive
+	}
+	return ReasonLimit
+}
+
+// Limit returns the current limit of operation and how many of its requests
+// are running; 0, 0 if it is unlimited or has not been called.
+func (l *Limiter) Limit(operation string) (limit float64, running int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if g, ok := l.gates[operation]; ok {
+		return g.limit, g.running
+	}
+	return 0, 0
+}
+
+// changed tells the observer about g. l.mu must be held.
+func (l *Limiter) changed(operation string, g *gate) {
+	if l.observer != nil {
+		l.observer.ConcurrencyChanged(operation, g.running, g.limit)
+	}
+}
+
+// shed tells the observer about a shed request. l.mu must be held.
+func (l *Limiter) shed(operation, reason string) {
+	if l.observer != nil {
+		l.observer.RequestShed(operation, reason)
+	}
+}
+
+// Admit gets r a slot for operation, answering it with a 503 and Retry-After
+// itself if it is shed. The caller must call release once it has handled an
+// admitted request. A nil Limiter admits every request.
+func (l *Limiter) Admit(w http.ResponseWriter, r *http.Request, operation string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	release, reason, ok := l.Acquire(r.Context(), operation)
+	if ok {
+		return release, true
+	}
+	if r.Context().Err() != nil {
+		// The client went away while queued; nobody reads an answer
+		return nil, false
+	}
+
+	retryAfter := l.RetryAfter(operation)
+	logging.FromContext(r.Context()).Debug("request shed",
+		"operation", operation, "reason", reason, "retry_after", retryAfter)
+
+	w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{
+		Error:     OverloadedMessage(operation, retryAfter),
+		RequestID: logging.RequestID(r.Context()),
+	})
+	return nil, false
+}
+
+// RetryAfter is how long a client shed by operation should wait, in the
+// whole seconds Retry-After takes: the operation's queue timeout.
+func (l *Limiter) RetryAfter(operation string) int {
+	return max(int(math.Ceil(l.config.For(operation).QueueTimeout.Seconds())), 1)
+}
+
+// OverloadedMessage is the error sent to a client shed by operation.
+func OverloadedMessage(operation string, retryAfter int) string {
+	return fmt.Sprintf("%s is overloaded; retry in %d seconds", operation, retryAfter)
+}
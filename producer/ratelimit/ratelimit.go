@@ -0,0 +1,355 @@
+// Package ratelimit limits how often each client may call each operation.
+//
+// Every client gets a token bucket per operation: it holds up to Burst
+// tokens, refills at Rate tokens per second, and each request takes one.
+// Clients are identified by who they authenticated as, or else by their IP
+// address. Requests that find the bucket empty get a 429 with Retry-After;
+// every limited response carries the RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers the contract documents.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// Response headers set on every rate-limited operation.
+const (
+	LimitHeader      = "RateLimit-Limit"
+	RemainingHeader  = "RateLimit-Remaining"
+	ResetHeader      = "RateLimit-Reset"
+	RetryAfterHeader = "Retry-After"
+)
+
+// Component headers and response documenting rate limiting in the OpenAPI
+// document.
+const (
+	LimitComponent          = "RateLimitLimit"
+	RemainingComponent      = "RateLimitRemaining"
+	ResetComponent          = "RateLimitReset"
+	RetryAfterComponent     = "RetryAfter"
+	TooManyRequestsResponse = "TooManyRequests"
+)
+
+// sweepEvery is how many new buckets are created between sweeps of the
+// buckets that have refilled, which are indistinguishable from new ones.
+const sweepEvery = 1024
+
+// Limit is a token bucket size and refill rate. The zero Limit is unlimited.
+type Limit struct {
+	// Rate is the number of tokens added per second.
+	Rate float64
+	// Burst is the number of tokens the bucket holds, i.e. how many requests
+	// a client that has been idle may send at once.
+	Burst int
+}
+
+// Unlimited reports whether l does not limit requests.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// ParseLimit parses a limit written as <requests>/<s|m|h>, optionally
+// followed by :<burst>, e.g. "10/s", "600/m" or "5/s:20". The burst defaults
+// to the number of requests. "off" and "" are unlimited.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	count, unit, ok := strings.Cut(rate, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q (want <requests>/<s|m|h>[:<burst>])", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q (unit must be s, m or h)", s)
+	}
+
+	limit := Limit{Rate: float64(n) / per.Seconds(), Burst: n}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit burst in %q", s)
+		}
+	}
+	return limit, nil
+}
+
+// Config holds the limit of every operation.
+type Config struct {
+	// Default applies to operations without an entry in Operations.
+	Default Limit
+	// Operations overrides Default by operationId.
+	Operations map[string]Limit
+}
+
+// ParseConfig builds a Config from a default limit and a comma-separated list
+// of operationId=limit overrides, as read from RATE_LIMIT and
+// RATE_LIMIT_OPERATIONS, e.g. "100/m" and "divide=10/m,add=off".
+func ParseConfig(limit, operations string) (Config, error) {
+	def, err := ParseLimit(limit)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{Default: def, Operations: make(map[string]Limit)}
+	for _, entry := range strings.Split(operations, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return Config{}, fmt.Errorf("invalid operation rate limit %q (want operationId=limit)", entry)
+		}
+		l, err := ParseLimit(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("operation %s: %w", strings.TrimSpace(name), err)
+		}
+		config.Operations[strings.TrimSpace(name)] = l
+	}
+	return config, nil
+}
+
+// For returns the limit of the named operation.
+func (c Config) For(operation string) Limit {
+	if l, ok := c.Operations[operation]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// Enabled reports whether any operation is limited.
+func (c Config) Enabled() bool {
+	if !c.Default.Unlimited() {
+		return true
+	}
+	for _, l := range c.Operations {
+		if !l.Unlimited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision is the outcome of taking a token.
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket size.
+	Limit int
+	// Remaining is the number of whole tokens left after this request.
+	Remaining int
+	// Reset is how long the bucket takes to refill completely.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client must wait for a token.
+	RetryAfter time.Duration
+}
+
+// Bucket is a token bucket. It is not safe for concurrent use; Limiter
+// serialises access to its buckets.
+type Bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket for limit.
+func NewBucket(limit Limit, now time.Time) *Bucket {
+	return &Bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// Take refills the bucket up to now and takes a token if there is one.
+func (b *Bucket) Take(now time.Time) Decision {
+	b.refill(now)
+
+	d := Decision{Limit: b.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = b.wait(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = b.wait(float64(b.limit.Burst) - b.tokens)
+	return d
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// full reports whether the bucket will have refilled by now.
+func (b *Bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// wait returns how long refilling the given number of tokens takes.
+func (b *Bucket) wait(tokens float64) time.Duration {
+	return time.Duration(tokens / b.limit.Rate * float64(time.Second))
+}
+
+// Limiter applies a Config to requests. It is safe for concurrent use.
+type Limiter struct {
+	config Config
+
+	mu         sync.Mutex
+	buckets    map[bucketKey]*Bucket
+	sinceSweep int
+}
+
+type bucketKey struct {
+	operation string
+	client    string
+}
+
+// New creates a limiter applying config.
+func New(config Config) *Limiter {
+	return &Limiter{config: config, buckets: make(map[bucketKey]*Bucket)}
+}
+
+// Allow takes a token from client's bucket for operation at now. Requests to
+// unlimited operations are always allowed and report a zero Limit.
+func (l *Limiter) Allow(operation, client string, now time.Time) Decision {
+	limit := l.config.For(operation)
+	if limit.Unlimited() {
+		return Decision{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := bucketKey{operation: operation, client: client}
+	b, ok := l.buckets[key]
+	if !ok {
+		l.sweep(now)
+		b = NewBucket(limit, now)
+		l.buckets[key] = b
+	}
+	return b.Take(now)
+}
+
+// Buckets returns the number of buckets the limiter holds.
+func (l *Limiter) Buckets() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// sweep drops refilled buckets every sweepEvery new buckets, so clients that
+// went away do not hold memory. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if l.sinceSweep++; l.sinceSweep < sweepEvery {
+		return
+	}
+	l.sinceSweep = 0
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Admit takes a token for r from its client's bucket for operation and
+// reports whether the request may proceed. It sets the rate limit headers on
+// w, and answers a rejected request with a 429 itself. A nil Limiter admits
+// every request.
+func (l *Limiter) Admit(w http.ResponseWriter, r *http.Request, operation string) bool {
+	if l == nil {
+		return true
+	}
+
+	client := ClientKey(r)
+	d := l.Allow(operation, client, time.Now())
+	if d.Limit == 0 {
+		return true
+	}
+
+	w.Header().Set(LimitHeader, strconv.Itoa(d.Limit))
+	w.Header().Set(RemainingHeader, strconv.Itoa(d.Remaining))
+	w.Header().Set(ResetHeader, strconv.Itoa(seconds(d.Reset)))
+	if d.Allowed {
+		return true
+	}
+
+	retryAfter := d.RetryAfterSeconds()
+	logging.FromContext(r.Context()).Debug("request rate limited",
+		"operation", operation, "client", client, "retry_after", retryAfter)
+
+	w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId,omitempty"`
+	}{
+		Error:     ExceededMessage(operation, retryAfter),
+		RequestID: logging.RequestID(r.Context()),
+	})
+	return false
+}
+
+// RetryAfterSeconds is how long a rejected client must wait, in the whole
+// seconds Retry-After takes.
+func (d Decision) RetryAfterSeconds() int {
+	return max(seconds(d.RetryAfter), 1)
+}
+
+// ExceededMessage is the error sent to a client over its limit for operation.
+func ExceededMessage(operation string, retryAfter int) string {
+	return fmt.Sprintf("Rate limit exceeded for %s; retry in %d seconds", operation, retryAfter)
+}
+
+// ClientKey identifies the client that sent r: the principal it
+// authenticated as, or otherwise its IP address. Credentials nothing has
+// verified are ignored, as anyone could send a fresh one per request to
+// dodge the limit. Forwarding headers are not trusted.
+func ClientKey(r *http.Request) string {
+	return Client(r.Context(), r.RemoteAddr)
+}
+
+// Client identifies a client as ClientKey does, for APIs other than HTTP:
+// by the principal in ctx, or otherwise by the IP address of remoteAddr.
+func Client(ctx context.Context, remoteAddr string) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.Scheme + ":" + p.Name
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// SpecHeaders returns the rate limit headers every response of a limited
+// operation lists, as references to the component headers.
+func SpecHeaders() map[string]contract.Header {
+	return map[string]contract.Header{
+		LimitHeader:     {Ref: "#/components/headers/" + LimitComponent},
+		RemainingHeader: {Ref: "#/components/headers/" + RemainingComponent},
+		ResetHeader:     {Ref: "#/components/headers/" + ResetComponent},
+	}
+}
+
+// seconds rounds d up to whole seconds, as the headers require.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
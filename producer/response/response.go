@@ -0,0 +1,48 @@
+// Package response records what handlers write, for the middleware that
+// reports on the response once the handler is done.
+package response
+
+import "net/http"
+
+// Recorder passes a response through and captures the status code the
+// wrapped handler wrote.
+type Recorder struct {
+	http.ResponseWriter
+	status  int
+	started bool
+}
+
+// NewRecorder wraps w.
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status returns the status code written, 200 if the handler wrote a body
+// without one or nothing at all.
+func (r *Recorder) Status() int {
+	return r.status
+}
+
+// Started reports whether the handler wrote a status code or any body, after
+// which the status can no longer change.
+func (r *Recorder) Started() bool {
+	return r.started
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if !r.started {
+		r.status = status
+		r.started = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.started = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
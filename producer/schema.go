@@ -0,0 +1,88 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+)
+
+// The contract is embedded so the binary always carries the exact schema it
+// was built with. SCHEMA_PATH can still point at a file on disk to override it.
+var (
+	//go:embed calculator-api.yaml
+	embeddedSchemaYAML []byte
+
+	//go:embed calculator-api.json
+	embeddedSchemaJSON []byte
+)
+
+// schemaSource is the contract the producer registers with CVT and enforces.
+type schemaSource struct {
+	// Origin describes where the schema came from, for logging.
+	Origin string
+	// Doc is the parsed document in both encodings.
+	Doc *contract.Document
+
+	override string
+}
+
+// loadSchema returns the embedded schema, or the file at override if set.
+func loadSchema(override string) (*schemaSource, error) {
+	if override != "" {
+		doc, err := contract.LoadDocument(override)
+		if err != nil {
+			return nil, err
+		}
+		return &schemaSource{Origin: override, Doc: doc, override: override}, nil
+	}
+
+	doc, err := contract.NewDocument(embeddedSchemaJSON, embeddedSchemaYAML)
+	if err != nil {
+		return nil, fmt.Errorf("embedded schema: %w", err)
+	}
+
+	// Both formats are embedded; make sure they describe the same contract
+	yamlSpec, err := contract.Parse(embeddedSchemaYAML)
+	if err != nil {
+		return nil, fmt.Errorf("embedded schema: %w", err)
+	}
+	if yamlSpec.Info.Version != doc.Spec.Info.Version {
+		return nil, fmt.Errorf("embedded schemas disagree: calculator-api.yaml is %s, calculator-api.json is %s",
+			yamlSpec.Info.Version, doc.Spec.Info.Version)
+	}
+
+	return &schemaSource{Origin: "embedded calculator-api.json", Doc: doc}, nil
+}
+
+// Version returns the schema's info.version.
+func (s *schemaSource) Version() string {
+	return s.Doc.Spec.Info.Version
+}
+
+// registrationPath returns a file path CVT can register the schema from.
+// The CVT SDK registers schemas by path, so the embedded document is written
+// to a temporary file; call cleanup once registration is done.
+func (s *schemaSource) registrationPath() (path string, cleanup func(), err error) {
+	if s.override != "" {
+		return s.override, func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "calculator-api-*.json")
+	if err != nil {
+		return "", nil, fmt.Errorf("write embedded schema: %w", err)
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+
+	if _, err := f.Write(s.Doc.JSON); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("write embedded schema: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("write embedded schema: %w", err)
+	}
+	return f.Name(), cleanup, nil
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/admin"
+	"github.com/sahina/cvt-demo/producer/faults"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/metrics"
+)
+
+// newAdminServer serves the admin listener's mux the way main builds it, with
+// the probes, metrics and the fault admin API.
+func newAdminServer(t *testing.T, calc *handlers.Calculator) *httptest.Server {
+	t.Helper()
+
+	mux := admin.NewMux(calc.OperationalRoutes()...)
+	mux.Handle("GET "+metrics.Path, metrics.New(loadSpec(t, "calculator-api.yaml"), "calculator-api").Handler())
+	faults.NewInjector().RegisterRoutes(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestAdmin_Routes tests that the admin listener serves the probes, metrics,
+// profiles and admin actions, and answers anything else like the public
+// listener does.
+func TestAdmin_Routes(t *testing.T) {
+	calc := handlers.NewCalculator()
+	calc.HealthChecker().Degrade("example degradation")
+	server := newAdminServer(t, calc)
+
+	testCases := []struct {
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"GET", handlers.HealthPath, http.StatusOK, `"status":"degraded"`},
+		{"GET", handlers.ReadyPath, http.StatusOK, `"status":"degraded"`},
+		{"GET", metrics.Path, http.StatusOK, "calculator_schema_info"},
+		{"GET", admin.PprofPath, http.StatusOK, "goroutine"},
+		{"GET", admin.PprofPath + "cmdline", http.StatusOK, ""},
+		{"GET", admin.PprofPath + "heap?debug=1", http.StatusOK, "heap profile"},
+		{"GET", faults.AdminPath, http.StatusOK, `"faults":`},
+		{"DELETE", handlers.HealthPath, http.StatusMethodNotAllowed, handlers.MethodNotAllowedMessage},
+		{"GET", "/add?x=1&y=2", http.StatusNotFound, handlers.NotFoundMessage},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, server.URL+tc.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, resp.StatusCode, body)
+			}
+			if !strings.Contains(string(body), tc.expectedBody) {
+				t.Errorf("Expected the body to contain %q, got %.200s", tc.expectedBody, body)
+			}
+		})
+	}
+}
+
+// TestAdmin_PublicRoutes tests that the public listener no longer serves the
+// operational endpoints, and that the contract no longer documents them.
+func TestAdmin_PublicRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+
+	for _, path := range []string{handlers.HealthPath, handlers.ReadyPath, metrics.Path, admin.PprofPath, faults.AdminPath} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body handlers.ErrorResponse
+		if json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != http.StatusNotFound || body.Error != handlers.NotFoundMessage {
+			t.Errorf("%s: expected a JSON 404 on the public listener, got %d %s", path, rec.Code, rec.Body)
+		}
+
+		for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+			if _, ok := loadSpec(t, name).Operation("GET", path); ok {
+				t.Errorf("%s: %s should not be in the contract", name, path)
+			}
+		}
+	}
+}
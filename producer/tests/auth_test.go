@@ -0,0 +1,417 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// testHS256Secret is the shared secret of the HS256 tokens in these tests.
+const testHS256Secret = "0123456789abcdef0123456789abcdef"
+
+// testAPIKeys is an API key file with an unrestricted and a restricted key.
+const testAPIKeys = `# client key [operationId ...]
+ci        key-ci
+dashboard key-dash add subtract
+`
+
+// writeFile writes data to name in a temporary directory and returns its path.
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newRSAKey generates an RS256 signing key and writes its public key as PEM.
+func newRSAKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, writeFile(t, "public.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signToken signs claims with method and key.
+func signToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newAuthHandler serves the calculator routes behind authentication with the
+// given authenticators, as main wires them.
+func newAuthHandler(t *testing.T, authenticators ...auth.Authenticator) http.Handler {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	return auth.Middleware(loadSpec(t, "calculator-api.yaml"), authenticators...)(mux)
+}
+
+// authGet serves a GET through handler with the given request headers.
+func authGet(handler http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAuth_APIKeys tests API key authentication and per-key operation
+// restrictions.
+func TestAuth_APIKeys(t *testing.T) {
+	keys, err := auth.ParseAPIKeys([]byte(testAPIKeys))
+	if err != nil {
+		t.Fatalf("ParseAPIKeys failed: %v", err)
+	}
+	handler := newAuthHandler(t, keys)
+
+	testCases := []struct {
+		name           string
+		path           string
+		apiKey         string
+		expectedStatus int
+	}{
+		{"unrestricted key", "/divide?x=6&y=3", "key-ci", http.StatusOK},
+		{"restricted key, allowed operation", "/add?x=1&y=2", "key-dash", http.StatusOK},
+		{"restricted key, other operation", "/divide?x=6&y=3", "key-dash", http.StatusForbidden},
+		{"unknown key", "/add?x=1&y=2", "key-unknown", http.StatusUnauthorized},
+		{"no key", "/add?x=1&y=2", "", http.StatusUnauthorized},
+		{"public version", "/version", "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := map[string]string{}
+			if tc.apiKey != "" {
+				header[auth.APIKeyHeader] = tc.apiKey
+			}
+			rec := authGet(handler, tc.path, header)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code < 400 {
+				return
+			}
+			var body handlers.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+				t.Errorf("Expected an Error body, got %q", rec.Body.String())
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if rec.Code == http.StatusUnauthorized && !strings.HasPrefix(challenge, "ApiKey ") {
+				t.Errorf("Expected an ApiKey challenge, got %q", challenge)
+			}
+			if rec.Code == http.StatusForbidden && challenge != "" {
+				t.Errorf("Expected no challenge with 403, got %q", challenge)
+			}
+		})
+	}
+
+	for _, data := range []string{"", "# only comments\n", "ci\n", "ci key-1\nother key-1\n"} {
+		if _, err := auth.ParseAPIKeys([]byte(data)); err == nil {
+			t.Errorf("Expected ParseAPIKeys(%q) to fail", data)
+		}
+	}
+}
+
+// TestAuth_JWT tests HS256 and RS256 bearer tokens and the claims they must
+// carry.
+func TestAuth_JWT(t *testing.T) {
+	rsaKey, publicKeyFile := newRSAKey(t)
+	j, err := auth.LoadJWT(auth.JWTConfig{
+		HS256SecretFile:    writeFile(t, "secret", []byte(testHS256Secret+"\n")),
+		RS256PublicKeyFile: publicKeyFile,
+		Issuer:             "https://issuer.example",
+		Audience:           "calculator-api",
+	})
+	if err != nil {
+		t.Fatalf("LoadJWT failed: %v", err)
+	}
+	handler := newAuthHandler(t, j)
+
+	valid := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"sub": "consumer-4",
+			"iss": "https://issuer.example",
+			"aud": "calculator-api",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(claims, key)
+				continue
+			}
+			claims[key] = value
+		}
+		return claims
+	}
+	hs256 := func(claims jwt.MapClaims) string {
+		return signToken(t, jwt.SigningMethodHS256, []byte(testHS256Secret), claims)
+	}
+	rs256 := func(claims jwt.MapClaims) string {
+		return signToken(t, jwt.SigningMethodRS256, rsaKey, claims)
+	}
+
+	testCases := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"HS256", "/add?x=1&y=2", hs256(valid(nil)), http.StatusOK},
+		{"RS256", "/add?x=1&y=2", rs256(valid(nil)), http.StatusOK},
+		{"scope allows", "/divide?x=6&y=3", hs256(valid(jwt.MapClaims{"scope": "add divide"})), http.StatusOK},
+		{"scope forbids", "/multiply?x=6&y=3", hs256(valid(jwt.MapClaims{"scope": "add divide"})), http.StatusForbidden},
+		{"expired", "/add?x=1&y=2", hs256(valid(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), http.StatusUnauthorized},
+		{"no expiry", "/add?x=1&y=2", hs256(valid(jwt.MapClaims{"exp": nil})), http.StatusUnauthorized},
+		{"no subject", "/add?x=1&y=2", hs256(valid(jwt.MapClaims{"sub": nil})), http.StatusUnauthorized},
+		{"wrong issuer", "/add?x=1&y=2", hs256(valid(jwt.MapClaims{"iss": "https://other.example"})), http.StatusUnauthorized},
+		{"wrong audience", "/add?x=1&y=2", rs256(valid(jwt.MapClaims{"aud": "other-api"})), http.StatusUnauthorized},
+		{"wrong secret", "/add?x=1&y=2", signToken(t, jwt.SigningMethodHS256, []byte(strings.Repeat("x", 32)), valid(nil)), http.StatusUnauthorized},
+		{"unaccepted algorithm", "/add?x=1&y=2", signToken(t, jwt.SigningMethodHS384, []byte(testHS256Secret), valid(nil)), http.StatusUnauthorized},
+		{"unsigned", "/add?x=1&y=2", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid(nil)), http.StatusUnauthorized},
+		{"malformed", "/add?x=1&y=2", "not-a-token", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := authGet(handler, tc.path, map[string]string{"Authorization": "Bearer " + tc.token})
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); rec.Code == http.StatusUnauthorized &&
+				!strings.Contains(challenge, `error="invalid_token"`) {
+				t.Errorf("Expected an invalid_token challenge, got %q", challenge)
+			}
+		})
+	}
+
+	rec := authGet(handler, "/add?x=1&y=2", nil)
+	if challenge := rec.Header().Get("WWW-Authenticate"); rec.Code != http.StatusUnauthorized || challenge != `Bearer realm="calculator-api"` {
+		t.Errorf("Expected 401 with a plain Bearer challenge without a token, got %d and %q", rec.Code, challenge)
+	}
+}
+
+// TestAuth_BothSchemes tests that either scheme is accepted and that a 401
+// challenges with both.
+func TestAuth_BothSchemes(t *testing.T) {
+	keys, _ := auth.ParseAPIKeys([]byte(testAPIKeys))
+	j, err := auth.LoadJWT(auth.JWTConfig{HS256SecretFile: writeFile(t, "secret", []byte(testHS256Secret))})
+	if err != nil {
+		t.Fatalf("LoadJWT failed: %v", err)
+	}
+	handler := newAuthHandler(t, keys, j)
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testHS256Secret),
+		jwt.MapClaims{"sub": "consumer-4", "exp": time.Now().Add(time.Hour).Unix()})
+
+	if rec := authGet(handler, "/add?x=1&y=2", map[string]string{auth.APIKeyHeader: "key-ci"}); rec.Code != http.StatusOK {
+		t.Errorf("Expected an API key to be accepted, got %d", rec.Code)
+	}
+	if rec := authGet(handler, "/add?x=1&y=2", map[string]string{"Authorization": "Bearer " + token}); rec.Code != http.StatusOK {
+		t.Errorf("Expected a bearer token to be accepted, got %d", rec.Code)
+	}
+
+	rec := authGet(handler, "/add?x=1&y=2", nil)
+	if challenges := rec.Header().Values("WWW-Authenticate"); rec.Code != http.StatusUnauthorized || len(challenges) != 2 {
+		t.Errorf("Expected 401 with an ApiKey and a Bearer challenge, got %d and %q", rec.Code, challenges)
+	}
+}
+
+// TestAuth_Load tests that only configured authenticators are created and
+// that bad key files fail startup.
+func TestAuth_Load(t *testing.T) {
+	if authenticators, err := auth.Load(auth.Config{}); err != nil || len(authenticators) != 0 {
+		t.Errorf("Expected no authenticators without configuration, got %d, %v", len(authenticators), err)
+	}
+
+	_, publicKeyFile := newRSAKey(t)
+	authenticators, err := auth.Load(auth.Config{
+		APIKeysFile: writeFile(t, "keys", []byte(testAPIKeys)),
+		JWT:         auth.JWTConfig{RS256PublicKeyFile: publicKeyFile},
+	})
+	if err != nil || len(authenticators) != 2 {
+		t.Fatalf("Expected two authenticators, got %d, %v", len(authenticators), err)
+	}
+	if authenticators[0].Scheme() != auth.APIKeyScheme || authenticators[1].Scheme() != auth.BearerScheme {
+		t.Errorf("Expected %s and %s, got %s and %s", auth.APIKeyScheme, auth.BearerScheme,
+			authenticators[0].Scheme(), authenticators[1].Scheme())
+	}
+
+	for name, config := range map[string]auth.Config{
+		"missing API keys file": {APIKeysFile: filepath.Join(t.TempDir(), "missing")},
+		"short HS256 secret":    {JWT: auth.JWTConfig{HS256SecretFile: writeFile(t, "short", []byte("too-short"))}},
+		"invalid public key":    {JWT: auth.JWTConfig{RS256PublicKeyFile: writeFile(t, "bad.pem", []byte("not a key"))}},
+	} {
+		if _, err := auth.Load(config); err == nil {
+			t.Errorf("%s: expected Load to fail", name)
+		}
+	}
+}
+
+// TestAuth_RateLimitByPrincipal tests that authenticated callers are rate
+// limited by who they are rather than by the key they sent or their address.
+func TestAuth_RateLimitByPrincipal(t *testing.T) {
+	keys, _ := auth.ParseAPIKeys([]byte(testAPIKeys))
+	config, _ := ratelimit.ParseConfig("1/h", "")
+	calc := handlers.NewCalculator()
+	calc.Registry().EnableRateLimit(ratelimit.New(config))
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	handler := auth.Middleware(loadSpec(t, "calculator-api.yaml"), keys)(mux)
+
+	get := func(apiKey, remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/add?x=1&y=2", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("key-ci", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if code := get("key-ci", "192.0.2.2:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the same principal from another address to be limited, got %d", code)
+	}
+	if code := get("key-dash", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected another principal from the same address to be allowed, got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/add?x=1&y=2", nil)
+	ctx := auth.NewContext(req.Context(), auth.Principal{Name: "ci", Scheme: auth.APIKeyScheme})
+	if key := ratelimit.ClientKey(req.WithContext(ctx)); key != auth.APIKeyScheme+":ci" {
+		t.Errorf("Expected the client key to name the principal, got %q", key)
+	}
+}
+
+// TestAuth_InSpec tests that every operation requires an API key or a bearer
+// token and documents the 401 and 403, while /version stays public.
+func TestAuth_InSpec(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		for _, scheme := range []string{auth.APIKeyScheme, auth.BearerScheme} {
+			if _, ok := spec.Components.SecuritySchemes[scheme]; !ok {
+				t.Errorf("%s: missing security scheme %s", name, scheme)
+			}
+		}
+		if s := spec.Components.SecuritySchemes[auth.APIKeyScheme]; s.Type != "apiKey" || s.In != "header" || s.Name != auth.APIKeyHeader {
+			t.Errorf("%s: %s should be an apiKey in the %s header, got %+v", name, auth.APIKeyScheme, auth.APIKeyHeader, s)
+		}
+		if s := spec.Components.SecuritySchemes[auth.BearerScheme]; s.Type != "http" || s.Scheme != "bearer" || s.BearerFormat != "JWT" {
+			t.Errorf("%s: %s should be an http bearer JWT, got %+v", name, auth.BearerScheme, s)
+		}
+
+		unauthorized := spec.Components.Responses[auth.UnauthorizedResponse]
+		if ref := unauthorized.Headers["WWW-Authenticate"].RefName(); ref != auth.WWWAuthenticateComponent {
+			t.Errorf("%s: %s WWW-Authenticate refers to %q", name, auth.UnauthorizedResponse, ref)
+		}
+		for _, response := range []string{auth.UnauthorizedResponse, auth.ForbiddenResponse} {
+			if ref := spec.Components.Responses[response].Content["application/json"].Schema.RefName(); ref != "Error" {
+				t.Errorf("%s: %s body refers to %q, want Error", name, response, ref)
+			}
+		}
+
+		for _, operation := range handlers.NewCalculator().Registry().Operations() {
+			path := "/" + operation.Name()
+			op := spec.Paths[path]["get"]
+			if len(op.Security) != 2 {
+				t.Errorf("%s: %s should accept an API key or a bearer token, got %v", name, path, op.Security)
+			}
+			if ref := op.Responses["401"].RefName(); ref != auth.UnauthorizedResponse {
+				t.Errorf("%s: %s 401 refers to %q", name, path, ref)
+			}
+			if ref := op.Responses["403"].RefName(); ref != auth.ForbiddenResponse {
+				t.Errorf("%s: %s 403 refers to %q", name, path, ref)
+			}
+		}
+		for _, path := range []string{"/version"} {
+			if op, ok := spec.Operation("GET", path); ok && len(op.Security) != 0 {
+				t.Errorf("%s: %s should be public, got %v", name, path, op.Security)
+			}
+		}
+	}
+}
+
+// TestSchemaCompliance_AuthErrors validates a 401 and a 403 for every
+// operation against the schema.
+func TestSchemaCompliance_AuthErrors(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
+	keys, _ := auth.ParseAPIKeys([]byte("restricted key-1 nothing\n"))
+	handler := newAuthHandler(t, keys)
+	ctx := context.Background()
+
+	for _, operation := range handlers.NewCalculator().Registry().Operations() {
+		for _, tc := range []struct {
+			name           string
+			header         map[string]string
+			expectedStatus int
+		}{
+			{"unauthorized", nil, http.StatusUnauthorized},
+			{"forbidden", map[string]string{auth.APIKeyHeader: "key-1"}, http.StatusForbidden},
+		} {
+			t.Run(operation.Name()+"/"+tc.name, func(t *testing.T) {
+				path := fmt.Sprintf("/%s?x=6&y=3", operation.Name())
+				rec := authGet(handler, path, tc.header)
+				if rec.Code != tc.expectedStatus {
+					t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+				}
+
+				result, err := testKit.ValidateResponse(ctx, producer.ValidateResponseParams{
+					Method: "GET",
+					Path:   path,
+					Response: producer.TestResponseData{
+						StatusCode: rec.Code,
+						Body:       parseBody(rec.Body.Bytes()),
+						Headers:    httpHeaderToMap(rec.Header()),
+					},
+				})
+				if err != nil {
+					t.Fatalf("Validation error: %v", err)
+				}
+				if !result.Valid {
+					t.Errorf("%d does not comply with schema: %v", rec.Code, result.Errors)
+				}
+			})
+		}
+	}
+}
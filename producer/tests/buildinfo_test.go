@@ -0,0 +1,82 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/buildinfo"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// TestBuildInfo_VersionEndpoint tests that /version reports the Go version,
+// the CVT SDK in the build and the schema version the producer enforces.
+func TestBuildInfo_VersionEndpoint(t *testing.T) {
+	calc := handlers.NewCalculator()
+	calc.SetBuildInfo(buildinfo.Read("1.0.0"))
+
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/version", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var info handlers.VersionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode version info: %v", err)
+	}
+	if info.Version == "" {
+		t.Error("Expected a module version")
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("Expected Go version %s, got %q", runtime.Version(), info.GoVersion)
+	}
+	if info.SchemaVersion != "1.0.0" {
+		t.Errorf("Expected schema version 1.0.0, got %q", info.SchemaVersion)
+	}
+	if info.CVTSDKVersion == "" {
+		t.Error("Expected the CVT SDK version from the build's dependencies")
+	}
+}
+
+// TestBuildInfo_MatchesSpec tests that the version info's fields are the
+// ones the VersionInfo schema documents.
+func TestBuildInfo_MatchesSpec(t *testing.T) {
+	schema := loadSpec(t, "calculator-api.yaml").Components.Schemas["VersionInfo"]
+
+	// Set every optional field so none is dropped by omitempty
+	info := buildinfo.Read("1.0.0")
+	info.Revision = "0123456789abcdef"
+	info.CVTSDKVersion = "v0.0.0"
+
+	body, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to encode version info: %v", err)
+	}
+	var fields map[string]any
+	json.Unmarshal(body, &fields)
+
+	for _, name := range schema.Required {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("Version info is missing required field %q", name)
+		}
+	}
+	for name := range fields {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("Version info field %q is not in the VersionInfo schema", name)
+		}
+	}
+	if len(fields) != len(schema.Properties) {
+		t.Errorf("Expected %d fields, got %d: %v", len(schema.Properties), len(fields), fields)
+	}
+}
@@ -0,0 +1,284 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/cache"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/metrics"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// conditionalGet serves a GET through handler with the given If-None-Match.
+func conditionalGet(handler http.Handler, path, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestCaching_ResultHeaders tests that results carry a strong ETag derived
+// from the body and the Cache-Control policy, and that errors carry neither.
+func TestCaching_ResultHeaders(t *testing.T) {
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+
+	rec := conditionalGet(mux, "/add?x=5&y=3", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != cache.ETag(rec.Body.Bytes()) {
+		t.Errorf("Expected ETag %s of the body, got %q", cache.ETag(rec.Body.Bytes()), etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != handlers.ResultCacheControl {
+		t.Errorf("Expected Cache-Control %q, got %q", handlers.ResultCacheControl, cc)
+	}
+
+	if other := conditionalGet(mux, "/add?x=5.0&y=3e0", "").Header().Get("ETag"); other != etag {
+		t.Errorf("Expected the same result to get the same ETag, got %q and %q", etag, other)
+	}
+	if other := conditionalGet(mux, "/add?x=5&y=4", "").Header().Get("ETag"); other == etag {
+		t.Errorf("Expected a different result to get a different ETag, both got %q", etag)
+	}
+
+	errRec := conditionalGet(mux, "/divide?x=1&y=0", "")
+	if errRec.Header().Get("ETag") != "" || errRec.Header().Get("Cache-Control") != "" {
+		t.Errorf("Expected no caching headers on an error, got ETag %q, Cache-Control %q",
+			errRec.Header().Get("ETag"), errRec.Header().Get("Cache-Control"))
+	}
+}
+
+// TestCaching_ConditionalRequests tests If-None-Match handling.
+func TestCaching_ConditionalRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	etag := conditionalGet(mux, "/multiply?x=6&y=7", "").Header().Get("ETag")
+
+	testCases := []struct {
+		name           string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{"matching tag", etag, http.StatusNotModified},
+		{"weak form of the tag", "W/" + etag, http.StatusNotModified},
+		{"tag in a list", `"other", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"stale tag", `"0123456789abcdef0123456789abcdef"`, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := conditionalGet(mux, "/multiply?x=6&y=7", tc.ifNoneMatch)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if rec.Header().Get("ETag") != etag {
+				t.Errorf("Expected ETag %s, got %q", etag, rec.Header().Get("ETag"))
+			}
+			if tc.expectedStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("Expected an empty 304 body, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestCaching_LRU tests eviction order and recency updates.
+func TestCaching_LRU(t *testing.T) {
+	lru := cache.NewLRU[string, int](2)
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Get("a") // b is now least recently used
+	lru.Add("c", 3)
+
+	if _, ok := lru.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := lru.Get(key); !ok || got != want {
+			t.Errorf("Get(%q) = %d, %v; want %d, true", key, got, ok, want)
+		}
+	}
+	if lru.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", lru.Len())
+	}
+}
+
+// TestCaching_LRUConcurrent tests the LRU under concurrent use; run with -race.
+func TestCaching_LRUConcurrent(t *testing.T) {
+	lru := cache.NewLRU[int, int](16)
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := (g*31 + i) % 64
+				if v, ok := lru.Get(key); ok && v != key*key {
+					t.Errorf("Get(%d) = %d, want %d", key, v, key*key)
+				}
+				lru.Add(key, key*key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lru.Len() > 16 {
+		t.Errorf("Expected at most 16 entries, got %d", lru.Len())
+	}
+}
+
+// TestCaching_ResultCacheMetrics tests that result cache hits and misses are
+// counted per operation, and that cached results are served unchanged.
+func TestCaching_ResultCacheMetrics(t *testing.T) {
+	spec := loadSpec(t, "calculator-api.yaml")
+	m := metrics.New(spec, "calculator-api")
+
+	calc := handlers.NewCalculator()
+	calc.Registry().EnableResultCache(2, m)
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	mux.Handle(metrics.Path, m.Handler())
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	first := conditionalGet(mux, "/add?x=1&y=2", "")
+	for _, path := range []string{"/add?x=1&y=2", "/add?x=1.0&y=2", "/subtract?x=1&y=2", "/divide?x=1&y=0"} {
+		rec := conditionalGet(mux, path, "")
+		if strings.HasPrefix(path, "/add") && rec.Body.String() != first.Body.String() {
+			t.Errorf("Expected the cached result %q, got %q", first.Body.String(), rec.Body.String())
+		}
+	}
+
+	body := scrape(t, server)
+	for _, want := range []string{
+		`calculator_result_cache_lookups_total{operation="add",result="hit"} 2`,
+		`calculator_result_cache_lookups_total{operation="add",result="miss"} 1`,
+		`calculator_result_cache_lookups_total{operation="subtract",result="miss"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(body, `operation="divide"`) {
+		t.Error("Expected rejected operands not to reach the result cache")
+	}
+}
+
+// TestCaching_NonFiniteResult tests that a result JSON cannot encode, such as
+// an overflowing addition, is a 500 that is neither tagged nor cached.
+func TestCaching_NonFiniteResult(t *testing.T) {
+	calc := handlers.NewCalculator()
+	calc.Registry().EnableResultCache(2, nil)
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+
+	for range 2 {
+		rec := conditionalGet(mux, "/add?x=1e308&y=1e308", "")
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status 500, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), handlers.InternalErrorMessage) {
+			t.Errorf("Expected an Error body, got %q", rec.Body.String())
+		}
+		if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "" {
+			t.Errorf("Expected no caching headers, got ETag %q, Cache-Control %q",
+				rec.Header().Get("ETag"), rec.Header().Get("Cache-Control"))
+		}
+	}
+
+	op, _ := calc.Registry().Lookup("add")
+	if _, err := calc.Registry().Calculate(context.Background(), op, []float64{1e308, 1e308}, "test"); !errors.Is(err, handlers.ErrResultNotRepresentable) {
+		t.Errorf("Expected ErrResultNotRepresentable from Calculate, got %v", err)
+	}
+}
+
+// TestCaching_NotModifiedInSpec tests that every calculator operation
+// documents the 304 and the caching headers of its 200.
+func TestCaching_NotModifiedInSpec(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		notModified, ok := spec.Components.Responses[handlers.NotModifiedResponse]
+		if !ok {
+			t.Fatalf("%s: missing component response %s", name, handlers.NotModifiedResponse)
+		}
+		if len(notModified.Content) != 0 {
+			t.Errorf("%s: %s must not have a body", name, handlers.NotModifiedResponse)
+		}
+		for _, component := range []string{handlers.ETagComponent, handlers.CacheControlComponent} {
+			if _, ok := spec.Components.Headers[component]; !ok {
+				t.Errorf("%s: missing component header %s", name, component)
+			}
+		}
+
+		for _, operation := range handlers.NewCalculator().Registry().Operations() {
+			path := "/" + operation.Name()
+			op := spec.Paths[path]["get"]
+			if ref := op.Responses["304"].RefName(); ref != handlers.NotModifiedResponse {
+				t.Errorf("%s: %s 304 refers to %q, want %s", name, path, ref, handlers.NotModifiedResponse)
+			}
+			for _, response := range []string{"200", "304"} {
+				resolved := spec.ResolveResponse(op.Responses[response])
+				for header, component := range map[string]string{"ETag": handlers.ETagComponent, "Cache-Control": handlers.CacheControlComponent} {
+					if ref := resolved.Headers[header].RefName(); ref != component {
+						t.Errorf("%s: %s %s %s refers to %q, want %s", name, path, response, header, ref, component)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestSchemaCompliance_NotModified validates a 304 for every operation
+// against the schema.
+func TestSchemaCompliance_NotModified(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
+	calc := handlers.NewCalculator()
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	ctx := context.Background()
+
+	for _, operation := range calc.Registry().Operations() {
+		t.Run(operation.Name(), func(t *testing.T) {
+			path := fmt.Sprintf("/%s?x=6&y=3", operation.Name())
+			etag := conditionalGet(mux, path, "").Header().Get("ETag")
+			rec := conditionalGet(mux, path, etag)
+
+			if rec.Code != http.StatusNotModified {
+				t.Fatalf("Expected status 304, got %d", rec.Code)
+			}
+
+			result, err := testKit.ValidateResponse(ctx, producer.ValidateResponseParams{
+				Method: "GET",
+				Path:   path,
+				Response: producer.TestResponseData{
+					StatusCode: rec.Code,
+					Headers:    httpHeaderToMap(rec.Header()),
+				},
+			})
+			if err != nil {
+				t.Fatalf("Validation error: %v", err)
+			}
+			if !result.Valid {
+				t.Errorf("304 does not comply with schema: %v", result.Errors)
+			}
+		})
+	}
+}
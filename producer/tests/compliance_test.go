@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/sahina/cvt-demo/producer/buildinfo"
 	"github.com/sahina/cvt-demo/producer/handlers"
 	"github.com/sahina/cvt/sdks/go/cvt/producer"
 )
@@ -58,17 +60,8 @@ func TestSchemaCompliance_AllOperations(t *testing.T) {
 			req := httptest.NewRequest(tc.Method, path, nil)
 			rec := httptest.NewRecorder()
 
-			// Call the appropriate handler
-			switch tc.Path {
-			case "/add":
-				calc.Add(rec, req)
-			case "/subtract":
-				calc.Subtract(rec, req)
-			case "/multiply":
-				calc.Multiply(rec, req)
-			case "/divide":
-				calc.Divide(rec, req)
-			}
+			// Call the generated handler for the operation
+			calc.Handler(strings.TrimPrefix(tc.Path, "/"))(rec, req)
 
 			// Verify status code
 			if rec.Code != tc.ExpectedStatus {
@@ -201,21 +194,21 @@ func TestSchemaCompliance_ErrorResponses(t *testing.T) {
 			name:       "division by zero error",
 			path:       "/divide",
 			queryPath:  "/divide?x=10&y=0",
-			handler:    calc.Divide,
+			handler:    calc.Handler("divide"),
 			statusCode: 400,
 		},
 		{
 			name:       "missing parameters error",
 			path:       "/add",
 			queryPath:  "/add",
-			handler:    calc.Add,
+			handler:    calc.Handler("add"),
 			statusCode: 400,
 		},
 		{
 			name:       "missing y parameter",
 			path:       "/multiply",
 			queryPath:  "/multiply?x=5",
-			handler:    calc.Multiply,
+			handler:    calc.Handler("multiply"),
 			statusCode: 400,
 		},
 	}
@@ -254,23 +247,143 @@ func TestSchemaCompliance_ErrorResponses(t *testing.T) {
 	}
 }
 
-// TestSchemaCompliance_HealthEndpoint tests the health endpoint response.
-func TestSchemaCompliance_HealthEndpoint(t *testing.T) {
+// TestSchemaCompliance_VersionEndpoint tests that the /version response
+// complies with the VersionInfo schema.
+func TestSchemaCompliance_VersionEndpoint(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
 	calc := handlers.NewCalculator()
+	calc.SetBuildInfo(buildinfo.Read("1.0.0"))
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	rec := httptest.NewRecorder()
+
+	calc.Version(rec, req)
+
+	result, err := testKit.ValidateResponse(context.Background(), producer.ValidateResponseParams{
+		Method: "GET",
+		Path:   "/version",
+		Response: producer.TestResponseData{
+			StatusCode: rec.Code,
+			Body:       parseBody(rec.Body.Bytes()),
+			Headers:    httpHeaderToMap(rec.Header()),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Validation error: %v", err)
+	}
+	if !result.Valid {
+		t.Errorf("Response does not comply with schema: %v", result.Errors)
+	}
+}
+
+// TestSchemaCompliance_UpgradeRequired tests that the 426 answering a plain
+// request for /ws complies with the schema. CVT does not see /ws, whose
+// frames the Calculator validates itself, so the response is checked here.
+func TestSchemaCompliance_UpgradeRequired(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
+	req := httptest.NewRequest("GET", handlers.StreamPath, nil)
+	rec := httptest.NewRecorder()
+
+	handlers.NewCalculator().Stream(rec, req)
+
+	if rec.Code != http.StatusUpgradeRequired {
+		t.Fatalf("Expected status 426, got %d", rec.Code)
+	}
+
+	result, err := testKit.ValidateResponse(context.Background(), producer.ValidateResponseParams{
+		Method: "GET",
+		Path:   handlers.StreamPath,
+		Response: producer.TestResponseData{
+			StatusCode: rec.Code,
+			Body:       parseBody(rec.Body.Bytes()),
+			Headers:    httpHeaderToMap(rec.Header()),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Validation error: %v", err)
+	}
+	if !result.Valid {
+		t.Errorf("Response does not comply with schema: %v", result.Errors)
+	}
+}
+
+// TestSchemaCompliance_InvalidLastEventID tests that the 400 answering an
+// invalid Last-Event-ID complies with the schema. CVT does not see /events,
+// whose stream never completes, so the response is checked here.
+func TestSchemaCompliance_InvalidLastEventID(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
 
-	req := httptest.NewRequest("GET", "/health", nil)
+	req := httptest.NewRequest("GET", handlers.EventsPath, nil)
+	req.Header.Set("Last-Event-ID", "latest")
 	rec := httptest.NewRecorder()
 
-	calc.Health(rec, req)
+	handlers.NewCalculator().Events(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", rec.Code)
+	}
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", rec.Code)
+	result, err := testKit.ValidateResponse(context.Background(), producer.ValidateResponseParams{
+		Method: "GET",
+		Path:   handlers.EventsPath,
+		Response: producer.TestResponseData{
+			StatusCode: rec.Code,
+			Body:       parseBody(rec.Body.Bytes()),
+			Headers:    httpHeaderToMap(rec.Header()),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Validation error: %v", err)
 	}
+	if !result.Valid {
+		t.Errorf("Response does not comply with schema: %v", result.Errors)
+	}
+}
+
+// TestSchemaCompliance_RecoveredPanic tests that the 500 a recovered panic
+// produces still passes CVT response validation on every operation.
+func TestSchemaCompliance_RecoveredPanic(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
+	handler := handlers.Recover(http.HandlerFunc(panickingHandler))
+	ctx := context.Background()
+
+	for _, route := range calculatorRoutes() {
+		t.Run(route.String(), func(t *testing.T) {
+			req := httptest.NewRequest(route.Method, route.Path, nil)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
 
-	// Health endpoint is excluded from CVT validation in production,
-	// but we can verify the response structure
-	body := rec.Body.String()
-	if body != `{"status":"healthy"}`+"\n" {
-		t.Errorf("Unexpected health response: %s", body)
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("Expected status 500, got %d", rec.Code)
+			}
+
+			result, err := testKit.ValidateResponse(ctx, producer.ValidateResponseParams{
+				Method: route.Method,
+				Path:   route.Path,
+				Response: producer.TestResponseData{
+					StatusCode: rec.Code,
+					Body:       parseBody(rec.Body.Bytes()),
+					Headers:    httpHeaderToMap(rec.Header()),
+				},
+			})
+			if err != nil {
+				t.Fatalf("Validation error: %v", err)
+			}
+			if !result.Valid {
+				t.Errorf("Recovered 500 does not comply with schema: %v", result.Errors)
+			}
+		})
 	}
 }
@@ -0,0 +1,325 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/sahina/cvt-demo/producer/compress"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// newCompressionServer wires compression, unmatched-route handling and the
+// calculator the way the producer does, with next standing in for the CVT
+// middleware.
+func newCompressionServer(t *testing.T, config compress.Config, next func(http.Handler) http.Handler) http.Handler {
+	t.Helper()
+	return compress.Middleware(config)(newRoutingServer(t, next))
+}
+
+// decompress decodes body according to its Content-Encoding.
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "":
+		return body
+	case compress.Gzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("Invalid gzip body: %v", err)
+		}
+		r = gz
+	case compress.Brotli:
+		r = brotli.NewReader(r)
+	default:
+		t.Fatalf("Unexpected Content-Encoding %q", encoding)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decode %s body: %v", encoding, err)
+	}
+	return decoded
+}
+
+// bodyValidator checks captured response bodies against the contract's
+// required properties, the part of CVT's response validation that breaks
+// when it sees compressed bytes.
+type bodyValidator struct {
+	spec    *contract.Spec
+	results []*producer.ValidationResult
+}
+
+func (v *bodyValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	result := &producer.ValidationResult{Valid: true}
+	schema := "Result"
+	if interaction.StatusCode >= 400 {
+		schema = "Error"
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal([]byte(interaction.ResponseBody), &body); err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, "response body is not JSON: "+err.Error())
+	}
+	for _, property := range v.spec.Components.Schemas[schema].Required {
+		if _, ok := body[property]; !ok {
+			result.Valid = false
+			result.Errors = append(result.Errors, "missing required property "+property)
+		}
+	}
+	if encoding := interaction.ResponseHeaders["Content-Encoding"]; encoding != "" {
+		result.Valid = false
+		result.Errors = append(result.Errors, "response captured with Content-Encoding "+encoding)
+	}
+	v.results = append(v.results, result)
+	return result, nil
+}
+
+// validateResponse captures the full interaction after next has served it,
+// the way the CVT middleware does, and then writes the response through.
+func validateResponse(validator producer.Validator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+
+			headers := make(map[string]string)
+			for name := range rec.Header() {
+				headers[name] = rec.Header().Get(name)
+			}
+			validator.Validate(r.Context(), "calculator-api", &producer.Interaction{
+				Method:          r.Method,
+				Path:            r.URL.Path,
+				StatusCode:      rec.Code,
+				ResponseHeaders: headers,
+				ResponseBody:    rec.Body.String(),
+			})
+
+			for name, values := range rec.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+		})
+	}
+}
+
+// TestCompression_ParseConfig tests defaults, overrides and invalid settings.
+func TestCompression_ParseConfig(t *testing.T) {
+	config, err := compress.ParseConfig("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !config.Enabled() || !slices.Equal(config.Encodings, compress.DefaultEncodings) || config.MinSize != compress.DefaultMinSize {
+		t.Errorf("Expected defaults, got %+v", config)
+	}
+
+	config, err = compress.ParseConfig("GZIP", "0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(config.Encodings, []string{compress.Gzip}) || config.MinSize != 0 {
+		t.Errorf("Expected gzip only with no minimum, got %+v", config)
+	}
+
+	if config, _ := compress.ParseConfig("off", ""); config.Enabled() {
+		t.Error("Expected off to disable compression")
+	}
+
+	for _, tc := range []struct{ name, encodings, minSize string }{
+		{"unsupported encoding", "deflate", ""},
+		{"non-numeric min size", "", "1k"},
+		{"negative min size", "", "-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := compress.ParseConfig(tc.encodings, tc.minSize); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// TestCompression_Negotiate tests Accept-Encoding negotiation.
+func TestCompression_Negotiate(t *testing.T) {
+	offered := []string{compress.Brotli, compress.Gzip}
+
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", compress.Gzip},
+		{"br", compress.Brotli},
+		{"gzip, deflate, br", compress.Brotli},
+		{"GZIP", compress.Gzip},
+		{"gzip;q=1.0, br;q=0.5", compress.Gzip},
+		{"br;q=0, gzip;q=0.1", compress.Gzip},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", compress.Brotli},
+		{"*;q=0.5, br;q=0", compress.Gzip},
+		{"deflate", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			if got := compress.Negotiate(tc.acceptEncoding, offered); got != tc.expected {
+				t.Errorf("Negotiate(%q) = %q, want %q", tc.acceptEncoding, got, tc.expected)
+			}
+		})
+	}
+}
+
+// TestCompression_Responses tests which responses are compressed and that
+// they decode to exactly the bytes an uncompressed request gets.
+func TestCompression_Responses(t *testing.T) {
+	testCases := []struct {
+		name             string
+		minSize          int
+		method           string
+		path             string
+		acceptEncoding   string
+		expectedStatus   int
+		expectedEncoding string
+	}{
+		{"gzip above threshold", compress.DefaultMinSize, "GET", "/openapi.json", "gzip", 200, compress.Gzip},
+		{"brotli above threshold", compress.DefaultMinSize, "GET", "/openapi.json", "gzip, br", 200, compress.Brotli},
+		{"below threshold", compress.DefaultMinSize, "GET", "/add?x=1&y=2", "gzip, br", 200, ""},
+		{"result without threshold", 0, "GET", "/add?x=1&y=2", "br", 200, compress.Brotli},
+		{"error without threshold", 0, "GET", "/divide?x=1&y=0", "gzip", 400, compress.Gzip},
+		{"unmatched without threshold", 0, "GET", "/nope", "gzip", 404, compress.Gzip},
+		{"no Accept-Encoding", 0, "GET", "/openapi.json", "", 200, ""},
+		{"unsupported coding", 0, "GET", "/openapi.json", "deflate", 200, ""},
+		{"HEAD", 0, "HEAD", "/openapi.json", "gzip", 200, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := compress.Config{Encodings: compress.DefaultEncodings, MinSize: tc.minSize}
+			handler := newCompressionServer(t, config, func(next http.Handler) http.Handler { return next })
+
+			identity := httptest.NewRecorder()
+			newRoutingServer(t, func(next http.Handler) http.Handler { return next }).
+				ServeHTTP(identity, httptest.NewRequest(tc.method, tc.path, nil))
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			encoding := rec.Header().Get("Content-Encoding")
+			if encoding != tc.expectedEncoding {
+				t.Fatalf("Expected Content-Encoding %q, got %q", tc.expectedEncoding, encoding)
+			}
+			if !slices.Contains(rec.Header().Values("Vary"), "Accept-Encoding") {
+				t.Errorf("Expected Vary to include Accept-Encoding, got %v", rec.Header().Values("Vary"))
+			}
+			if encoding != "" && rec.Header().Get("Content-Length") != "" {
+				t.Errorf("Expected no Content-Length on a compressed response, got %s", rec.Header().Get("Content-Length"))
+			}
+			if body := decompress(t, encoding, rec.Body.Bytes()); !bytes.Equal(body, identity.Body.Bytes()) {
+				t.Errorf("Decoded body differs from the uncompressed response:\n%s\nvs\n%s", body, identity.Body.Bytes())
+			}
+		})
+	}
+}
+
+// TestCompression_ETag tests that a compressed representation carries the
+// handler's strong ETag with the coding appended, that it revalidates, and
+// that the identity tag still does too.
+func TestCompression_ETag(t *testing.T) {
+	config := compress.Config{Encodings: compress.DefaultEncodings}
+	handler := newCompressionServer(t, config, func(next http.Handler) http.Handler { return next })
+
+	get := func(path, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/openapi.json", "/add?x=5&y=3"} {
+		identity := get(path, "identity", "").Header().Get("ETag")
+		if !strings.HasPrefix(identity, `"`) {
+			t.Fatalf("%s: expected a strong ETag, got %q", path, identity)
+		}
+
+		for _, encoding := range compress.DefaultEncodings {
+			rec := get(path, encoding, "")
+			coded := rec.Header().Get("ETag")
+			if expected := strings.TrimSuffix(identity, `"`) + "-" + encoding + `"`; coded != expected {
+				t.Errorf("%s: expected %s ETag %s, got %q", path, encoding, expected, coded)
+			}
+
+			rec = get(path, encoding, coded)
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("%s: expected 304 for If-None-Match %s, got %d", path, coded, rec.Code)
+			}
+			if got := rec.Header().Get("ETag"); got != coded {
+				t.Errorf("%s: expected the 304 to carry %s, got %q", path, coded, got)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("%s: expected an empty 304 body, got %d bytes", path, rec.Body.Len())
+			}
+		}
+
+		if rec := get(path, "identity", identity); rec.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for the identity ETag, got %d", path, rec.Code)
+		}
+	}
+}
+
+// TestCompression_ValidationSeesUncompressedBody tests that with compression
+// on, validation runs against the JSON the handler wrote while the client
+// receives compressed bytes.
+func TestCompression_ValidationSeesUncompressedBody(t *testing.T) {
+	validator := &bodyValidator{spec: loadSpec(t, "calculator-api.yaml")}
+	config := compress.Config{Encodings: compress.DefaultEncodings, MinSize: 0}
+	handler := newCompressionServer(t, config, validateResponse(validator))
+
+	for _, path := range []string{"/add?x=5&y=3", "/divide?x=1&y=0"} {
+		for _, encoding := range compress.DefaultEncodings {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != encoding {
+				t.Errorf("%s: expected Content-Encoding %s, got %q", path, encoding, got)
+			}
+			if !json.Valid(decompress(t, encoding, rec.Body.Bytes())) {
+				t.Errorf("%s: decoded %s body is not JSON", path, encoding)
+			}
+		}
+	}
+
+	if len(validator.results) != 4 {
+		t.Fatalf("Expected 4 validations, got %d", len(validator.results))
+	}
+	for i, result := range validator.results {
+		if !result.Valid {
+			t.Errorf("Validation %d failed: %v", i, result.Errors)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// loadSpec loads one of the schema files embedded in the producer binary.
+func loadSpec(t *testing.T, name string) *contract.Spec {
+	t.Helper()
+
+	spec, err := contract.Load(filepath.Join("..", name))
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return spec
+}
+
+// schemaVersion returns the info.version of the contract the producer enforces.
+func schemaVersion(t *testing.T) string {
+	t.Helper()
+	return loadSpec(t, "calculator-api.yaml").Info.Version
+}
+
+// TestContract_EmbeddedFormatsAgree tests that the YAML and JSON schemas
+// embedded in the binary describe the same contract.
+func TestContract_EmbeddedFormatsAgree(t *testing.T) {
+	yamlSpec := loadSpec(t, "calculator-api.yaml")
+	jsonSpec := loadSpec(t, "calculator-api.json")
+
+	if !reflect.DeepEqual(yamlSpec, jsonSpec) {
+		t.Errorf("calculator-api.yaml and calculator-api.json differ:\nyaml: %+v\njson: %+v", yamlSpec, jsonSpec)
+	}
+}
+
+// calculatorRoutes returns the routes RegisterRoutes installs.
+func calculatorRoutes() []contract.Route {
+	return handlers.NewCalculator().RegisterRoutes(http.NewServeMux())
+}
+
+// TestContract_EmbeddedSpecMatchesRoutes tests that every operation in the
+// embedded schema, including /version and the streams, is served by
+// RegisterRoutes and vice versa.
+func TestContract_EmbeddedSpecMatchesRoutes(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+		if err := contract.CheckParity(spec, calculatorRoutes()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestContract_DocumentRoutesAreExempt tests that the routes serving the
+// contract are compared too, and pass only as the deliberate exemptions main
+// declares.
+func TestContract_DocumentRoutesAreExempt(t *testing.T) {
+	_, doc := newContractServer(t)
+	spec := loadSpec(t, "calculator-api.yaml")
+	h, err := contract.NewHandler(doc)
+	if err != nil {
+		t.Fatalf("Failed to create contract handler: %v", err)
+	}
+	routes := append(calculatorRoutes(), h.RegisterRoutes(http.NewServeMux())...)
+
+	var parityErr *contract.ParityError
+	if err := contract.CheckParity(spec, routes); !errors.As(err, &parityErr) || len(parityErr.Undocumented) != 3 {
+		t.Errorf("Expected the three document routes to be undocumented, got %v", err)
+	}
+	if err := contract.CheckParity(spec, routes, contract.JSONPath, contract.YAMLPath, contract.DocsPath); err != nil {
+		t.Errorf("Expected parity with the document routes exempt, got %v", err)
+	}
+}
+
+// TestContract_ParityReportsPreciseDiff tests that a mismatch names every
+// offending method and path on both sides.
+func TestContract_ParityReportsPreciseDiff(t *testing.T) {
+	spec := loadSpec(t, "calculator-api.json")
+
+	routes := append(calculatorRoutes(),
+		contract.Route{Method: "GET", Path: "/modulo"},  // handler without spec entry
+		contract.Route{Method: "POST", Path: "/add"},    // method without spec entry
+		contract.Route{Method: "GET", Path: "/metrics"}, // exempt
+	)
+	// Drop /divide so the spec has an operation without a handler
+	for i, route := range routes {
+		if route.Path == "/divide" {
+			routes = append(routes[:i], routes[i+1:]...)
+			break
+		}
+	}
+
+	err := contract.CheckParity(spec, routes, "/metrics")
+
+	var parityErr *contract.ParityError
+	if !errors.As(err, &parityErr) {
+		t.Fatalf("Expected a *contract.ParityError, got %v", err)
+	}
+
+	expectUndocumented := []contract.Route{{Method: "POST", Path: "/add"}, {Method: "GET", Path: "/modulo"}}
+	if !reflect.DeepEqual(parityErr.Undocumented, expectUndocumented) {
+		t.Errorf("Expected undocumented %v, got %v", expectUndocumented, parityErr.Undocumented)
+	}
+	expectUnimplemented := []contract.Route{{Method: "GET", Path: "/divide"}}
+	if !reflect.DeepEqual(parityErr.Unimplemented, expectUnimplemented) {
+		t.Errorf("Expected unimplemented %v, got %v", expectUnimplemented, parityErr.Unimplemented)
+	}
+
+	for _, want := range []string{"+ POST /add", "+ GET /modulo", "- GET /divide"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+// newContractServer serves the schema files the same way the producer does.
+func newContractServer(t *testing.T) (*http.ServeMux, *contract.Document) {
+	t.Helper()
+
+	jsonData, err := os.ReadFile(filepath.Join("..", "calculator-api.json"))
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	yamlData, err := os.ReadFile(filepath.Join("..", "calculator-api.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+
+	doc, err := contract.NewDocument(jsonData, yamlData)
+	if err != nil {
+		t.Fatalf("Failed to build document: %v", err)
+	}
+	h, err := contract.NewHandler(doc)
+	if err != nil {
+		t.Fatalf("Failed to create contract handler: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return mux, doc
+}
+
+// TestContract_ServesSchema tests that /openapi.json and /openapi.yaml return
+// the enforced schema with its version and an ETag.
+func TestContract_ServesSchema(t *testing.T) {
+	mux, doc := newContractServer(t)
+
+	testCases := []struct {
+		path        string
+		contentType string
+		body        []byte
+	}{
+		{contract.JSONPath, "application/json", doc.JSON},
+		{contract.YAMLPath, "application/yaml", doc.YAML},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("Expected Content-Type %s, got %s", tc.contentType, got)
+			}
+			if got := rec.Header().Get(contract.SchemaVersionHeader); got != doc.Spec.Info.Version {
+				t.Errorf("Expected %s %s, got %q", contract.SchemaVersionHeader, doc.Spec.Info.Version, got)
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("Expected an ETag header")
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tc.body) {
+				t.Error("Served schema differs from the embedded schema")
+			}
+		})
+	}
+}
+
+// TestContract_ETagRevalidation tests that a matching If-None-Match returns 304.
+func TestContract_ETagRevalidation(t *testing.T) {
+	mux, _ := newContractServer(t)
+
+	for _, path := range []string{contract.JSONPath, contract.YAMLPath, contract.DocsPath} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			etag := rec.Header().Get("ETag")
+
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("If-None-Match", etag)
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("Expected status 304, got %d", rec.Code)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("Expected empty body on 304, got %d bytes", rec.Body.Len())
+			}
+		})
+	}
+}
+
+// TestContract_DocsPage tests that the HTML reference is rendered from the
+// schema and does not load anything from a CDN.
+func TestContract_DocsPage(t *testing.T) {
+	mux, doc := newContractServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", contract.DocsPath, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Expected text/html, got %s", got)
+	}
+
+	page := rec.Body.String()
+	for _, endpoint := range doc.Spec.Endpoints() {
+		if !strings.Contains(page, endpoint.Path) || !strings.Contains(page, endpoint.Operation.OperationID) {
+			t.Errorf("Docs page is missing %s %s (%s)", endpoint.Method, endpoint.Path, endpoint.Operation.OperationID)
+		}
+	}
+	for _, external := range []string{"<script src=", "<link rel=\"stylesheet\" href=\"http"} {
+		if strings.Contains(page, external) {
+			t.Errorf("Docs page must be self-contained, found %q", external)
+		}
+	}
+}
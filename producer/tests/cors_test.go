@@ -0,0 +1,243 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/cors"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+const testOrigin = "https://app.example.com"
+
+// newCORSServer wires CORS, unmatched-route handling and the calculator the
+// way the producer does, with next standing in for the CVT middleware.
+func newCORSServer(t *testing.T, config cors.Config, next func(http.Handler) http.Handler) http.Handler {
+	t.Helper()
+	return cors.Middleware(config)(newRoutingServer(t, next))
+}
+
+// preflightRequest builds a browser preflight for a GET to path.
+func preflightRequest(path, origin, headers string) *http.Request {
+	req := httptest.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set(cors.RequestMethodHeader, "GET")
+	if headers != "" {
+		req.Header.Set(cors.RequestHeadersHeader, headers)
+	}
+	return req
+}
+
+// TestCORS_ParseConfig tests defaults, overrides and invalid settings.
+func TestCORS_ParseConfig(t *testing.T) {
+	config, err := cors.ParseConfig("", "", "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Enabled() {
+		t.Error("Expected CORS to be disabled without origins")
+	}
+	if config.MaxAge != cors.DefaultMaxAge || !slices.Equal(config.Methods, cors.DefaultMethods) {
+		t.Errorf("Expected defaults, got %+v", config)
+	}
+
+	config, err = cors.ParseConfig(" https://a.example.com, http://localhost:3000 ,", "get,head", "Content-Type", "60")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(config.Origins, []string{"https://a.example.com", "http://localhost:3000"}) {
+		t.Errorf("Unexpected origins %v", config.Origins)
+	}
+	if !slices.Equal(config.Methods, []string{"GET", "HEAD"}) {
+		t.Errorf("Unexpected methods %v", config.Methods)
+	}
+	if config.MaxAge != time.Minute {
+		t.Errorf("Expected max age 1m, got %v", config.MaxAge)
+	}
+
+	for _, tc := range []struct{ name, origins, maxAge string }{
+		{"origin without scheme", "app.example.com", ""},
+		{"non-numeric max age", "*", "10m"},
+		{"negative max age", "*", "-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := cors.ParseConfig(tc.origins, "", "", tc.maxAge); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// TestCORS_Preflight tests that allowed preflights get a 204 with the allowed
+// methods, headers and max age, and disallowed ones a JSON 403 without any
+// Access-Control-Allow-* headers.
+func TestCORS_Preflight(t *testing.T) {
+	config, _ := cors.ParseConfig(testOrigin, "", "", "120")
+	handler := newCORSServer(t, config, func(next http.Handler) http.Handler { return next })
+
+	t.Run("allowed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, preflightRequest("/add?x=1&y=2", testOrigin, "x-request-id, Content-Type"))
+
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rec.Code)
+		}
+		expected := map[string]string{
+			cors.AllowOriginHeader:  testOrigin,
+			cors.AllowMethodsHeader: "GET, HEAD",
+			cors.AllowHeadersHeader: "Content-Type, X-Request-ID, traceparent, Authorization, X-API-Key",
+			cors.MaxAgeHeader:       "120",
+		}
+		for header, value := range expected {
+			if got := rec.Header().Get(header); got != value {
+				t.Errorf("Expected %s %q, got %q", header, value, got)
+			}
+		}
+		if !slices.Contains(rec.Header().Values("Vary"), "Origin") {
+			t.Errorf("Expected Vary to include Origin, got %v", rec.Header().Values("Vary"))
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("Expected an empty body, got %q", rec.Body.String())
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+	}{
+		{"origin not allowed", preflightRequest("/add", "https://evil.example.com", "")},
+		{"method not allowed", func() *http.Request {
+			req := preflightRequest("/add", testOrigin, "")
+			req.Header.Set(cors.RequestMethodHeader, "DELETE")
+			return req
+		}()},
+		{"header not allowed", preflightRequest("/add", testOrigin, "X-Debug")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tc.req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("Expected status 403, got %d", rec.Code)
+			}
+			if got := rec.Header().Get(cors.AllowOriginHeader); got != "" {
+				t.Errorf("Expected no %s on a rejected preflight, got %q", cors.AllowOriginHeader, got)
+			}
+			var errResp handlers.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Error == "" {
+				t.Errorf("Expected a JSON error body, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestCORS_ActualRequests tests the headers on actual requests, including
+// error responses, and that requests without Origin are left untouched.
+func TestCORS_ActualRequests(t *testing.T) {
+	testCases := []struct {
+		name                string
+		origins             string
+		origin              string
+		path                string
+		expectedStatus      int
+		expectedAllowOrigin string
+	}{
+		{"allowed origin", testOrigin, testOrigin, "/add?x=1&y=2", 200, testOrigin},
+		{"any origin", "*", testOrigin, "/add?x=1&y=2", 200, "*"},
+		{"handler error", testOrigin, testOrigin, "/divide?x=1&y=0", 400, testOrigin},
+		{"unmatched route", testOrigin, testOrigin, "/nope", 404, testOrigin},
+		{"origin not allowed", testOrigin, "https://evil.example.com", "/add?x=1&y=2", 200, ""},
+		{"no origin", testOrigin, "", "/add?x=1&y=2", 200, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, _ := cors.ParseConfig(tc.origins, "", "", "")
+			handler := newCORSServer(t, config, func(next http.Handler) http.Handler { return next })
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if got := rec.Header().Get(cors.AllowOriginHeader); got != tc.expectedAllowOrigin {
+				t.Errorf("Expected %s %q, got %q", cors.AllowOriginHeader, tc.expectedAllowOrigin, got)
+			}
+			if exposed := "X-Request-ID, ETag, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Idempotent-Replayed"; tc.expectedAllowOrigin != "" && rec.Header().Get(cors.ExposeHeadersHeader) != exposed {
+				t.Errorf("Expected %s %s, got %q", cors.ExposeHeadersHeader, exposed, rec.Header().Get(cors.ExposeHeadersHeader))
+			}
+			if hasVary := rec.Header().Get("Vary") != ""; hasVary != (tc.origin != "") {
+				t.Errorf("Expected Vary only with an Origin, got %q", rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+// TestCORS_PreflightBypassesValidation tests that preflights never reach the
+// middleware standing in for CVT, while a plain OPTIONS without
+// Access-Control-Request-Method is routed like any other request.
+func TestCORS_PreflightBypassesValidation(t *testing.T) {
+	config, _ := cors.ParseConfig("*", "", "", "")
+	var validated []string
+	handler := newCORSServer(t, config, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			validated = append(validated, r.Method+" "+r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflightRequest("/add?x=1&y=2", testOrigin, ""))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight status 204, got %d", rec.Code)
+	}
+
+	plain := httptest.NewRequest("OPTIONS", "/add", nil)
+	plain.Header.Set("Origin", testOrigin)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, plain)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected plain OPTIONS status 405, got %d", rec.Code)
+	}
+
+	if len(validated) != 0 {
+		t.Errorf("Expected nothing to be validated, got %v", validated)
+	}
+}
+
+// TestCORS_HeadersInSpec tests that every documented response, including the
+// component responses, lists the CORS headers and that the component headers
+// they refer to exist.
+func TestCORS_HeadersInSpec(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		for _, component := range []string{cors.AllowOriginComponent, cors.ExposeHeadersComponent} {
+			if header, ok := spec.Components.Headers[component]; !ok || header.Description == "" {
+				t.Errorf("%s: missing component header %s", name, component)
+			}
+		}
+
+		for _, e := range spec.Endpoints() {
+			for status, response := range e.Operation.Responses {
+				response = spec.ResolveResponse(response)
+				for header, expected := range cors.SpecHeaders() {
+					if ref := response.Headers[header].RefName(); ref != expected.RefName() {
+						t.Errorf("%s: %s %s %s: %s refers to %q, want %s",
+							name, e.Method, e.Path, status, header, ref, expected.RefName())
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/deploy"
+	"github.com/sahina/cvt/sdks/go/cvt"
+)
+
+// fakeChecker returns a canned can-i-deploy answer without a CVT server.
+type fakeChecker struct {
+	result *cvt.CanIDeployResult
+	err    error
+	calls  int
+}
+
+func (f *fakeChecker) CanIDeploy(ctx context.Context, schemaID, version, environment string) (*cvt.CanIDeployResult, error) {
+	f.calls++
+	return f.result, f.err
+}
+
+// TestDeployGate_SchemaVersion tests that both spec documents carry the same
+// info.version for the gate to check.
+func TestDeployGate_SchemaVersion(t *testing.T) {
+	var versions []string
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec, err := contract.Load(filepath.Join("..", name))
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		if spec.Info.Version == "" {
+			t.Errorf("%s: expected an info.version", name)
+		}
+		versions = append(versions, spec.Info.Version)
+	}
+	if versions[0] != versions[1] {
+		t.Errorf("Expected the YAML and JSON specs to share info.version, got %q and %q", versions[0], versions[1])
+	}
+}
+
+// TestDeployGate_Modes tests how each mode reacts to safe and unsafe results.
+func TestDeployGate_Modes(t *testing.T) {
+	safe := &cvt.CanIDeployResult{SafeToDeploy: true, Summary: "no consumers affected"}
+	unsafe := &cvt.CanIDeployResult{SafeToDeploy: false, Summary: "consumer-1 uses 'result'"}
+
+	testCases := []struct {
+		name           string
+		mode           deploy.Mode
+		result         *cvt.CanIDeployResult
+		expectUnsafe   bool
+		expectDegraded bool
+		expectCalls    int
+	}{
+		{"enforce safe", deploy.ModeEnforce, safe, false, false, 1},
+		{"enforce unsafe", deploy.ModeEnforce, unsafe, true, false, 1},
+		{"degraded safe", deploy.ModeDegraded, safe, false, false, 1},
+		{"degraded unsafe", deploy.ModeDegraded, unsafe, false, true, 1},
+		{"off unsafe", deploy.ModeOff, unsafe, false, false, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := &fakeChecker{result: tc.result}
+			outcome, err := deploy.Check(context.Background(), checker, deploy.Config{
+				SchemaID:    "calculator-api",
+				Version:     "1.0.0",
+				Environment: "demo",
+				Mode:        tc.mode,
+			})
+
+			if got := errors.Is(err, deploy.ErrUnsafe); got != tc.expectUnsafe {
+				t.Errorf("Expected ErrUnsafe=%v, got err=%v", tc.expectUnsafe, err)
+			}
+			if outcome.Degraded != tc.expectDegraded {
+				t.Errorf("Expected Degraded=%v, got %v", tc.expectDegraded, outcome.Degraded)
+			}
+			if checker.calls != tc.expectCalls {
+				t.Errorf("Expected %d CanIDeploy calls, got %d", tc.expectCalls, checker.calls)
+			}
+		})
+	}
+}
+
+// TestDeployGate_CVTUnavailable tests that CVT errors are reported but never
+// mistaken for an unsafe deployment.
+func TestDeployGate_CVTUnavailable(t *testing.T) {
+	checker := &fakeChecker{err: errors.New("connection refused")}
+	outcome, err := deploy.Check(context.Background(), checker, deploy.Config{
+		SchemaID: "calculator-api",
+		Version:  "1.0.0",
+		Mode:     deploy.ModeEnforce,
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error when CVT is unavailable")
+	}
+	if errors.Is(err, deploy.ErrUnsafe) {
+		t.Errorf("CVT errors must not be reported as ErrUnsafe: %v", err)
+	}
+	if outcome.Checked {
+		t.Error("Expected Checked=false when CVT did not answer")
+	}
+}
+
+// TestDeployGate_NoResult tests that CVT answering without a result is
+// treated as a check that could not run, not as a safe deployment.
+func TestDeployGate_NoResult(t *testing.T) {
+	checker := &fakeChecker{}
+	outcome, err := deploy.Check(context.Background(), checker, deploy.Config{
+		SchemaID: "calculator-api",
+		Version:  "1.0.0",
+		Mode:     deploy.ModeEnforce,
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error when CVT returns no result")
+	}
+	if errors.Is(err, deploy.ErrUnsafe) {
+		t.Errorf("A missing result must not be reported as ErrUnsafe: %v", err)
+	}
+	if outcome.Checked {
+		t.Error("Expected Checked=false when CVT returned no result")
+	}
+}
+
+// TestDeployGate_ParseMode tests configuration parsing.
+func TestDeployGate_ParseMode(t *testing.T) {
+	if mode, err := deploy.ParseMode(""); err != nil || mode != deploy.ModeEnforce {
+		t.Errorf("Expected empty mode to default to enforce, got %q (%v)", mode, err)
+	}
+	if _, err := deploy.ParseMode("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
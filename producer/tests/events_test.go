@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/events"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// sseEvent is an event read from a text/event-stream.
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// newEventsServer serves the Calculator's routes behind the logging
+// middleware, so calculations carry request IDs.
+func newEventsServer(t *testing.T, config handlers.EventsConfig) *httptest.Server {
+	t.Helper()
+
+	// Routes first, as main registers them before configuring the feed
+	calc := handlers.NewCalculator()
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	calc.SetEventsConfig(config)
+
+	server := httptest.NewServer(logging.Middleware(logging.New(io.Discard, slog.LevelInfo, logging.FormatJSON))(mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// openEvents opens /events on server, resuming after lastEventID if it is
+// set, and returns the status and a reader of the stream.
+func openEvents(t *testing.T, server *httptest.Server, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", server.URL+handlers.EventsPath, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readEvent reads the next event, skipping comments, within a few seconds.
+func readEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+
+	read := make(chan sseEvent, 1)
+	go func() {
+		var e sseEvent
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				close(read)
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			field, value, _ := strings.Cut(line, ": ")
+			switch {
+			case line == "" && e != (sseEvent{}):
+				read <- e
+				return
+			case field == "id":
+				e.id = value
+			case field == "event":
+				e.event = value
+			case field == "data":
+				e.data = value
+			}
+		}
+	}()
+
+	select {
+	case e, ok := <-read:
+		if !ok {
+			t.Fatal("Event stream ended")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+// calculationOf decodes an event's data and checks it against the
+// CalculationEvent schema.
+func calculationOf(t *testing.T, e sseEvent) map[string]any {
+	t.Helper()
+
+	if e.event != handlers.CalculationEventType {
+		t.Errorf("Expected event type %q, got %q", handlers.CalculationEventType, e.event)
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(e.data), &data); err != nil {
+		t.Fatalf("Event data is not JSON: %s", e.data)
+	}
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		if violations := loadSpec(t, name).ValidateComponent("CalculationEvent", data); len(violations) > 0 {
+			t.Errorf("%s: event %s violates CalculationEvent: %v", name, e.data, violations)
+		}
+	}
+	return data
+}
+
+// calculate calls an operation on server and returns its X-Request-ID.
+func calculate(t *testing.T, server *httptest.Server, path string) string {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get(logging.RequestIDHeader)
+}
+
+// TestEvents_Stream tests that every calculation is streamed as an event
+// with its inputs, its result or error and its request ID.
+func TestEvents_Stream(t *testing.T) {
+	server := newEventsServer(t, handlers.DefaultEventsConfig())
+	resp, stream := openEvents(t, server, "")
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected a 200 event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	testCases := []struct {
+		path   string
+		result any
+		error  string
+	}{
+		{"/add?x=5&y=3", 8.0, ""},
+		{"/divide?x=1&y=0", nil, handlers.ErrDivisionByZero.Error()},
+	}
+
+	for i, tc := range testCases {
+		requestID := calculate(t, server, tc.path)
+		e := readEvent(t, stream)
+		data := calculationOf(t, e)
+
+		if e.id != strconv.Itoa(i+1) {
+			t.Errorf("%s: expected id %d, got %q", tc.path, i+1, e.id)
+		}
+		if data["requestId"] != requestID {
+			t.Errorf("%s: expected request ID %q, got %v", tc.path, requestID, data["requestId"])
+		}
+		if data["result"] != tc.result || (data["error"] != nil && data["error"] != tc.error) {
+			t.Errorf("%s: expected result %v and error %q, got %v", tc.path, tc.result, tc.error, data)
+		}
+		if inputs, _ := data["inputs"].(map[string]any); len(inputs) != 2 {
+			t.Errorf("%s: expected inputs x and y, got %v", tc.path, data["inputs"])
+		}
+	}
+
+	// Requests that never reach the operation are not calculations
+	calculate(t, server, "/add?x=5")
+	calculate(t, server, "/subtract?x=5&y=3")
+	if data := calculationOf(t, readEvent(t, stream)); data["operation"] != "subtract" {
+		t.Errorf("Expected the subtract event next, got %v", data)
+	}
+}
+
+// TestEvents_Resume tests that Last-Event-ID replays the events after it.
+func TestEvents_Resume(t *testing.T) {
+	config := handlers.DefaultEventsConfig()
+	config.ReplaySize = 3
+	server := newEventsServer(t, config)
+	for _, path := range []string{"/add?x=1&y=1", "/add?x=2&y=2", "/add?x=3&y=3", "/add?x=4&y=4"} {
+		calculate(t, server, path)
+	}
+
+	testCases := []struct {
+		name        string
+		lastEventID string
+		expectedIDs []string
+	}{
+		{"resume after 2", "2", []string{"3", "4"}},
+		{"older than the buffer", "0", []string{"2", "3", "4"}},
+		{"newer than the latest", "99", []string{"2", "3", "4"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, stream := openEvents(t, server, tc.lastEventID)
+			for _, id := range tc.expectedIDs {
+				if e := readEvent(t, stream); e.id != id {
+					t.Errorf("Expected event %s, got %s", id, e.id)
+				}
+			}
+		})
+	}
+
+	resp, _ := openEvents(t, server, "latest")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid Last-Event-ID to get a 400, got %d", resp.StatusCode)
+	}
+}
+
+// TestEvents_Keepalive tests that an idle stream gets comment lines.
+func TestEvents_Keepalive(t *testing.T) {
+	config := handlers.DefaultEventsConfig()
+	config.KeepaliveInterval = 20 * time.Millisecond
+	_, stream := openEvents(t, newEventsServer(t, config), "")
+
+	line, err := stream.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, ":") {
+		t.Errorf("Expected a comment line, got %q, %v", line, err)
+	}
+}
+
+// TestEvents_Feed tests replay and slow subscribers in the feed itself.
+func TestEvents_Feed(t *testing.T) {
+	feed := events.NewFeed(2)
+	replay, live := feed.Subscribe(0, false)
+	defer live.Close()
+	if len(replay) != 0 {
+		t.Errorf("Expected no replay without resumption, got %v", replay)
+	}
+
+	for i := range 100 {
+		feed.Publish(events.Calculation{Operation: "add", Inputs: map[string]float64{"x": float64(i), "y": 1}})
+	}
+	if replay, s := feed.Subscribe(99, true); len(replay) != 1 || replay[0].ID != 100 {
+		t.Errorf("Expected event 100 to be replayed, got %v", replay)
+	} else {
+		s.Close()
+	}
+
+	// live never read, so it fell behind and was dropped
+	received := 0
+	for range live.C {
+		received++
+	}
+	if received == 0 || received == 100 {
+		t.Errorf("Expected a slow subscriber to be dropped after some events, got %d", received)
+	}
+}
+
+// TestEvents_ParseConfig tests the EVENTS_REPLAY_SIZE setting.
+func TestEvents_ParseConfig(t *testing.T) {
+	if config, err := handlers.ParseEventsConfig(""); err != nil || config.ReplaySize != events.DefaultReplaySize {
+		t.Errorf("Expected the default replay size, got %+v, %v", config, err)
+	}
+	if config, err := handlers.ParseEventsConfig("0"); err != nil || config.ReplaySize != 0 {
+		t.Errorf("Expected 0 to disable replay, got %+v, %v", config, err)
+	}
+	for _, value := range []string{"-1", "lots"} {
+		if _, err := handlers.ParseEventsConfig(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
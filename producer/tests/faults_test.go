@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/faults"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// newFaultServer serves the calculator routes and the fault admin API with
+// the given faults injected, as main does with FAULTS_FILE.
+func newFaultServer(t *testing.T, config string) (*httptest.Server, *faults.Injector) {
+	t.Helper()
+
+	injector := faults.NewInjector(handlers.StreamPath, handlers.EventsPath)
+	if config != "" {
+		parsed, err := faults.Parse([]byte(config))
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		injector.Set(parsed)
+	}
+
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	injector.RegisterRoutes(mux)
+	server := httptest.NewServer(injector.Middleware(mux))
+	t.Cleanup(server.Close)
+	return server, injector
+}
+
+// faultGet sends a GET to server as the given consumer, if any, and returns
+// the response with its body read.
+func faultGet(t *testing.T, server *httptest.Server, path, consumer string) (*http.Response, []byte, error) {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	if consumer != "" {
+		req.Header.Set(faults.ConsumerHeader, consumer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// TestFaults_Kinds tests what each kind of fault does to /add.
+func TestFaults_Kinds(t *testing.T) {
+	spec := loadSpec(t, "calculator-api.yaml")
+
+	t.Run("latency", func(t *testing.T) {
+		server, _ := newFaultServer(t, `{"faults":[{"kind":"latency","latency":"100ms"}]}`)
+		start := time.Now()
+		resp, body, err := faultGet(t, server, "/add?x=5&y=3", "")
+		if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"result":8`) {
+			t.Fatalf("Expected the result after the delay, got %v %s", err, body)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected a delay of at least 100ms, took %v", elapsed)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		server, _ := newFaultServer(t, `{"faults":[{"kind":"error","status":503}]}`)
+		resp, body, err := faultGet(t, server, "/add?x=5&y=3", "")
+		if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Expected a 503, got %v %v", resp, err)
+		}
+		var value any
+		json.Unmarshal(body, &value)
+		if violations := spec.ValidateComponent("Error", value); len(violations) > 0 {
+			t.Errorf("Expected an Error body, got %s: %v", body, violations)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		server, _ := newFaultServer(t, `{"faults":[{"kind":"malformed"}]}`)
+		resp, body, err := faultGet(t, server, "/add?x=5&y=3", "")
+		if err != nil || resp.StatusCode != http.StatusOK || len(body) == 0 {
+			t.Fatalf("Expected a 200 with a body, got %v %v", resp, err)
+		}
+		if json.Valid(body) {
+			t.Errorf("Expected malformed JSON, got %s", body)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		server, _ := newFaultServer(t, `{"faults":[{"kind":"drop"}]}`)
+		if resp, _, err := faultGet(t, server, "/add?x=5&y=3", ""); err == nil {
+			t.Errorf("Expected the connection to be dropped, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("violation", func(t *testing.T) {
+		server, _ := newFaultServer(t, `{"faults":[{"kind":"violation"}]}`)
+		resp, body, err := faultGet(t, server, "/add?x=5&y=3", "")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a 200, got %v %v", resp, err)
+		}
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			t.Fatalf("Expected JSON, got %s", body)
+		}
+		if violations := spec.ValidateComponent("Result", value); len(violations) == 0 {
+			t.Errorf("Expected %s to violate the Result schema", body)
+		}
+	})
+}
+
+// TestFaults_Scope tests that faults apply only to their route and consumer,
+// and as often as their probability says.
+func TestFaults_Scope(t *testing.T) {
+	server, _ := newFaultServer(t, `{"faults":[
+		{"kind":"error","route":"/add","consumer":"consumer-4"},
+		{"kind":"error","route":"/subtract","probability":0},
+		{"kind":"error","route":"/multiply","probability":0.5}
+	]}`)
+
+	testCases := []struct {
+		path     string
+		consumer string
+		faulted  bool
+	}{
+		{"/add?x=1&y=2", "consumer-4", true},
+		{"/add?x=1&y=2", "consumer-1", false},
+		{"/add?x=1&y=2", "", false},
+		{"/divide?x=1&y=2", "consumer-4", false},
+		{"/subtract?x=1&y=2", "consumer-4", false},
+	}
+	for _, tc := range testCases {
+		resp, _, err := faultGet(t, server, tc.path, tc.consumer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if faulted := resp.StatusCode == http.StatusInternalServerError; faulted != tc.faulted {
+			t.Errorf("%s as %q: expected faulted=%v, got %d", tc.path, tc.consumer, tc.faulted, resp.StatusCode)
+		}
+	}
+
+	faulted := 0
+	for range 200 {
+		if resp, _, _ := faultGet(t, server, "/multiply?x=1&y=2", ""); resp.StatusCode != http.StatusOK {
+			faulted++
+		}
+	}
+	if faulted < 50 || faulted > 150 {
+		t.Errorf("Expected about half of 200 requests to be faulted, got %d", faulted)
+	}
+}
+
+// TestFaults_Admin tests replacing, reading and clearing the faults through
+// the admin API, which is never faulted itself.
+func TestFaults_Admin(t *testing.T) {
+	server, injector := newFaultServer(t, "")
+	admin := server.URL + faults.AdminPath
+
+	put := func(body string) *http.Response {
+		req, _ := http.NewRequest("PUT", admin, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := put(`{"faults":[{"kind":"error","status":502}]}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected PUT to succeed, got %d", resp.StatusCode)
+	}
+	if resp, _, _ := faultGet(t, server, "/add?x=1&y=2", ""); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the new fault, got %d", resp.StatusCode)
+	}
+
+	resp, body, _ := faultGet(t, server, faults.AdminPath, "")
+	var config faults.Config
+	if err := json.Unmarshal(body, &config); err != nil || resp.StatusCode != http.StatusOK || len(config.Faults) != 1 {
+		t.Errorf("Expected GET to return the fault, got %d %s", resp.StatusCode, body)
+	} else if config.Faults[0].Probability == nil || *config.Faults[0].Probability != 1 {
+		t.Errorf("Expected the default probability of 1, got %s", body)
+	}
+
+	if resp := put(`{"faults":[{"kind":"explode"}]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid configuration to get a 400, got %d", resp.StatusCode)
+	}
+	if len(injector.Config().Faults) != 1 {
+		t.Error("Expected an invalid configuration to leave the faults alone")
+	}
+
+	req, _ := http.NewRequest("POST", admin, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to get a 405, got %v %v", resp, err)
+	}
+
+	req, _ = http.NewRequest("DELETE", admin, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected DELETE to succeed, got %v %v", resp, err)
+	}
+	if resp, _, _ := faultGet(t, server, "/add?x=1&y=2", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected no faults after DELETE, got %d", resp.StatusCode)
+	}
+}
+
+// TestFaults_AdminAuth tests that with authentication configured only
+// credentials granting faults.AdminGrant may use the admin API: unrestricted
+// consumer keys are not enough.
+func TestFaults_AdminAuth(t *testing.T) {
+	keys, err := auth.ParseAPIKeys([]byte(testAPIKeys + "ops key-ops " + faults.AdminGrant + "\n"))
+	if err != nil {
+		t.Fatalf("ParseAPIKeys failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	faults.NewInjector().RegisterRoutes(mux, keys)
+
+	for _, tc := range []struct {
+		name, method, apiKey string
+		expectedStatus       int
+	}{
+		{"no credentials", "GET", "", http.StatusUnauthorized},
+		{"unknown key", "PUT", "key-unknown", http.StatusUnauthorized},
+		{"unrestricted consumer key", "PUT", "key-ci", http.StatusForbidden},
+		{"restricted consumer key", "DELETE", "key-dash", http.StatusForbidden},
+		{"admin key", "GET", "key-ops", http.StatusOK},
+		{"admin key clears", "DELETE", "key-ops", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, faults.AdminPath, strings.NewReader(`{"faults":[]}`))
+			if tc.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// TestFaults_Config tests FAULTS_FILE loading and validation.
+func TestFaults_Config(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	os.WriteFile(path, []byte(`{"faults":[{"kind":"latency","latency":"2s","route":"/divide"}]}`), 0o600)
+	config, err := faults.Load(path)
+	if err != nil || len(config.Faults) != 1 || time.Duration(config.Faults[0].Latency) != 2*time.Second {
+		t.Errorf("Expected a 2s latency fault, got %+v, %v", config, err)
+	}
+	if _, err := faults.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected a missing file to be rejected")
+	}
+
+	for _, invalid := range []string{
+		`{"faults":[{"kind":"latency"}]}`,
+		`{"faults":[{"kind":"latency","latency":"soon"}]}`,
+		`{"faults":[{"kind":"error","status":200}]}`,
+		`{"faults":[{"kind":"error","probability":1.5}]}`,
+		`{"faults":[{"kind":"error","route":"add"}]}`,
+		`{"faults":[{"kind":"error","delay":"1s"}]}`,
+		`not json`,
+	} {
+		if _, err := faults.Parse([]byte(invalid)); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/grpcapi"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/health"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newGRPCClient serves the CalculatorService over an in-memory connection
+// with the given interceptors, in main's order, and returns a client.
+func newGRPCClient(t *testing.T, interceptors ...grpc.UnaryServerInterceptor) calculatorpb.CalculatorServiceClient {
+	t.Helper()
+	return newRegistryGRPCClient(t, handlers.NewCalculator().Registry(), interceptors...)
+}
+
+// newRegistryGRPCClient is newGRPCClient for the operations in registry.
+func newRegistryGRPCClient(t *testing.T, registry *handlers.Registry, interceptors ...grpc.UnaryServerInterceptor) calculatorpb.CalculatorServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	calculatorpb.RegisterCalculatorServiceServer(server, grpcapi.NewServer(registry))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return calculatorpb.NewCalculatorServiceClient(conn)
+}
+
+// callGRPC calls the RPC of the named operation.
+func callGRPC(ctx context.Context, client calculatorpb.CalculatorServiceClient, operation string, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	switch operation {
+	case "add":
+		return client.Add(ctx, req)
+	case "subtract":
+		return client.Subtract(ctx, req)
+	case "multiply":
+		return client.Multiply(ctx, req)
+	case "divide":
+		return client.Divide(ctx, req)
+	}
+	return nil, fmt.Errorf("no RPC for operation %s", operation)
+}
+
+// TestGRPC_ServiceParity tests that the CalculatorService has an RPC for
+// every operation in the registry, and no other.
+func TestGRPC_ServiceParity(t *testing.T) {
+	var methods []string
+	for _, method := range calculatorpb.CalculatorService_ServiceDesc.Methods {
+		methods = append(methods, strings.ToLower(method.MethodName))
+	}
+	var operations []string
+	for _, op := range handlers.NewCalculator().Registry().Operations() {
+		operations = append(operations, op.Name())
+	}
+	slices.Sort(methods)
+	slices.Sort(operations)
+
+	if !slices.Equal(methods, operations) {
+		t.Errorf("Expected RPCs %v to match operations %v", methods, operations)
+	}
+}
+
+// TestGRPC_Operations tests that every RPC computes what its REST operation
+// computes.
+func TestGRPC_Operations(t *testing.T) {
+	client := newGRPCClient(t, grpcapi.Recover())
+
+	for _, tc := range CalculatorTestCases() {
+		if tc.ExpectError {
+			continue
+		}
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := callGRPC(context.Background(), client, strings.TrimPrefix(tc.Path, "/"),
+				&calculatorpb.OperationRequest{X: proto.Float64(tc.X), Y: proto.Float64(tc.Y)})
+			if err != nil {
+				t.Fatalf("RPC failed: %v", err)
+			}
+			if resp.GetResult() != tc.ExpectedResult {
+				t.Errorf("Expected result %v, got %v", tc.ExpectedResult, resp.GetResult())
+			}
+		})
+	}
+}
+
+// TestGRPC_Errors tests that domain errors fail with INVALID_ARGUMENT and
+// the REST API's error message.
+func TestGRPC_Errors(t *testing.T) {
+	client := newGRPCClient(t, grpcapi.Recover())
+
+	testCases := []struct {
+		name            string
+		operation       string
+		request         *calculatorpb.OperationRequest
+		expectedMessage string
+	}{
+		{"division by zero", "divide", &calculatorpb.OperationRequest{X: proto.Float64(10), Y: proto.Float64(0)}, handlers.ErrDivisionByZero.Error()},
+		{"missing y", "add", &calculatorpb.OperationRequest{X: proto.Float64(1)}, handlers.MissingOperandsMessage(2)},
+		{"missing both", "multiply", &calculatorpb.OperationRequest{}, handlers.MissingOperandsMessage(2)},
+		{"explicit zero operands", "subtract", &calculatorpb.OperationRequest{X: proto.Float64(0), Y: proto.Float64(0)}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := callGRPC(context.Background(), client, tc.operation, tc.request)
+			if tc.expectedMessage == "" {
+				if err != nil {
+					t.Fatalf("Expected success, got %v", err)
+				}
+				return
+			}
+
+			st := status.Convert(err)
+			if st.Code() != codes.InvalidArgument {
+				t.Fatalf("Expected %v, got %v", codes.InvalidArgument, err)
+			}
+			if st.Message() != tc.expectedMessage {
+				t.Errorf("Expected message %q, got %q", tc.expectedMessage, st.Message())
+			}
+		})
+	}
+}
+
+// TestGRPC_Auth tests that RPCs require the credentials the REST operations
+// require, sent as metadata.
+func TestGRPC_Auth(t *testing.T) {
+	keys, err := auth.ParseAPIKeys([]byte(testAPIKeys))
+	if err != nil {
+		t.Fatalf("ParseAPIKeys failed: %v", err)
+	}
+	j, err := auth.LoadJWT(auth.JWTConfig{HS256SecretFile: writeFile(t, "secret", []byte(testHS256Secret))})
+	if err != nil {
+		t.Fatalf("LoadJWT failed: %v", err)
+	}
+	client := newGRPCClient(t, grpcapi.Authenticate(keys, j), grpcapi.Recover())
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testHS256Secret),
+		jwt.MapClaims{"sub": "ci", "exp": time.Now().Add(time.Hour).Unix(), "scope": "add"})
+
+	testCases := []struct {
+		name         string
+		operation    string
+		metadata     []string
+		expectedCode codes.Code
+	}{
+		{"api key", "divide", []string{"x-api-key", "key-ci"}, codes.OK},
+		{"restricted api key, allowed", "add", []string{"x-api-key", "key-dash"}, codes.OK},
+		{"restricted api key, other operation", "divide", []string{"x-api-key", "key-dash"}, codes.PermissionDenied},
+		{"unknown api key", "add", []string{"x-api-key", "key-unknown"}, codes.Unauthenticated},
+		{"bearer token", "add", []string{"authorization", "Bearer " + token}, codes.OK},
+		{"bearer token, other operation", "multiply", []string{"authorization", "Bearer " + token}, codes.PermissionDenied},
+		{"invalid bearer token", "add", []string{"authorization", "Bearer not-a-token"}, codes.Unauthenticated},
+		{"no credentials", "add", nil, codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), tc.metadata...)
+			_, err := callGRPC(ctx, client, tc.operation,
+				&calculatorpb.OperationRequest{X: proto.Float64(6), Y: proto.Float64(3)})
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("Expected %v, got %v", tc.expectedCode, err)
+			}
+		})
+	}
+}
+
+// TestGRPC_Interaction tests that a call's interaction is the response the
+// REST API gives to the same operands.
+func TestGRPC_Interaction(t *testing.T) {
+	registry := handlers.NewCalculator().Registry()
+	server := grpcapi.NewServer(registry)
+	mux := http.NewServeMux()
+	for _, route := range registry.Routes() {
+		mux.Handle(route.Method+" "+route.Path, route.Handler)
+	}
+
+	testCases := []struct {
+		name      string
+		operation string
+		request   *calculatorpb.OperationRequest
+	}{
+		{"result", "add", &calculatorpb.OperationRequest{X: proto.Float64(5), Y: proto.Float64(3)}},
+		{"fraction", "divide", &calculatorpb.OperationRequest{X: proto.Float64(1), Y: proto.Float64(3)}},
+		{"division by zero", "divide", &calculatorpb.OperationRequest{X: proto.Float64(10), Y: proto.Float64(0)}},
+		{"missing operand", "subtract", &calculatorpb.OperationRequest{Y: proto.Float64(2)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := callGRPCServer(server, tc.operation, tc.request)
+			interaction := grpcapi.Interaction(tc.operation, tc.request, resp, err)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(interaction.Method, interaction.Path, nil))
+
+			if interaction.StatusCode != rec.Code {
+				t.Errorf("Expected status %d, got %d", rec.Code, interaction.StatusCode)
+			}
+			var expected, got any
+			json.Unmarshal(rec.Body.Bytes(), &expected)
+			json.Unmarshal([]byte(interaction.ResponseBody), &got)
+			if fmt.Sprint(expected) != fmt.Sprint(got) {
+				t.Errorf("Expected body %s, got %s", rec.Body.String(), interaction.ResponseBody)
+			}
+			if ct := interaction.ResponseHeaders["Content-Type"]; ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %q", ct)
+			}
+		})
+	}
+
+	for code, expected := range map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.InvalidArgument:   http.StatusBadRequest,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.PermissionDenied:  http.StatusForbidden,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.Internal:          http.StatusInternalServerError,
+		codes.Unknown:           http.StatusInternalServerError,
+	} {
+		if got := grpcapi.HTTPStatus(code); got != expected {
+			t.Errorf("HTTPStatus(%v) = %d, expected %d", code, got, expected)
+		}
+	}
+}
+
+// callGRPCServer calls the named operation's method on server directly.
+func callGRPCServer(server *grpcapi.Server, operation string, req *calculatorpb.OperationRequest) (*calculatorpb.OperationResponse, error) {
+	ctx := context.Background()
+	switch operation {
+	case "add":
+		return server.Add(ctx, req)
+	case "subtract":
+		return server.Subtract(ctx, req)
+	case "multiply":
+		return server.Multiply(ctx, req)
+	default:
+		return server.Divide(ctx, req)
+	}
+}
+
+// recordingValidator records the interactions it validates and rejects
+// those of the given paths.
+type recordingValidator struct {
+	invalid      []string
+	interactions []*producer.Interaction
+}
+
+func (v *recordingValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	v.interactions = append(v.interactions, interaction)
+	if slices.Contains(v.invalid, interaction.Path) {
+		return &producer.ValidationResult{Valid: false, Errors: []string{"response does not match schema"}}, nil
+	}
+	return &producer.ValidationResult{Valid: true}, nil
+}
+
+// TestGRPC_Validate tests that calls are validated as their REST
+// interactions, and that strict mode fails calls that do not comply.
+func TestGRPC_Validate(t *testing.T) {
+	invalidPath := "/multiply?x=2&y=3"
+
+	for _, tc := range []struct {
+		mode         producer.ValidationMode
+		expectedCode codes.Code
+	}{
+		{producer.ModeStrict, codes.Internal},
+		{producer.ModeWarn, codes.OK},
+		{producer.ModeShadow, codes.OK},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			validator := &recordingValidator{invalid: []string{invalidPath}}
+			client := newGRPCClient(t, grpcapi.Validate(validator, "calculator-api", tc.mode), grpcapi.Recover())
+			ctx := context.Background()
+
+			if _, err := client.Add(ctx, &calculatorpb.OperationRequest{X: proto.Float64(5), Y: proto.Float64(3)}); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+			_, err := client.Divide(ctx, &calculatorpb.OperationRequest{X: proto.Float64(1), Y: proto.Float64(0)})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Expected the domain error to pass validation, got %v", err)
+			}
+			_, err = client.Multiply(ctx, &calculatorpb.OperationRequest{X: proto.Float64(2), Y: proto.Float64(3)})
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("Expected %v for a non-compliant call, got %v", tc.expectedCode, err)
+			}
+
+			var got []string
+			for _, interaction := range validator.interactions {
+				got = append(got, fmt.Sprintf("%s %s %d", interaction.Method, interaction.Path, interaction.StatusCode))
+			}
+			expected := []string{"GET /add?x=5&y=3 200", "GET /divide?x=1&y=0 400", "GET " + invalidPath + " 200"}
+			if !slices.Equal(got, expected) {
+				t.Errorf("Expected interactions %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+// TestGRPC_Recover tests that a panicking RPC fails with INTERNAL and the
+// REST API's 500 message.
+func TestGRPC_Recover(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/calculator.v1.CalculatorService/Add"}
+	_, err := grpcapi.Recover()(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	})
+
+	st := status.Convert(err)
+	if st.Code() != codes.Internal || st.Message() != handlers.InternalErrorMessage {
+		t.Errorf("Expected %v %q, got %v", codes.Internal, handlers.InternalErrorMessage, err)
+	}
+
+	if name, ok := grpcapi.OperationName(info.FullMethod); !ok || name != "add" {
+		t.Errorf("Expected operation add, got %q (%v)", name, ok)
+	}
+	if _, ok := grpcapi.OperationName("/grpc.health.v1.Health/Check"); ok {
+		t.Error("Expected no operation for another service")
+	}
+}
+
+// togglingProber reports CVT unreachable once lost is set.
+type togglingProber struct {
+	lost atomic.Bool
+}
+
+func (p *togglingProber) Probe(ctx context.Context) error {
+	if p.lost.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// TestGRPC_Health tests that the gRPC health service reports the checker's
+// status as /ready does, and that Watch follows it.
+func TestGRPC_Health(t *testing.T) {
+	checker := health.NewChecker()
+	prober := &togglingProber{}
+	checker.ExpectValidation("cvt:9550", prober)
+	checker.SchemaRegistered()
+	checker.EnableValidation("strict")
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, grpcapi.NewHealthServer(checker, 10*time.Millisecond))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", service, err)
+		}
+		return resp.GetStatus()
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("Watch: %v", err)
+		}
+		return resp.GetStatus()
+	}
+
+	for _, service := range []string{"", "calculator.v1.CalculatorService"} {
+		if got := check(service); got != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Healthy: Check(%q) = %v, expected SERVING", service, got)
+		}
+	}
+	if got := next(); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Healthy: Watch sent %v, expected SERVING", got)
+	}
+
+	checker.Degrade("registered consumers would break")
+	if got := check(""); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Degraded: Check = %v, expected SERVING, as /ready answers 200", got)
+	}
+
+	prober.lost.Store(true)
+	for _, service := range []string{"", "calculator.v1.CalculatorService"} {
+		if got := check(service); got != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("Unhealthy: Check(%q) = %v, expected NOT_SERVING", service, got)
+		}
+	}
+	if got := next(); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Unhealthy: Watch sent %v, expected NOT_SERVING", got)
+	}
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown.Service"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Unknown service: expected %v, got %v", codes.NotFound, err)
+	}
+}
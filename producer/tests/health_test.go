@@ -0,0 +1,143 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/admin"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/health"
+)
+
+// fakeProber reports a fixed CVT connection state.
+type fakeProber struct {
+	err error
+}
+
+func (p fakeProber) Probe(ctx context.Context) error {
+	return p.err
+}
+
+// TestHealthReport_Statuses tests how startup events and the CVT connection
+// map to healthy, degraded and unhealthy, and when /ready refuses traffic.
+func TestHealthReport_Statuses(t *testing.T) {
+	unreachable := fakeProber{err: errors.New("connection refused")}
+
+	testCases := []struct {
+		name        string
+		setup       func(c *health.Checker)
+		status      health.Status
+		readyStatus int
+	}{
+		{
+			name:        "validation disabled on purpose",
+			setup:       func(c *health.Checker) {},
+			status:      health.StatusHealthy,
+			readyStatus: http.StatusOK,
+		},
+		{
+			name: "validation running",
+			setup: func(c *health.Checker) {
+				c.ExpectValidation("cvt:9550", fakeProber{})
+				c.SchemaRegistered()
+				c.EnableValidation("strict")
+			},
+			status:      health.StatusHealthy,
+			readyStatus: http.StatusOK,
+		},
+		{
+			name: "validation silently failed to start",
+			setup: func(c *health.Checker) {
+				c.ExpectValidation("cvt:9550", fakeProber{})
+				c.ValidationFailed(errors.New("schema registration failed"))
+			},
+			status:      health.StatusDegraded,
+			readyStatus: http.StatusOK,
+		},
+		{
+			name: "CVT lost in strict mode",
+			setup: func(c *health.Checker) {
+				c.ExpectValidation("cvt:9550", unreachable)
+				c.SchemaRegistered()
+				c.EnableValidation("strict")
+			},
+			status:      health.StatusUnhealthy,
+			readyStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "CVT lost in warn mode",
+			setup: func(c *health.Checker) {
+				c.ExpectValidation("cvt:9550", unreachable)
+				c.SchemaRegistered()
+				c.EnableValidation("warn")
+			},
+			status:      health.StatusDegraded,
+			readyStatus: http.StatusOK,
+		},
+		{
+			name: "can-i-deploy degraded",
+			setup: func(c *health.Checker) {
+				c.Degrade("registered consumers would break")
+			},
+			status:      health.StatusDegraded,
+			readyStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calc := handlers.NewCalculator()
+			tc.setup(calc.HealthChecker())
+
+			for path, wantCode := range map[string]int{"/health": http.StatusOK, "/ready": tc.readyStatus} {
+				mux := admin.NewMux(calc.OperationalRoutes()...)
+
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+				if rec.Code != wantCode {
+					t.Errorf("%s: expected status %d, got %d", path, wantCode, rec.Code)
+				}
+
+				var report handlers.HealthResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+					t.Fatalf("%s: failed to decode report: %v", path, err)
+				}
+				if report.Status != tc.status {
+					t.Errorf("%s: expected %s, got %s (reasons: %v)", path, tc.status, report.Status, report.Reasons)
+				}
+				if report.Status != health.StatusHealthy && len(report.Reasons) == 0 {
+					t.Errorf("%s: expected reasons for status %s", path, report.Status)
+				}
+			}
+		})
+	}
+}
+
+// TestHealthReport_Dependencies tests that the report shows the CVT
+// connection, the registered schema and the validation mode.
+func TestHealthReport_Dependencies(t *testing.T) {
+	checker := health.NewChecker()
+	checker.SetSchema("calculator-api", "1.0.0")
+	checker.ExpectValidation("cvt:9550", fakeProber{})
+	checker.SchemaRegistered()
+	checker.EnableValidation("warn")
+
+	report := checker.Check(context.Background())
+
+	expected := health.Report{
+		Status:     health.StatusHealthy,
+		Validation: health.ValidationReport{Enabled: true, Mode: "warn"},
+		Schema:     health.SchemaReport{ID: "calculator-api", Version: "1.0.0", Registered: true},
+		CVT:        health.CVTReport{Address: "cvt:9550", Connected: true},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, report)
+	}
+}
@@ -0,0 +1,385 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/idempotency"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+)
+
+// idempotentPost is a POST handler with a side effect: it counts the
+// requests that reach it and answers with the count, unless ?status= asks for
+// another status. Like the rate limiter inside the guard, it also reports
+// the quota left, which must not be recorded.
+type idempotentPost struct {
+	calls   atomic.Int64
+	release chan struct{} // if set, requests wait for it
+}
+
+func (h *idempotentPost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.calls.Add(1)
+	if h.release != nil {
+		<-h.release
+	}
+	status := http.StatusCreated
+	if r.URL.Query().Get("status") == "500" {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/1")
+	w.Header().Set(ratelimit.RemainingHeader, strconv.FormatInt(100-n, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]int64{"calls": n})
+}
+
+// guarded serves next behind guard, as RegisterRoutes does for non-GET
+// routes. Like the logging middleware, it sets a per-request header first,
+// which must not be recorded.
+func guarded(guard *idempotency.Guard, next http.Handler) http.Handler {
+	var requests atomic.Int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", strconv.FormatInt(requests.Add(1), 10))
+		guard.Serve(w, r, next)
+	})
+}
+
+// post sends a POST with an optional Idempotency-Key through handler.
+func post(handler http.Handler, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotency.Header, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// idempotencyStores returns each store implementation.
+func idempotencyStores(t *testing.T) map[string]idempotency.Store {
+	t.Helper()
+
+	fileStore, err := idempotency.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	return map[string]idempotency.Store{"memory": idempotency.NewMemoryStore(), "file": fileStore}
+}
+
+// TestIdempotency_Replay tests that a repeated key gets the first response
+// back without reaching the handler again, and that a reused key carrying a
+// different request gets a 422.
+func TestIdempotency_Replay(t *testing.T) {
+	for name, store := range idempotencyStores(t) {
+		t.Run(name, func(t *testing.T) {
+			h := &idempotentPost{}
+			handler := guarded(idempotency.New(store, time.Hour), h)
+
+			first := post(handler, "/jobs", "key-1", `{"x":1}`)
+			retry := post(handler, "/jobs", "key-1", `{"x":1}`)
+			if h.calls.Load() != 1 {
+				t.Fatalf("Expected the handler to run once, ran %d times", h.calls.Load())
+			}
+			if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+				t.Errorf("Expected the first response %d %q, got %d %q", first.Code, first.Body, retry.Code, retry.Body)
+			}
+			if retry.Header().Get("Location") != "/jobs/1" || retry.Header().Get(idempotency.ReplayedHeader) != "true" {
+				t.Errorf("Expected the recorded headers marked as replayed, got %v", retry.Header())
+			}
+			if got := retry.Header().Get(ratelimit.RemainingHeader); got != "" {
+				t.Errorf("Expected no recorded %s, got %q", ratelimit.RemainingHeader, got)
+			}
+			if retry.Header().Get("X-Request-ID") != "2" {
+				t.Errorf("Expected the retry's own X-Request-ID, got %q", retry.Header().Get("X-Request-ID"))
+			}
+			if first.Header().Get(idempotency.ReplayedHeader) != "" {
+				t.Error("Expected the first response not to be marked as replayed")
+			}
+
+			for _, tc := range []struct{ name, path, body string }{
+				{"different body", "/jobs", `{"x":2}`},
+				{"different query", "/jobs?priority=high", `{"x":1}`},
+			} {
+				rec := post(handler, tc.path, "key-1", tc.body)
+				if rec.Code != http.StatusUnprocessableEntity {
+					t.Errorf("%s: expected 422, got %d", tc.name, rec.Code)
+				}
+				var body handlers.ErrorResponse
+				if json.Unmarshal(rec.Body.Bytes(), &body); !strings.Contains(body.Error, idempotency.Header) {
+					t.Errorf("%s: expected an error naming %s, got %q", tc.name, idempotency.Header, rec.Body)
+				}
+			}
+
+			// Other keys, and requests without one, are handled
+			post(handler, "/jobs", "key-2", `{"x":2}`)
+			post(handler, "/jobs", "", `{"x":1}`)
+			post(handler, "/jobs", "", `{"x":1}`)
+			if h.calls.Load() != 4 {
+				t.Errorf("Expected 4 calls, got %d", h.calls.Load())
+			}
+		})
+	}
+}
+
+// TestIdempotency_Scope tests that keys are scoped to the client and path.
+func TestIdempotency_Scope(t *testing.T) {
+	h := &idempotentPost{}
+	handler := guarded(idempotency.New(idempotency.NewMemoryStore(), time.Hour), h)
+
+	for _, name := range []string{"alice", "bob"} {
+		req := httptest.NewRequest("POST", "/jobs", strings.NewReader("{}"))
+		req.Header.Set(idempotency.Header, "shared")
+		req = req.WithContext(auth.NewContext(req.Context(), auth.Principal{Name: name, Scheme: auth.APIKeyScheme}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Header().Get(idempotency.ReplayedHeader) != "" {
+			t.Errorf("%s: expected its own response, got a replay", name)
+		}
+	}
+	post(handler, "/batches", "shared", "{}")
+	if h.calls.Load() != 3 {
+		t.Errorf("Expected each client and path to reach the handler, got %d calls", h.calls.Load())
+	}
+}
+
+// TestIdempotency_Unrecorded tests that server errors are not recorded, so
+// the request may be retried for real, that malformed keys get a 400 and
+// oversized bodies a 413.
+func TestIdempotency_Unrecorded(t *testing.T) {
+	h := &idempotentPost{}
+	handler := guarded(idempotency.New(idempotency.NewMemoryStore(), time.Hour), h)
+
+	post(handler, "/jobs?status=500", "key", "{}")
+	post(handler, "/jobs?status=500", "key", "{}")
+	if h.calls.Load() != 2 {
+		t.Errorf("Expected a failed request to be retried, got %d calls", h.calls.Load())
+	}
+
+	rec := post(handler, "/jobs", strings.Repeat("k", idempotency.MaxKeyLength+1), "{}")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an overlong key to get a 400, got %d", rec.Code)
+	}
+
+	// Bodies are read into memory to fingerprint them, so their size is capped
+	rec = post(handler, "/jobs", "big", strings.Repeat("x", idempotency.MaxBodySize+1))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected an oversized body to get a 413, got %d", rec.Code)
+	}
+	if h.calls.Load() != 2 {
+		t.Errorf("Expected the rejected requests not to reach the handler, got %d calls", h.calls.Load())
+	}
+}
+
+// TestIdempotency_InFlight tests that a repeat of a request that is still
+// being handled gets a 409 instead of running concurrently.
+func TestIdempotency_InFlight(t *testing.T) {
+	h := &idempotentPost{release: make(chan struct{})}
+	handler := guarded(idempotency.New(idempotency.NewMemoryStore(), time.Hour), h)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(handler, "/jobs", "slow", "{}") }()
+	for h.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if rec := post(handler, "/jobs", "slow", "{}"); rec.Code != http.StatusConflict {
+		t.Errorf("Expected a concurrent repeat to get a 409, got %d", rec.Code)
+	}
+	close(h.release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Errorf("Expected the first request to complete, got %d", rec.Code)
+	}
+	if rec := post(handler, "/jobs", "slow", "{}"); rec.Header().Get(idempotency.ReplayedHeader) != "true" {
+		t.Error("Expected a later repeat to be replayed")
+	}
+}
+
+// TestIdempotency_Expiry tests that both stores forget records after their
+// TTL, and that the file store keeps them across instances.
+func TestIdempotency_Expiry(t *testing.T) {
+	now := time.Now()
+	record := idempotency.Record{Fingerprint: "f", Response: idempotency.Response{Status: 201}, Expires: now.Add(time.Minute)}
+
+	for name, store := range idempotencyStores(t) {
+		if err := store.Put("k", record); err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+		if got, ok, err := store.Get("k", now); !ok || err != nil || got.Response.Status != 201 {
+			t.Errorf("%s: expected the record, got %+v, %v, %v", name, got, ok, err)
+		}
+		if _, ok, _ := store.Get("k", now.Add(2*time.Minute)); ok {
+			t.Errorf("%s: expected the record to expire", name)
+		}
+		if _, ok, _ := store.Get("k", now); ok {
+			t.Errorf("%s: expected an expired record to be dropped", name)
+		}
+	}
+
+	dir := t.TempDir()
+	first, _ := idempotency.NewFileStore(dir)
+	first.Put("k", record)
+	second, _ := idempotency.NewFileStore(dir)
+	if _, ok, err := second.Get("k", now); !ok || err != nil {
+		t.Errorf("Expected the record to survive a restart, got %v, %v", ok, err)
+	}
+}
+
+// TestIdempotency_Routes tests that GET routes ignore Idempotency-Key: they
+// are safe to repeat, so each request is answered afresh.
+func TestIdempotency_Routes(t *testing.T) {
+	calc := handlers.NewCalculator()
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+
+	for _, path := range []string{"/add?x=1&y=2", "/add?x=2&y=2"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(idempotency.Header, "same")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get(idempotency.ReplayedHeader) != "" {
+			t.Errorf("%s: expected a fresh 200, got %d %v", path, rec.Code, rec.Header())
+		}
+	}
+}
+
+// postedModulo is moduloOperation served with POST, counting its computations.
+type postedModulo struct {
+	moduloOperation
+	computed *atomic.Int64
+}
+
+func (o postedModulo) Compute(operands []float64) float64 {
+	o.computed.Add(1)
+	return o.moduloOperation.Compute(operands)
+}
+
+func (o postedModulo) Spec() handlers.OperationSpec {
+	spec := o.moduloOperation.Spec()
+	spec.Method = http.MethodPost
+	return spec
+}
+
+// TestIdempotency_OperationRoutes tests end to end that RegisterRoutes puts
+// an operation served with another method than GET behind the guard: a
+// repeat is replayed without computing again, and reusing the key for other
+// operands gets a 422.
+func TestIdempotency_OperationRoutes(t *testing.T) {
+	op := postedModulo{computed: &atomic.Int64{}}
+	calc := handlers.NewCalculator()
+	if err := calc.Registry().Register(op); err != nil {
+		t.Fatalf("Failed to register operation: %v", err)
+	}
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+
+	first := post(mux, "/modulo?x=7&y=3", "retry-1", "")
+	if first.Code != http.StatusOK || first.Body.String() != `{"result":1}`+"\n" {
+		t.Fatalf("Expected a 200 with the result, got %d %s", first.Code, first.Body)
+	}
+	repeat := post(mux, "/modulo?x=7&y=3", "retry-1", "")
+	if repeat.Code != http.StatusOK || repeat.Header().Get(idempotency.ReplayedHeader) != "true" || repeat.Body.String() != first.Body.String() {
+		t.Errorf("Expected the first response replayed, got %d %v %s", repeat.Code, repeat.Header(), repeat.Body)
+	}
+	if n := op.computed.Load(); n != 1 {
+		t.Errorf("Expected the operation computed once, got %d", n)
+	}
+
+	if rec := post(mux, "/modulo?x=7&y=2", "retry-1", ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a reused key to get a 422, got %d", rec.Code)
+	}
+	if rec := post(mux, "/modulo?x=7&y=3", "", ""); rec.Code != http.StatusOK || op.computed.Load() != 2 {
+		t.Errorf("Expected a request without a key to compute again, got %d after %d computations", rec.Code, op.computed.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/modulo?x=7&y=3", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to get a 405, got %d", rec.Code)
+	}
+}
+
+// TestIdempotency_InSpec tests that operations served with another method
+// than GET document the Idempotency-Key parameter, the Idempotent-Replayed
+// header and the guard's own responses, against components both schema files
+// define, while GET operations do not.
+func TestIdempotency_InSpec(t *testing.T) {
+	registry, err := handlers.NewRegistry(postedModulo{computed: &atomic.Int64{}})
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	op, ok := registry.SpecPaths()["/modulo"]["post"]
+	if !ok {
+		t.Fatal("Expected a post operation at /modulo")
+	}
+
+	if !slices.ContainsFunc(op.Parameters, func(p contract.Parameter) bool {
+		return p.Name == idempotency.Header && p.In == "header" && !p.Required
+	}) {
+		t.Errorf("Expected an optional %s header parameter, got %+v", idempotency.Header, op.Parameters)
+	}
+	for _, status := range []string{"200", "400"} {
+		if op.Responses[status].Headers[idempotency.ReplayedHeader].Ref != "#/components/headers/"+idempotency.ReplayedComponent {
+			t.Errorf("%s: expected the %s header", status, idempotency.ReplayedHeader)
+		}
+	}
+
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+		if _, ok := spec.Components.Headers[idempotency.ReplayedComponent]; !ok {
+			t.Errorf("%s: missing header component %s", name, idempotency.ReplayedComponent)
+		}
+		for status, want := range map[string]string{"409": idempotency.ConflictResponse, "413": idempotency.TooLargeResponse, "422": idempotency.KeyReusedResponse} {
+			if op.Responses[status].Ref != "#/components/responses/"+want {
+				t.Errorf("%s: expected a %s reference, got %+v", status, want, op.Responses[status])
+			}
+			response, ok := spec.Components.Responses[want]
+			if !ok || response.Content["application/json"].Schema.Ref != "#/components/schemas/Error" {
+				t.Errorf("%s: expected response component %s with an Error body, got %+v", name, want, response)
+			}
+		}
+		for path, operations := range spec.Paths {
+			if get, ok := operations["get"]; ok && slices.ContainsFunc(get.Parameters, func(p contract.Parameter) bool { return p.Name == idempotency.Header }) {
+				t.Errorf("%s: GET %s should not document %s", name, path, idempotency.Header)
+			}
+		}
+	}
+}
+
+// TestIdempotency_ParseConfig tests IDEMPOTENCY_TTL and IDEMPOTENCY_DIR.
+func TestIdempotency_ParseConfig(t *testing.T) {
+	if config, err := idempotency.ParseConfig("", ""); err != nil || config.TTL != idempotency.DefaultTTL {
+		t.Errorf("Expected the default TTL, got %+v, %v", config, err)
+	}
+	if store, err := (idempotency.Config{}).Open(); err != nil {
+		t.Errorf("Expected an in-memory store, got %v", err)
+	} else if _, ok := store.(*idempotency.MemoryStore); !ok {
+		t.Errorf("Expected an in-memory store, got %T", store)
+	}
+
+	dir := t.TempDir() + "/records"
+	config, err := idempotency.ParseConfig("90m", dir)
+	if err != nil || config.TTL != 90*time.Minute {
+		t.Fatalf("Expected a 90m TTL, got %+v, %v", config, err)
+	}
+	if store, err := config.Open(); err != nil {
+		t.Errorf("Expected a file store in %s, got %v", dir, err)
+	} else if _, ok := store.(*idempotency.FileStore); !ok {
+		t.Errorf("Expected a file store, got %T", store)
+	}
+
+	for _, ttl := range []string{"1d", "0s", "-1h"} {
+		if _, err := idempotency.ParseConfig(ttl, ""); err == nil {
+			t.Errorf("Expected %q to be rejected", ttl)
+		}
+	}
+}
@@ -4,9 +4,11 @@ package tests
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"syscall"
 	"testing"
 
 	"github.com/sahina/cvt-demo/producer/handlers"
@@ -45,7 +47,7 @@ func TestIntegration_AllEndpoints(t *testing.T) {
 			// Build the full URL
 			url := fmt.Sprintf("%s%s?x=%v&y=%v", config.ProducerURL, tc.Path, tc.X, tc.Y)
 
-			resp, err := http.Get(url)
+			resp, err := config.HTTPClient.Get(url)
 			if err != nil {
 				t.Fatalf("HTTP request failed: %v", err)
 			}
@@ -87,12 +89,17 @@ func TestIntegration_AllEndpoints(t *testing.T) {
 	}
 }
 
-// TestIntegration_HealthEndpoint tests the health endpoint.
+// TestIntegration_HealthEndpoint tests the health endpoint on the admin
+// listener.
 func TestIntegration_HealthEndpoint(t *testing.T) {
 	config := GetTestConfig(t)
 
-	url := fmt.Sprintf("%s/health", config.ProducerURL)
+	url := fmt.Sprintf("%s/health", config.AdminURL)
 	resp, err := http.Get(url)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// docker compose keeps the admin listener on the container network
+		t.Skipf("Admin listener not reachable at %s; set PRODUCER_ADMIN_URL: %v", config.AdminURL, err)
+	}
 	if err != nil {
 		t.Fatalf("HTTP request failed: %v", err)
 	}
@@ -113,7 +120,16 @@ func TestIntegration_HealthEndpoint(t *testing.T) {
 	}
 
 	if health.Status != "healthy" {
-		t.Errorf("Expected status 'healthy', got '%s'", health.Status)
+		t.Errorf("Expected status 'healthy', got '%s' (reasons: %v)", health.Status, health.Reasons)
+	}
+	if !health.Validation.Enabled {
+		t.Error("Expected CVT validation to be enabled")
+	}
+	if !health.Schema.Registered {
+		t.Error("Expected the schema to be registered with CVT")
+	}
+	if !health.CVT.Connected {
+		t.Errorf("Expected a live CVT connection, got error %q", health.CVT.Error)
 	}
 }
 
@@ -144,7 +160,7 @@ func TestIntegration_WithCVTValidation(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Make HTTP request to producer
 			url := fmt.Sprintf("%s%s?x=%v&y=%v", config.ProducerURL, tc.path, tc.x, tc.y)
-			resp, err := http.Get(url)
+			resp, err := config.HTTPClient.Get(url)
 			if err != nil {
 				t.Fatalf("HTTP request failed: %v", err)
 			}
@@ -227,7 +243,7 @@ func TestIntegration_ErrorResponses(t *testing.T) {
 	for _, tc := range errorCases {
 		t.Run(tc.name, func(t *testing.T) {
 			url := fmt.Sprintf("%s%s", config.ProducerURL, tc.url)
-			resp, err := http.Get(url)
+			resp, err := config.HTTPClient.Get(url)
 			if err != nil {
 				t.Fatalf("HTTP request failed: %v", err)
 			}
@@ -292,7 +308,7 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 			for i := 0; i < concurrency; i++ {
 				go func() {
 					url := fmt.Sprintf("%s%s?x=%v&y=%v", config.ProducerURL, ep.path, ep.x, ep.y)
-					resp, err := http.Get(url)
+					resp, err := config.HTTPClient.Get(url)
 					if err != nil {
 						results <- err
 						return
@@ -339,12 +355,12 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 func TestIntegration_ContentType(t *testing.T) {
 	config := GetTestConfig(t)
 
-	endpoints := []string{"/add?x=1&y=1", "/subtract?x=1&y=1", "/multiply?x=1&y=1", "/divide?x=1&y=1", "/health"}
+	endpoints := []string{"/add?x=1&y=1", "/subtract?x=1&y=1", "/multiply?x=1&y=1", "/divide?x=1&y=1", "/version"}
 
 	for _, ep := range endpoints {
 		t.Run(ep, func(t *testing.T) {
 			url := fmt.Sprintf("%s%s", config.ProducerURL, ep)
-			resp, err := http.Get(url)
+			resp, err := config.HTTPClient.Get(url)
 			if err != nil {
 				t.Fatalf("HTTP request failed: %v", err)
 			}
@@ -0,0 +1,162 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/logging"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// newLoggingServer wires the calculator behind the request ID middleware and
+// returns it with the buffer its JSON logs are written to.
+func newLoggingServer(t *testing.T, validator producer.Validator) (http.Handler, *bytes.Buffer) {
+	t.Helper()
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, slog.LevelDebug, logging.FormatJSON)
+
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+
+	var handler http.Handler = mux
+	if validator != nil {
+		spec := loadSpec(t, "calculator-api.yaml")
+		handler = validateAfter(logging.Validator(validator, spec, producer.ModeWarn), mux)
+	}
+	return logging.Middleware(logger)(handler), &logs
+}
+
+// logLines decodes the JSON log lines in logs.
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Log line is not JSON: %q", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+// TestLogging_RequestID tests that a request ID is generated when absent,
+// propagated when present, and appears on the response, in the error body
+// and in every log line.
+func TestLogging_RequestID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		incoming string
+	}{
+		{"generated", ""},
+		{"propagated", "client-supplied-id-42"},
+		{"invalid replaced", "bad id\nwith newline"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, logs := newLoggingServer(t, nil)
+
+			req := httptest.NewRequest("GET", "/divide?x=1&y=0", nil)
+			if tc.incoming != "" {
+				req.Header.Set(logging.RequestIDHeader, tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(logging.RequestIDHeader)
+			switch {
+			case id == "":
+				t.Fatal("Expected an X-Request-ID response header")
+			case tc.name == "propagated" && id != tc.incoming:
+				t.Errorf("Expected the client's request ID %q, got %q", tc.incoming, id)
+			case tc.name == "invalid replaced" && id == tc.incoming:
+				t.Error("Expected an invalid request ID to be replaced")
+			}
+
+			var body handlers.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if body.RequestID != id {
+				t.Errorf("Expected requestId %q in the error body, got %q", id, body.RequestID)
+			}
+
+			lines := logLines(t, logs)
+			if len(lines) < 2 {
+				t.Fatalf("Expected a handler log and an access log, got %d lines", len(lines))
+			}
+			for _, line := range lines {
+				if line["request_id"] != id {
+					t.Errorf("Expected request_id %q in log line %v", id, line)
+				}
+			}
+		})
+	}
+}
+
+// TestLogging_ValidationFailureFields tests that a contract violation is
+// logged with structured operation, errors and schema version fields.
+func TestLogging_ValidationFailureFields(t *testing.T) {
+	handler, logs := newLoggingServer(t, scriptedValidator{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/subtract?x=1&y=2", nil))
+
+	var failure map[string]any
+	for _, line := range logLines(t, logs) {
+		if line["msg"] == "contract validation failed" {
+			failure = line
+		}
+	}
+	if failure == nil {
+		t.Fatalf("Expected a \"contract validation failed\" log line, got:\n%s", logs)
+	}
+
+	expected := map[string]any{
+		"level":          "WARN",
+		"operation":      "subtract",
+		"schema_version": schemaVersion(t),
+		"mode":           "warn",
+		"errors":         []any{"response does not match schema"},
+		"request_id":     rec.Header().Get(logging.RequestIDHeader),
+	}
+	for key, want := range expected {
+		if !reflect.DeepEqual(failure[key], want) {
+			t.Errorf("Expected %s=%v, got %v", key, want, failure[key])
+		}
+	}
+}
+
+// TestLogging_ParseConfig tests LOG_LEVEL and LOG_FORMAT parsing.
+func TestLogging_ParseConfig(t *testing.T) {
+	for input, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		got, err := logging.ParseLevel(input)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+
+	for input, want := range map[string]logging.Format{"": logging.FormatJSON, "json": logging.FormatJSON, "text": logging.FormatText} {
+		got, err := logging.ParseFormat(input)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := logging.ParseFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,146 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/metrics"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+)
+
+// scriptedValidator returns a fixed outcome per path without a CVT server.
+type scriptedValidator struct{}
+
+func (scriptedValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	switch interaction.Path {
+	case "/subtract":
+		return &producer.ValidationResult{Valid: false, Errors: []string{"response does not match schema"}}, nil
+	case "/multiply":
+		return nil, errors.New("cvt unavailable")
+	default:
+		return &producer.ValidationResult{Valid: true}, nil
+	}
+}
+
+// validateAfter calls validator once per request after next has served it,
+// the way the CVT middleware does, skipping the given paths.
+func validateAfter(validator producer.Validator, next http.Handler, skip ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		for _, path := range skip {
+			if r.URL.Path == path {
+				return
+			}
+		}
+		validator.Validate(r.Context(), "calculator-api", &producer.Interaction{Method: r.Method, Path: r.URL.Path})
+	})
+}
+
+// newMetricsServer wires the calculator, metrics and a scripted validator the
+// way the producer does, with /version standing in for a route CVT skips.
+func newMetricsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	spec := loadSpec(t, "calculator-api.yaml")
+	m := metrics.New(spec, "calculator-api")
+
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	mux.Handle(metrics.Path, m.Handler())
+
+	handler := validateAfter(m.Validator(scriptedValidator{}), mux, "/version", metrics.Path)
+	server := httptest.NewServer(m.Middleware(handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// scrape returns the metrics endpoint's body.
+func scrape(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + metrics.Path)
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 from %s, got %d", metrics.Path, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+// TestMetrics_RequestsByOperation tests that requests are counted per
+// operationId and status, never per raw path or query string.
+func TestMetrics_RequestsByOperation(t *testing.T) {
+	server := newMetricsServer(t)
+
+	for _, path := range []string{"/add?x=1&y=2", "/add?x=3&y=4", "/divide?x=1&y=0", "/version", "/nope"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	body := scrape(t, server)
+
+	for _, want := range []string{
+		`calculator_http_requests_total{code="200",method="GET",operation="add"} 2`,
+		`calculator_http_requests_total{code="400",method="GET",operation="divide"} 1`,
+		`calculator_http_requests_total{code="200",method="GET",operation="version"} 1`,
+		`calculator_http_requests_total{code="404",method="GET",operation="unmatched"} 1`,
+		`calculator_http_request_duration_seconds_count{code="200",operation="add"} 2`,
+		`calculator_schema_info{schema_id="calculator-api",version="` + schemaVersion(t) + `"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+
+	for _, unwanted := range []string{"x=1", `operation="/add"`, `operation="/nope"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("Metrics must not be labelled by raw path, found %q", unwanted)
+		}
+	}
+}
+
+// TestMetrics_ValidationOutcomes tests that each validation outcome and the
+// CVT call latency are recorded per operation.
+func TestMetrics_ValidationOutcomes(t *testing.T) {
+	server := newMetricsServer(t)
+
+	for _, path := range []string{"/add?x=1&y=2", "/subtract?x=1&y=2", "/multiply?x=1&y=2", "/version"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	body := scrape(t, server)
+
+	for _, want := range []string{
+		`calculator_cvt_validations_total{operation="add",outcome="valid"} 1`,
+		`calculator_cvt_validations_total{operation="subtract",outcome="invalid"} 1`,
+		`calculator_cvt_validations_total{operation="multiply",outcome="error"} 1`,
+		`calculator_cvt_validations_total{operation="version",outcome="skipped"} 1`,
+		`calculator_cvt_validation_duration_seconds_count{operation="add"} 1`,
+		`calculator_cvt_validation_duration_seconds_count{operation="multiply"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+}
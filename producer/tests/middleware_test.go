@@ -2,10 +2,17 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/compress"
+	"github.com/sahina/cvt-demo/producer/cors"
 	"github.com/sahina/cvt-demo/producer/handlers"
 	"github.com/sahina/cvt/sdks/go/cvt/producer"
 	"github.com/sahina/cvt/sdks/go/cvt/producer/adapters"
@@ -309,3 +316,124 @@ func TestMiddleware_ExcludePaths(t *testing.T) {
 		}
 	})
 }
+
+// countingValidator counts the interactions it validates before delegating.
+type countingValidator struct {
+	producer.Validator
+	calls atomic.Int32
+}
+
+func (v *countingValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	v.calls.Add(1)
+	return v.Validator.Validate(ctx, schemaID, interaction)
+}
+
+// TestMiddleware_CORS tests, over a real HTTP server in strict and warn mode
+// with response validation on, that preflights are answered before the CVT
+// middleware and never validated, and that actual cross-origin requests are
+// validated, pass and carry the CORS headers the contract declares. The
+// request carries an API key so it satisfies the operation's security.
+func TestMiddleware_CORS(t *testing.T) {
+	config := GetTestConfig(t)
+	validator := NewTestValidator(t, config)
+	defer validator.Close()
+
+	corsConfig, err := cors.ParseConfig(testOrigin, "", "", "")
+	if err != nil {
+		t.Fatalf("Invalid CORS config: %v", err)
+	}
+
+	for _, mode := range []producer.ValidationMode{producer.ModeStrict, producer.ModeWarn} {
+		t.Run(string(mode), func(t *testing.T) {
+			counter := &countingValidator{Validator: &ValidatorAdapter{Validator: validator}}
+			server := httptest.NewServer(newCORSServer(t, corsConfig, adapters.NetHTTPMiddleware(producer.Config{
+				SchemaID:         config.SchemaID,
+				Validator:        counter,
+				Mode:             mode,
+				ValidateRequest:  true,
+				ValidateResponse: true,
+			})))
+			defer server.Close()
+
+			preflight, _ := http.NewRequest("OPTIONS", server.URL+"/add?x=5&y=3", nil)
+			preflight.Header.Set("Origin", testOrigin)
+			preflight.Header.Set(cors.RequestMethodHeader, "GET")
+			preflight.Header.Set(cors.RequestHeadersHeader, "X-Request-ID")
+			resp, err := http.DefaultClient.Do(preflight)
+			if err != nil {
+				t.Fatalf("Preflight failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("Expected preflight status 204, got %d", resp.StatusCode)
+			}
+			if calls := counter.calls.Load(); calls != 0 {
+				t.Errorf("Expected the preflight not to be validated, got %d validations", calls)
+			}
+
+			req, _ := http.NewRequest("GET", server.URL+"/add?x=5&y=3", nil)
+			req.Header.Set("Origin", testOrigin)
+			req.Header.Set(auth.APIKeyHeader, "key-ci")
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status 200 (request and response validated), got %d: %s", resp.StatusCode, body)
+			}
+			if got := resp.Header.Get(cors.AllowOriginHeader); got != testOrigin {
+				t.Errorf("Expected %s %q, got %q", cors.AllowOriginHeader, testOrigin, got)
+			}
+			if counter.calls.Load() == 0 {
+				t.Error("Expected the actual request to be validated")
+			}
+		})
+	}
+}
+
+// TestMiddleware_Compression tests, over a real HTTP server in strict mode
+// with response validation on, that compressed responses still pass CVT
+// validation: CVT sees the uncompressed JSON, the client the gzip bytes.
+func TestMiddleware_Compression(t *testing.T) {
+	config := GetTestConfig(t)
+	validator := NewTestValidator(t, config)
+	defer validator.Close()
+
+	counter := &countingValidator{Validator: &ValidatorAdapter{Validator: validator}}
+	handler := newCompressionServer(t, compress.Config{Encodings: []string{compress.Gzip}}, adapters.NetHTTPMiddleware(producer.Config{
+		SchemaID:         config.SchemaID,
+		Validator:        counter,
+		Mode:             producer.ModeStrict,
+		ValidateRequest:  true,
+		ValidateResponse: true,
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// The default transport asks for gzip and decodes it transparently
+	resp, err := http.Get(server.URL + "/add?x=5&y=3")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 (response validated), got %d", resp.StatusCode)
+	}
+	if !resp.Uncompressed {
+		t.Error("Expected a gzip-encoded response")
+	}
+	var result handlers.ResultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Result != 8 {
+		t.Errorf("Expected result 8, got %v", result.Result)
+	}
+	if counter.calls.Load() == 0 {
+		t.Error("Expected the request to be validated")
+	}
+}
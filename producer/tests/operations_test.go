@@ -0,0 +1,162 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// moduloOperation is a custom operation used to show that a new operation
+// needs to be defined in one place only.
+type moduloOperation struct{}
+
+func (moduloOperation) Name() string { return "modulo" }
+func (moduloOperation) Arity() int   { return 2 }
+func (moduloOperation) Validate(operands []float64) error {
+	if operands[1] == 0 {
+		return errors.New("modulo by zero is not allowed")
+	}
+	return nil
+}
+func (moduloOperation) Compute(operands []float64) float64 {
+	return math.Mod(operands[0], operands[1])
+}
+func (moduloOperation) Spec() handlers.OperationSpec {
+	return handlers.OperationSpec{
+		Summary:      "Remainder of two numbers",
+		Operands:     []string{"Dividend", "Divisor (cannot be zero)"},
+		InvalidInput: "Invalid input or modulo by zero",
+	}
+}
+
+// TestOperationRegistry_SpecFragmentsMatchContract tests that the path items the
+// registry generates are exactly those in calculator-api.yaml.
+func TestOperationRegistry_SpecFragmentsMatchContract(t *testing.T) {
+	spec := loadSpec(t, "calculator-api.yaml")
+	generated := handlers.NewCalculator().Registry().SpecPaths()
+
+	for path, item := range generated {
+		if !reflect.DeepEqual(item, spec.Paths[path]) {
+			fragment, _ := yaml.Marshal(map[string]any{path: item})
+			t.Errorf("Spec entry for %s differs from the registry. Expected:\n%s", path, fragment)
+		}
+	}
+}
+
+// TestOperationRegistry_GeneratedHandlers tests the handlers generated for the
+// built-in operations without a CVT server.
+func TestOperationRegistry_GeneratedHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+
+	for _, tc := range CalculatorTestCases() {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := fmt.Sprintf("%s?x=%v&y=%v", tc.Path, tc.X, tc.Y)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tc.Method, path, nil))
+
+			if rec.Code != tc.ExpectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.ExpectedStatus, rec.Code, rec.Body.String())
+			}
+
+			if tc.ExpectError {
+				var errResp handlers.ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+					t.Fatalf("Failed to parse error response: %v", err)
+				}
+				if !strings.Contains(errResp.Error, tc.ErrorContains) {
+					t.Errorf("Expected error containing %q, got %q", tc.ErrorContains, errResp.Error)
+				}
+				return
+			}
+
+			var result handlers.ResultResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to parse result response: %v", err)
+			}
+			if result.Result != tc.ExpectedResult {
+				t.Errorf("Expected result %v, got %v", tc.ExpectedResult, result.Result)
+			}
+		})
+	}
+}
+
+// TestOperationRegistry_RequestErrors tests the generic errors every generated
+// handler returns before an operation is computed.
+func TestOperationRegistry_RequestErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+
+	testCases := []struct {
+		method         string
+		path           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{"GET", "/add", 400, "missing required parameters 'x' and 'y'"},
+		{"GET", "/multiply?x=5", 400, "missing required parameters 'x' and 'y'"},
+		{"GET", "/subtract?x=abc&y=1", 400, "parameter 'x' must be a valid number"},
+		{"GET", "/divide?x=1&y=abc", 400, "parameter 'y' must be a valid number"},
+		{"POST", "/add?x=1&y=2", 405, "method not allowed"},
+	}
+
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+		var errResp handlers.ErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &errResp)
+		if rec.Code != tc.expectedStatus || errResp.Error != tc.expectedError {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tc.method, tc.path, tc.expectedStatus, tc.expectedError, rec.Code, errResp.Error)
+		}
+	}
+}
+
+// TestOperationRegistry_CustomOperation tests that registering one Operation yields
+// its route, handler and spec fragment.
+func TestOperationRegistry_CustomOperation(t *testing.T) {
+	registry, err := handlers.NewRegistry(append(handlers.BuiltinOperations(), moduloOperation{})...)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	routes := registry.Routes()
+	last := routes[len(routes)-1]
+	if last.Method != "GET" || last.Path != "/modulo" {
+		t.Fatalf("Expected GET /modulo route, got %s %s", last.Method, last.Path)
+	}
+
+	rec := httptest.NewRecorder()
+	last.Handler(rec, httptest.NewRequest("GET", "/modulo?x=7&y=3", nil))
+	if rec.Body.String() != `{"result":1}`+"\n" {
+		t.Errorf("Unexpected modulo response: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	last.Handler(rec, httptest.NewRequest("GET", "/modulo?x=7&y=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for modulo by zero, got %d", rec.Code)
+	}
+
+	op := registry.SpecPaths()["/modulo"]["get"]
+	if op.OperationID != "modulo" || len(op.Parameters) != 2 || op.Responses["400"].Description != "Invalid input or modulo by zero" {
+		t.Errorf("Unexpected generated spec operation: %+v", op)
+	}
+}
+
+// TestOperationRegistry_RegistryRejectsInvalidOperations tests registration checks.
+func TestOperationRegistry_RegistryRejectsInvalidOperations(t *testing.T) {
+	if _, err := handlers.NewRegistry(moduloOperation{}, moduloOperation{}); err == nil {
+		t.Error("Expected an error for a duplicate operation name")
+	}
+}
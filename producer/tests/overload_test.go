@@ -0,0 +1,320 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/grpcapi"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/metrics"
+	"github.com/sahina/cvt-demo/producer/overload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// slowOperation is a long-running operation: Compute blocks until release
+// is closed.
+type slowOperation struct {
+	release chan struct{}
+}
+
+func (slowOperation) Name() string                      { return "slow" }
+func (slowOperation) Arity() int                        { return 2 }
+func (slowOperation) Validate(operands []float64) error { return nil }
+func (o slowOperation) Compute(operands []float64) float64 {
+	<-o.release
+	return operands[0] + operands[1]
+}
+func (slowOperation) Spec() handlers.OperationSpec {
+	return handlers.OperationSpec{
+		Summary:      "Add two numbers slowly",
+		Operands:     []string{"First number", "Second number"},
+		InvalidInput: "Invalid input",
+	}
+}
+
+// newOverloadServer serves /slow and the built-in operations behind a
+// concurrency limiter built from the given settings, recording to m.
+func newOverloadServer(t *testing.T, slow slowOperation, limit, operations string, m *metrics.Metrics) *httptest.Server {
+	t.Helper()
+
+	config, err := overload.ParseConfig(limit, operations, "")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	registry, err := handlers.NewRegistry(append(handlers.BuiltinOperations(), slow)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.EnableConcurrencyLimit(overload.New(config, m))
+
+	mux := http.NewServeMux()
+	for _, route := range registry.Routes() {
+		mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	}
+	mux.Handle("GET "+metrics.Path, m.Handler())
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// waitForRunning waits until limiter-tracked requests to /slow are running,
+// as reported by the in-flight gauge.
+func waitForRunning(t *testing.T, server *httptest.Server, n int) {
+	t.Helper()
+
+	want := `calculator_operation_requests_in_flight{operation="slow"} ` + strconv.Itoa(n)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(server.URL + metrics.Path)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if strings.Contains(string(body), want) {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d running /slow requests", n)
+}
+
+// TestOverload_Saturation tests that a saturated operation sheds its excess
+// requests with a documented 503 while the other operations keep serving.
+func TestOverload_Saturation(t *testing.T) {
+	slow := slowOperation{release: make(chan struct{})}
+	m := metrics.New(loadSpec(t, "calculator-api.yaml"), "calculator-api")
+	server := newOverloadServer(t, slow, "2:20ms", "", m)
+
+	// Fill both slots of /slow
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := http.Get(server.URL + "/slow?x=1&y=2"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	waitForRunning(t, server, 2)
+
+	resp, err := http.Get(server.URL + "/slow?x=1&y=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(overload.RetryAfterHeader) != "1" {
+		t.Fatalf("Expected a 503 with Retry-After 1, got %d %q", resp.StatusCode, resp.Header.Get(overload.RetryAfterHeader))
+	}
+	var value any
+	json.Unmarshal(body, &value)
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		if violations := loadSpec(t, name).ValidateComponent("Error", value); len(violations) > 0 {
+			t.Errorf("%s: 503 body %s violates Error: %v", name, body, violations)
+		}
+	}
+
+	// The other operations have slots of their own
+	for _, path := range []string{"/add?x=1&y=2", "/subtract?x=1&y=2", "/divide?x=1&y=2"} {
+		for range 5 {
+			resp, err := http.Get(server.URL + path)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: expected 200 while /slow is saturated, got %v %v", path, resp, err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	close(slow.release)
+	wg.Wait()
+	if resp, err := http.Get(server.URL + "/slow?x=1&y=2"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /slow to serve again once its requests finished, got %v %v", resp, err)
+	}
+
+	resp, _ = http.Get(server.URL + metrics.Path)
+	scrape, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`calculator_shed_requests_total{operation="slow",reason="limit"} 1`,
+		`calculator_operation_concurrency_limit{operation="slow"} 2`,
+	} {
+		if !strings.Contains(string(scrape), want) {
+			t.Errorf("Expected the scrape to contain %s", want)
+		}
+	}
+}
+
+// TestOverload_Queue tests that a request queues for a slot within its
+// timeout, and that unlimited operations are never queued.
+func TestOverload_Queue(t *testing.T) {
+	config, _ := overload.ParseConfig("off", "slow=1:5s", "")
+	limiter := overload.New(config, nil)
+
+	release, _, ok := limiter.Acquire(context.Background(), "slow")
+	if !ok {
+		t.Fatal("Expected the first request to get the slot")
+	}
+	admitted := make(chan bool)
+	go func() {
+		_, _, ok := limiter.Acquire(context.Background(), "slow")
+		admitted <- ok
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	release()
+	select {
+	case ok := <-admitted:
+		if !ok {
+			t.Error("Expected the queued request to be admitted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queued request to be admitted once the slot was released")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, ok := limiter.Acquire(ctx, "slow"); ok {
+		t.Error("Expected a cancelled request to give up its place in the queue")
+	}
+	for range 100 {
+		if _, _, ok := limiter.Acquire(context.Background(), "add"); !ok {
+			t.Fatal("Expected an unlimited operation to admit every request")
+		}
+	}
+}
+
+// TestOverload_Adaptive tests that requests slower than the latency target
+// lower the limit, so requests are shed before every configured slot is
+// taken, and that fast requests raise it again.
+func TestOverload_Adaptive(t *testing.T) {
+	config, _ := overload.ParseConfig("10:0s", "", "1ms")
+	limiter := overload.New(config, nil)
+
+	for range 10 {
+		release, _, _ := limiter.Acquire(context.Background(), "divide")
+		time.Sleep(2 * time.Millisecond)
+		release()
+	}
+	limit, _ := limiter.Limit("divide")
+	if limit >= 10 || limit < 1 {
+		t.Fatalf("Expected slow requests to lower the limit below 10, got %v", limit)
+	}
+
+	var releases []func()
+	for range int(limit) {
+		release, _, ok := limiter.Acquire(context.Background(), "divide")
+		if !ok {
+			t.Fatalf("Expected %d requests to be admitted", int(limit))
+		}
+		releases = append(releases, release)
+	}
+	if _, reason, ok := limiter.Acquire(context.Background(), "divide"); ok || reason != overload.ReasonAdaptive {
+		t.Errorf("Expected the next request to be shed by the adaptive limit, got %v %q", ok, reason)
+	}
+	for _, release := range releases {
+		release()
+	}
+
+	for range 200 {
+		release, _, _ := limiter.Acquire(context.Background(), "divide")
+		release()
+	}
+	if limit, _ := limiter.Limit("divide"); limit != 10 {
+		t.Errorf("Expected fast requests to restore the limit to 10, got %v", limit)
+	}
+}
+
+// TestOverload_GRPCAndStream tests that gRPC calls and /ws frames need a
+// slot of the operation's concurrency limit like REST requests, and are
+// shed with UNAVAILABLE and an error frame while every slot is taken.
+func TestOverload_GRPCAndStream(t *testing.T) {
+	config, _ := overload.ParseConfig("off", "add=1:0s", "")
+	limiter := overload.New(config, nil)
+	calc := handlers.NewCalculator()
+	calc.Registry().EnableConcurrencyLimit(limiter)
+
+	release, _, ok := limiter.Acquire(context.Background(), "add")
+	if !ok {
+		t.Fatal("Expected to take the only slot of add")
+	}
+
+	client := newRegistryGRPCClient(t, calc.Registry())
+	req := &calculatorpb.OperationRequest{X: proto.Float64(1), Y: proto.Float64(2)}
+	var header metadata.MD
+	_, err := client.Add(context.Background(), req, grpc.Header(&header))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected UNAVAILABLE, got %v", err)
+	}
+	if got := header.Get(grpcapi.RetryAfterKey); len(got) != 1 || got[0] != "1" {
+		t.Errorf("Expected retry-after 1, got %v", got)
+	}
+	if _, err := client.Subtract(context.Background(), req); err != nil {
+		t.Errorf("Expected other operations to keep serving, got %v", err)
+	}
+
+	conn := dialStream(t, serveStream(t, calc, handlers.DefaultStreamConfig()), nil)
+	frame := `{"type":"operation","id":"1","operation":"add","x":1,"y":2}`
+	if text := frameError(t, exchange(t, conn, frame)); text != overload.OverloadedMessage("add", 1) {
+		t.Errorf("Expected an overload error frame, got %q", text)
+	}
+
+	release()
+	if _, err := client.Add(context.Background(), req); err != nil {
+		t.Errorf("Expected add to serve once its slot is free, got %v", err)
+	}
+	if message := exchange(t, conn, frame); message["type"] != handlers.FrameResult {
+		t.Errorf("Expected a result frame once the slot is free, got %v", message)
+	}
+}
+
+// TestOverload_ParseConfig tests CONCURRENCY_LIMIT, CONCURRENCY_LIMIT_OPERATIONS
+// and SHED_LATENCY_TARGET parsing.
+func TestOverload_ParseConfig(t *testing.T) {
+	config, err := overload.ParseConfig("64", "divide=4:1s, add=off", "200ms")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if l := config.For("subtract"); l.Max != 64 || l.QueueTimeout != overload.DefaultQueueTimeout {
+		t.Errorf("Expected the default 64 with the default queue timeout for subtract, got %+v", l)
+	}
+	if l := config.For("divide"); l.Max != 4 || l.QueueTimeout != time.Second {
+		t.Errorf("Expected 4 with a 1s queue timeout for divide, got %+v", l)
+	}
+	if !config.For("add").Unlimited() || config.LatencyTarget != 200*time.Millisecond || !config.Enabled() {
+		t.Errorf("Expected add unlimited and a 200ms target, got %+v", config)
+	}
+
+	if config, err := overload.ParseConfig("", "", ""); err != nil || config.Enabled() {
+		t.Errorf("Expected empty settings to disable concurrency limits, got %+v, %v", config, err)
+	}
+
+	for _, tc := range []struct{ limit, operations, target string }{
+		{"0", "", ""},
+		{"many", "", ""},
+		{"4:soon", "", ""},
+		{"4:-1s", "", ""},
+		{"", "divide", ""},
+		{"", "=4", ""},
+		{"", "divide=x", ""},
+		{"", "", "0s"},
+		{"", "", "fast"},
+	} {
+		if _, err := overload.ParseConfig(tc.limit, tc.operations, tc.target); err == nil {
+			t.Errorf("Expected %+v to be rejected", tc)
+		}
+	}
+}
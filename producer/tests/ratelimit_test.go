@@ -0,0 +1,361 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/calculatorpb"
+	"github.com/sahina/cvt-demo/producer/grpcapi"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/ratelimit"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// newRateLimitedHandler serves the calculator routes behind a limiter built
+// from the given RATE_LIMIT and RATE_LIMIT_OPERATIONS values.
+func newRateLimitedHandler(t *testing.T, limit, operations string) http.Handler {
+	t.Helper()
+
+	config, err := ratelimit.ParseConfig(limit, operations)
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	calc := handlers.NewCalculator()
+	calc.Registry().EnableRateLimit(ratelimit.New(config))
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	return mux
+}
+
+// limitedGet serves a GET through handler from the given client address and
+// optional, unverified API key.
+func limitedGet(handler http.Handler, path, remoteAddr, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestRateLimit_ParseConfig tests RATE_LIMIT and RATE_LIMIT_OPERATIONS parsing.
+func TestRateLimit_ParseConfig(t *testing.T) {
+	config, err := ratelimit.ParseConfig("600/m", "divide=2/s:5, add=off")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if l := config.For("subtract"); l.Rate != 10 || l.Burst != 600 {
+		t.Errorf("Expected the default 10/s with burst 600 for subtract, got %+v", l)
+	}
+	if l := config.For("divide"); l.Rate != 2 || l.Burst != 5 {
+		t.Errorf("Expected 2/s with burst 5 for divide, got %+v", l)
+	}
+	if l := config.For("add"); !l.Unlimited() {
+		t.Errorf("Expected add to be unlimited, got %+v", l)
+	}
+	if !config.Enabled() {
+		t.Error("Expected rate limiting to be enabled")
+	}
+
+	if config, err := ratelimit.ParseConfig("", ""); err != nil || config.Enabled() {
+		t.Errorf("Expected empty settings to disable rate limiting, got %+v, %v", config, err)
+	}
+	if config, err := ratelimit.ParseConfig("", "divide=1/h"); err != nil || !config.Enabled() || !config.For("add").Unlimited() {
+		t.Errorf("Expected only divide to be limited, got %+v, %v", config, err)
+	}
+
+	for _, tc := range []struct{ limit, operations string }{
+		{"10", ""},
+		{"10/d", ""},
+		{"0/s", ""},
+		{"-1/s", ""},
+		{"10/s:0", ""},
+		{"10/s:x", ""},
+		{"", "divide"},
+		{"", "=1/s"},
+		{"", "divide=fast"},
+	} {
+		if _, err := ratelimit.ParseConfig(tc.limit, tc.operations); err == nil {
+			t.Errorf("Expected ParseConfig(%q, %q) to fail", tc.limit, tc.operations)
+		}
+	}
+}
+
+// TestRateLimit_Bucket tests that a bucket allows its burst, then refills at
+// its rate.
+func TestRateLimit_Bucket(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	b := ratelimit.NewBucket(ratelimit.Limit{Rate: 2, Burst: 3}, start)
+
+	for i := range 3 {
+		d := b.Take(start)
+		if !d.Allowed || d.Remaining != 2-i || d.Limit != 3 {
+			t.Fatalf("Take %d: expected allowed with %d remaining, got %+v", i, 2-i, d)
+		}
+	}
+
+	d := b.Take(start)
+	if d.Allowed {
+		t.Fatal("Expected an empty bucket to reject")
+	}
+	if d.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Expected RetryAfter 500ms at 2 tokens/s, got %v", d.RetryAfter)
+	}
+	if d.Reset != 1500*time.Millisecond {
+		t.Errorf("Expected Reset 1.5s to refill 3 tokens, got %v", d.Reset)
+	}
+
+	if d := b.Take(start.Add(500 * time.Millisecond)); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("Expected one token after 500ms, got %+v", d)
+	}
+	if d := b.Take(start.Add(time.Hour)); !d.Allowed || d.Remaining != 2 {
+		t.Errorf("Expected the bucket to refill to its burst only, got %+v", d)
+	}
+}
+
+// TestRateLimit_Responses tests the headers on allowed requests and the 429
+// once a client's bucket is empty.
+func TestRateLimit_Responses(t *testing.T) {
+	handler := newRateLimitedHandler(t, "2/m", "")
+
+	for i := range 2 {
+		rec := limitedGet(handler, "/add?x=1&y=2", "192.0.2.1:1234", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i, rec.Code)
+		}
+		if rec.Header().Get(ratelimit.LimitHeader) != "2" || rec.Header().Get(ratelimit.RemainingHeader) != strconv.Itoa(1-i) {
+			t.Errorf("Request %d: expected limit 2 and %d remaining, got %q and %q", i, 1-i,
+				rec.Header().Get(ratelimit.LimitHeader), rec.Header().Get(ratelimit.RemainingHeader))
+		}
+		if rec.Header().Get(ratelimit.ResetHeader) == "" {
+			t.Errorf("Request %d: expected a %s header", i, ratelimit.ResetHeader)
+		}
+	}
+
+	rec := limitedGet(handler, "/add?x=1&y=2", "192.0.2.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 for the same IP on another port, got %d", rec.Code)
+	}
+	if retryAfter := rec.Header().Get(ratelimit.RetryAfterHeader); retryAfter != "30" {
+		t.Errorf("Expected Retry-After 30 at 2 requests/minute, got %q", retryAfter)
+	}
+	if rec.Header().Get(ratelimit.RemainingHeader) != "0" {
+		t.Errorf("Expected 0 remaining, got %q", rec.Header().Get(ratelimit.RemainingHeader))
+	}
+	var body handlers.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("Expected an Error body, got %q", rec.Body.String())
+	}
+
+	// Other clients, other operations and paths outside the contract's
+	// operations are unaffected
+	for _, tc := range []struct {
+		name, path, remoteAddr, apiKey string
+	}{
+		{"other IP", "/add?x=1&y=2", "192.0.2.2:1234", ""},
+		{"other operation", "/subtract?x=1&y=2", "192.0.2.1:1234", ""},
+		{"version", "/version", "192.0.2.1:1234", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := limitedGet(handler, tc.path, tc.remoteAddr, tc.apiKey)
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", rec.Code)
+			}
+		})
+	}
+	if rec := limitedGet(handler, "/version", "192.0.2.1:1234", ""); rec.Header().Get(ratelimit.LimitHeader) != "" {
+		t.Error("Expected no rate limit headers outside the operations")
+	}
+
+	// API keys nothing has verified do not identify a client, so a fresh
+	// one per request does not escape the address's bucket
+	for _, apiKey := range []string{"key-1", "key-2"} {
+		if rec := limitedGet(handler, "/add?x=1&y=2", "192.0.2.1:1234", apiKey); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: expected an unverified API key to be limited by IP, got %d", apiKey, rec.Code)
+		}
+	}
+}
+
+// TestRateLimit_PerOperation tests that operation overrides replace the default.
+func TestRateLimit_PerOperation(t *testing.T) {
+	handler := newRateLimitedHandler(t, "1/m", "divide=3/m,add=off")
+
+	count := func(path string) int {
+		allowed := 0
+		for range 5 {
+			if limitedGet(handler, path, "192.0.2.1:1234", "").Code == http.StatusOK {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	for path, want := range map[string]int{"/subtract?x=1&y=2": 1, "/divide?x=1&y=2": 3, "/add?x=1&y=2": 5} {
+		if got := count(path); got != want {
+			t.Errorf("%s: expected %d of 5 requests allowed, got %d", path, want, got)
+		}
+	}
+	if rec := limitedGet(handler, "/add?x=1&y=2", "192.0.2.1:1234", ""); rec.Header().Get(ratelimit.LimitHeader) != "" {
+		t.Error("Expected no rate limit headers on an unlimited operation")
+	}
+}
+
+// TestRateLimit_GRPCAndStream tests that gRPC calls and /ws frames take
+// tokens from the caller's bucket like REST requests, and are rejected with
+// RESOURCE_EXHAUSTED and an error frame once it is empty.
+func TestRateLimit_GRPCAndStream(t *testing.T) {
+	config, _ := ratelimit.ParseConfig("1/m", "")
+	newCalculator := func() *handlers.Calculator {
+		calc := handlers.NewCalculator()
+		calc.Registry().EnableRateLimit(ratelimit.New(config))
+		return calc
+	}
+
+	client := newRegistryGRPCClient(t, newCalculator().Registry())
+	req := &calculatorpb.OperationRequest{X: proto.Float64(1), Y: proto.Float64(2)}
+	if _, err := client.Add(context.Background(), req); err != nil {
+		t.Fatalf("First call failed: %v", err)
+	}
+	var header metadata.MD
+	_, err := client.Add(context.Background(), req, grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected RESOURCE_EXHAUSTED, got %v", err)
+	}
+	if got := header.Get(grpcapi.RetryAfterKey); len(got) != 1 || got[0] == "" {
+		t.Errorf("Expected retry-after metadata, got %v", got)
+	}
+	if _, err := client.Subtract(context.Background(), req); err != nil {
+		t.Errorf("Expected other operations to keep their own bucket, got %v", err)
+	}
+
+	conn := dialStream(t, serveStream(t, newCalculator(), handlers.DefaultStreamConfig()), nil)
+	frame := `{"type":"operation","id":"%d","operation":"add","x":1,"y":2}`
+	if message := exchange(t, conn, fmt.Sprintf(frame, 1)); message["type"] != handlers.FrameResult {
+		t.Fatalf("Expected a result frame first, got %v", message)
+	}
+	if text := frameError(t, exchange(t, conn, fmt.Sprintf(frame, 2))); text != ratelimit.ExceededMessage("add", 60) {
+		t.Errorf("Expected a rate limit error frame, got %q", text)
+	}
+}
+
+// TestRateLimit_Concurrent tests that concurrent requests from one client
+// never get more than the burst; run with -race.
+func TestRateLimit_Concurrent(t *testing.T) {
+	config, _ := ratelimit.ParseConfig("50/h", "")
+	limiter := ratelimit.New(config)
+	now := time.Now()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				if limiter.Allow("add", "ip:192.0.2.1", now).Allowed {
+					allowed.Add(1)
+				}
+				// Other clients exercise bucket creation concurrently
+				limiter.Allow("add", fmt.Sprintf("ip:198.51.100.%d", g*20+i), now)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := allowed.Load(); n != 50 {
+		t.Errorf("Expected exactly the burst of 50 requests allowed, got %d", n)
+	}
+	if n := limiter.Buckets(); n != 321 {
+		t.Errorf("Expected 321 buckets, got %d", n)
+	}
+}
+
+// TestRateLimit_InSpec tests that every operation documents the 429 and the
+// rate limit headers of its responses.
+func TestRateLimit_InSpec(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		tooMany, ok := spec.Components.Responses[ratelimit.TooManyRequestsResponse]
+		if !ok {
+			t.Fatalf("%s: missing component response %s", name, ratelimit.TooManyRequestsResponse)
+		}
+		if ref := tooMany.Headers[ratelimit.RetryAfterHeader].RefName(); ref != ratelimit.RetryAfterComponent {
+			t.Errorf("%s: %s Retry-After refers to %q", name, ratelimit.TooManyRequestsResponse, ref)
+		}
+		if ref := tooMany.Content["application/json"].Schema.RefName(); ref != "Error" {
+			t.Errorf("%s: %s body refers to %q, want Error", name, ratelimit.TooManyRequestsResponse, ref)
+		}
+
+		for _, operation := range handlers.NewCalculator().Registry().Operations() {
+			path := "/" + operation.Name()
+			op := spec.Paths[path]["get"]
+			if ref := op.Responses["429"].RefName(); ref != ratelimit.TooManyRequestsResponse {
+				t.Errorf("%s: %s 429 refers to %q, want %s", name, path, ref, ratelimit.TooManyRequestsResponse)
+			}
+			for _, status := range []string{"200", "304", "400", "429", "500"} {
+				resolved := spec.ResolveResponse(op.Responses[status])
+				for header, want := range ratelimit.SpecHeaders() {
+					if ref := resolved.Headers[header].RefName(); ref != want.RefName() {
+						t.Errorf("%s: %s %s %s refers to %q, want %s", name, path, status, header, ref, want.RefName())
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestSchemaCompliance_TooManyRequests validates a 429 for every operation
+// against the schema.
+func TestSchemaCompliance_TooManyRequests(t *testing.T) {
+	config := GetTestConfig(t)
+	testKit := NewProducerTestKit(t, config)
+	defer testKit.Close()
+
+	handler := newRateLimitedHandler(t, "1/h", "")
+	ctx := context.Background()
+
+	for _, operation := range handlers.NewCalculator().Registry().Operations() {
+		t.Run(operation.Name(), func(t *testing.T) {
+			path := fmt.Sprintf("/%s?x=6&y=3", operation.Name())
+			limitedGet(handler, path, "192.0.2.1:1234", "")
+			rec := limitedGet(handler, path, "192.0.2.1:1234", "")
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected status 429, got %d", rec.Code)
+			}
+
+			result, err := testKit.ValidateResponse(ctx, producer.ValidateResponseParams{
+				Method: "GET",
+				Path:   path,
+				Response: producer.TestResponseData{
+					StatusCode: rec.Code,
+					Body:       parseBody(rec.Body.Bytes()),
+					Headers:    httpHeaderToMap(rec.Header()),
+				},
+			})
+			if err != nil {
+				t.Fatalf("Validation error: %v", err)
+			}
+			if !result.Valid {
+				t.Errorf("429 does not comply with schema: %v", result.Errors)
+			}
+		})
+	}
+}
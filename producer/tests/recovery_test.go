@@ -0,0 +1,143 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/logging"
+)
+
+// panickingHandler panics before writing anything.
+func panickingHandler(w http.ResponseWriter, r *http.Request) {
+	var m map[string]int
+	m["boom"]++ // assignment to entry in nil map
+}
+
+// TestRecovery_PanicReturnsErrorResponse tests that a panic becomes a 500
+// ErrorResponse tagged with the request ID, and that the log line carries
+// the panic, the stack and the same request ID.
+func TestRecovery_PanicReturnsErrorResponse(t *testing.T) {
+	var logs strings.Builder
+	logger := logging.New(&logs, slog.LevelInfo, logging.FormatJSON)
+	handler := logging.Middleware(logger)(handlers.Recover(http.HandlerFunc(panickingHandler)))
+
+	req := httptest.NewRequest("GET", "/add?x=1&y=2", nil)
+	req.Header.Set(logging.RequestIDHeader, "panic-request-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var body handlers.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if body.Error != handlers.InternalErrorMessage {
+		t.Errorf("Expected error %q, got %q", handlers.InternalErrorMessage, body.Error)
+	}
+	if body.RequestID != "panic-request-1" {
+		t.Errorf("Expected requestId panic-request-1, got %q", body.RequestID)
+	}
+	if strings.Contains(rec.Body.String(), "nil map") {
+		t.Errorf("Panic value leaked to the client: %s", rec.Body.String())
+	}
+
+	var entry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		json.Unmarshal([]byte(line), &entry)
+		if entry["msg"] == "handler panicked" {
+			break
+		}
+		entry = nil
+	}
+	if entry == nil {
+		t.Fatalf("Expected a 'handler panicked' log line, got:\n%s", logs.String())
+	}
+	if entry["request_id"] != "panic-request-1" {
+		t.Errorf("Expected request_id panic-request-1, got %v", entry["request_id"])
+	}
+	if !strings.Contains(entry["panic"].(string), "nil map") {
+		t.Errorf("Expected the panic value in the log, got %v", entry["panic"])
+	}
+	if !strings.Contains(entry["stack"].(string), "panickingHandler") {
+		t.Errorf("Expected the stack to name the panicking handler, got %v", entry["stack"])
+	}
+}
+
+// TestRecovery_ResponseAlreadyStarted tests that a panic after the handler
+// started its response aborts the connection, so the client cannot mistake
+// the partial response for a complete one.
+func TestRecovery_ResponseAlreadyStarted(t *testing.T) {
+	handler := handlers.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "64")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"result":`))
+		panic("late failure")
+	}))
+
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("Expected http.ErrAbortHandler, got %v", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/add?x=1&y=2", nil))
+	}()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	resp, err := http.Get(server.URL + "/add?x=1&y=2")
+	if err == nil {
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+	}
+	if err == nil {
+		t.Error("Expected the connection to be aborted")
+	}
+}
+
+// TestRecovery_AbortHandlerPropagates tests that http.ErrAbortHandler is
+// re-raised so net/http can abort the connection.
+func TestRecovery_AbortHandlerPropagates(t *testing.T) {
+	handler := handlers.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to propagate, got %v", v)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/add", nil))
+}
+
+// TestRecovery_EveryOperationDocuments500 tests that the contract documents
+// the recovered 500 ErrorResponse for every operation.
+func TestRecovery_EveryOperationDocuments500(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		for _, e := range loadSpec(t, name).Endpoints() {
+			response, ok := e.Operation.Responses["500"]
+			if !ok {
+				t.Errorf("%s: %s %s has no 500 response", name, e.Method, e.Path)
+				continue
+			}
+			if ref := response.Content["application/json"].Schema.RefName(); ref != "Error" {
+				t.Errorf("%s: %s %s 500 response uses schema %q, want Error", name, e.Method, e.Path, ref)
+			}
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/sahina/cvt/sdks/go/cvt"
 )
 
-// TestRegistry_CanIDeploy_CurrentSchema tests that the current v1.0.0 schema
+// TestRegistry_CanIDeploy_CurrentSchema tests that the current schema
 // is safe to deploy when consumers are registered.
 func TestRegistry_CanIDeploy_CurrentSchema(t *testing.T) {
 	config := GetTestConfig(t)
@@ -28,13 +28,14 @@ func TestRegistry_CanIDeploy_CurrentSchema(t *testing.T) {
 	}
 
 	// Check if the current schema version is safe to deploy
-	result, err := validator.CanIDeploy(ctx, config.SchemaID, "1.0.0", config.Environment)
+	version := schemaVersion(t)
+	result, err := validator.CanIDeploy(ctx, config.SchemaID, version, config.Environment)
 	if err != nil {
 		t.Logf("CanIDeploy check returned error (may be expected if no consumers registered): %v", err)
 		return
 	}
 
-	t.Logf("CanIDeploy result for v1.0.0:")
+	t.Logf("CanIDeploy result for v%s:", version)
 	t.Logf("  Safe to deploy: %v", result.SafeToDeploy)
 	t.Logf("  Summary: %s", result.Summary)
 
@@ -47,7 +48,7 @@ func TestRegistry_CanIDeploy_CurrentSchema(t *testing.T) {
 
 	// Current schema should be safe to deploy
 	if !result.SafeToDeploy {
-		t.Errorf("Expected v1.0.0 to be safe to deploy, but got: %s", result.Summary)
+		t.Errorf("Expected v%s to be safe to deploy, but got: %s", version, result.Summary)
 	}
 }
 
@@ -147,15 +148,16 @@ func TestRegistry_DeploymentFlow(t *testing.T) {
 	ctx := context.Background()
 
 	// Step 1: Register current schema
-	t.Log("Step 1: Registering current schema (v1.0.0)...")
+	version := schemaVersion(t)
+	t.Logf("Step 1: Registering current schema (v%s)...", version)
 	if err := validator.RegisterSchema(ctx, config.SchemaID, config.SchemaPath); err != nil {
 		t.Fatalf("Failed to register current schema: %v", err)
 	}
 	t.Log("  Schema registered successfully")
 
 	// Step 2: Check can-i-deploy for current version
-	t.Log("Step 2: Checking if v1.0.0 can be deployed...")
-	result, err := validator.CanIDeploy(ctx, config.SchemaID, "1.0.0", config.Environment)
+	t.Logf("Step 2: Checking if v%s can be deployed...", version)
+	result, err := validator.CanIDeploy(ctx, config.SchemaID, version, config.Environment)
 	if err != nil {
 		t.Logf("  CanIDeploy returned error: %v", err)
 	} else {
@@ -0,0 +1,171 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// newRoutingServer wires the calculator and contract routes the way the
+// producer does, with next standing in for the CVT middleware.
+func newRoutingServer(t *testing.T, next func(http.Handler) http.Handler) http.Handler {
+	t.Helper()
+
+	mux, _ := newContractServer(t)
+	handlers.NewCalculator().RegisterRoutes(mux)
+	return handlers.Unmatched(mux)(next(mux))
+}
+
+// TestRouting_UnmatchedRequests tests the routing policy: unknown paths get
+// a JSON 404 and known paths with the wrong method a JSON 405 with Allow.
+func TestRouting_UnmatchedRequests(t *testing.T) {
+	handler := newRoutingServer(t, func(next http.Handler) http.Handler { return next })
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedError  string
+		expectedAllow  string
+	}{
+		{"unknown path", "GET", "/nope", 404, handlers.NotFoundMessage, ""},
+		{"root", "GET", "/", 404, handlers.NotFoundMessage, ""},
+		{"case differs", "GET", "/ADD?x=1&y=2", 404, handlers.NotFoundMessage, ""},
+		{"trailing slash", "GET", "/add/?x=1&y=2", 404, handlers.NotFoundMessage, ""},
+		{"unclean path", "GET", "/x/../add?x=1&y=2", 404, handlers.NotFoundMessage, ""},
+		{"wrong method on operation", "POST", "/add?x=1&y=2", 405, handlers.MethodNotAllowedMessage, "GET, HEAD"},
+		{"wrong method on version", "DELETE", "/version", 405, handlers.MethodNotAllowedMessage, "GET, HEAD"},
+		{"wrong method on contract", "PUT", contract.JSONPath, 405, handlers.MethodNotAllowedMessage, "GET, HEAD"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %q", ct)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tc.expectedAllow {
+				t.Errorf("Expected Allow %q, got %q", tc.expectedAllow, allow)
+			}
+
+			var errResp handlers.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Expected a JSON error body, got %q", rec.Body.String())
+			}
+			if errResp.Error != tc.expectedError {
+				t.Errorf("Expected error %q, got %q", tc.expectedError, errResp.Error)
+			}
+		})
+	}
+}
+
+// TestRouting_UnmatchedBypassesValidation tests that only requests a route
+// matches reach the middleware standing in for CVT.
+func TestRouting_UnmatchedBypassesValidation(t *testing.T) {
+	var validated []string
+	handler := newRoutingServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			validated = append(validated, r.Method+" "+r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/add?x=1&y=2"},
+		{"POST", "/add?x=1&y=2"},
+		{"GET", "/nope"},
+		{"GET", "/add/"},
+		{"HEAD", "/version"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	expected := []string{"GET /add", "HEAD /version"}
+	if len(validated) != len(expected) || validated[0] != expected[0] || validated[1] != expected[1] {
+		t.Errorf("Expected only %v to be validated, got %v", expected, validated)
+	}
+}
+
+// TestRouting_ResponsesInSpec tests that every operation documents the 405
+// and that the 404 and 405 component responses describe the JSON body and
+// the Allow header.
+func TestRouting_ResponsesInSpec(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		for _, e := range spec.Endpoints() {
+			if ref := e.Operation.Responses["405"].RefName(); ref != handlers.MethodNotAllowedResponse {
+				t.Errorf("%s: %s %s 405 refers to %q, want %s", name, e.Method, e.Path, ref, handlers.MethodNotAllowedResponse)
+			}
+		}
+
+		for _, component := range []string{handlers.NotFoundResponse, handlers.MethodNotAllowedResponse} {
+			response, ok := spec.Components.Responses[component]
+			if !ok {
+				t.Errorf("%s: missing component response %s", name, component)
+				continue
+			}
+			if ref := response.Content["application/json"].Schema.RefName(); ref != "Error" {
+				t.Errorf("%s: %s uses schema %q, want Error", name, component, ref)
+			}
+		}
+		if _, ok := spec.Components.Responses[handlers.MethodNotAllowedResponse].Headers["Allow"]; !ok {
+			t.Errorf("%s: %s does not document the Allow header", name, handlers.MethodNotAllowedResponse)
+		}
+	}
+}
+
+// TestRouting_ResponsesMatchSpec tests the 404 and 405 bodies against the
+// spec's NotFound and MethodNotAllowed responses. Unmatched answers them
+// before the CVT middleware, which cannot validate requests for paths and
+// methods the contract does not describe, so this test is what keeps them
+// compliant.
+func TestRouting_ResponsesMatchSpec(t *testing.T) {
+	handler := newRoutingServer(t, func(next http.Handler) http.Handler { return next })
+
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		for _, tc := range []struct {
+			method, path, component string
+		}{
+			{"GET", "/nope", handlers.NotFoundResponse},
+			{"POST", "/add?x=1&y=2", handlers.MethodNotAllowedResponse},
+			{"DELETE", "/version", handlers.MethodNotAllowedResponse},
+		} {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			response := spec.Components.Responses[tc.component]
+			media, ok := response.Content[rec.Header().Get("Content-Type")]
+			if !ok {
+				t.Errorf("%s: %s %s: Content-Type %q is not documented by %s",
+					name, tc.method, tc.path, rec.Header().Get("Content-Type"), tc.component)
+				continue
+			}
+			var body any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%s %s: body is not JSON: %q", tc.method, tc.path, rec.Body.String())
+			}
+			if violations := spec.ValidateComponent(media.Schema.RefName(), body); len(violations) > 0 {
+				t.Errorf("%s: %s %s: body violates %s: %v", name, tc.method, tc.path, tc.component, violations)
+			}
+			for header := range response.Headers {
+				if header == "Allow" && rec.Header().Get(header) == "" {
+					t.Errorf("%s: %s %s: missing the Allow header %s documents", name, tc.method, tc.path, tc.component)
+				}
+			}
+		}
+	}
+}
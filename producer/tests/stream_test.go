@@ -0,0 +1,342 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/sahina/cvt-demo/producer/auth"
+	"github.com/sahina/cvt-demo/producer/handlers"
+)
+
+// newStreamServer serves the Calculator's routes with the given stream
+// settings, validating frames against the YAML contract.
+func newStreamServer(t *testing.T, config handlers.StreamConfig, authenticators ...auth.Authenticator) *httptest.Server {
+	t.Helper()
+	return serveStream(t, handlers.NewCalculator(), config, authenticators...)
+}
+
+// serveStream is newStreamServer for calc.
+func serveStream(t *testing.T, calc *handlers.Calculator, config handlers.StreamConfig, authenticators ...auth.Authenticator) *httptest.Server {
+	t.Helper()
+
+	spec := loadSpec(t, "calculator-api.yaml")
+	config.Spec = spec
+	calc.SetStreamConfig(config)
+
+	mux := http.NewServeMux()
+	calc.RegisterRoutes(mux)
+	var handler http.Handler = mux
+	if len(authenticators) > 0 {
+		handler = auth.Middleware(spec, authenticators...)(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialStream opens a session on server with the given request headers.
+func dialStream(t *testing.T, server *httptest.Server, header http.Header) *websocket.Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http")+handlers.StreamPath,
+		&websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// exchange sends frame and returns the next frame the producer sends,
+// skipping heartbeats, checked against the StreamMessage schema.
+func exchange(t *testing.T, conn *websocket.Conn, frame string) map[string]any {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Write(ctx, websocket.MessageText, []byte(frame)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	for {
+		message := readFrame(t, ctx, conn)
+		if message["type"] != handlers.FrameHeartbeat {
+			return message
+		}
+	}
+}
+
+// readFrame reads one frame and checks it against the StreamMessage schema.
+func readFrame(t *testing.T, ctx context.Context, conn *websocket.Conn) map[string]any {
+	t.Helper()
+
+	typ, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if typ != websocket.MessageText {
+		t.Fatalf("Expected a text message, got %v", typ)
+	}
+	var message map[string]any
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("Frame is not JSON: %s", data)
+	}
+	if violations := loadSpec(t, "calculator-api.yaml").ValidateComponent(handlers.StreamMessageSchema, message); len(violations) > 0 {
+		t.Errorf("Frame %s violates %s: %v", data, handlers.StreamMessageSchema, violations)
+	}
+	return message
+}
+
+// frameError returns the error message of an error frame.
+func frameError(t *testing.T, message map[string]any) string {
+	t.Helper()
+
+	if message["type"] != handlers.FrameError {
+		t.Fatalf("Expected an error frame, got %v", message)
+	}
+	body, _ := message["body"].(map[string]any)
+	text, _ := body["error"].(string)
+	return text
+}
+
+// TestStream_Operations tests that operation frames get result frames with
+// the result the REST operation computes, echoing their id.
+func TestStream_Operations(t *testing.T) {
+	conn := dialStream(t, newStreamServer(t, handlers.DefaultStreamConfig()), nil)
+
+	for _, tc := range CalculatorTestCases() {
+		t.Run(tc.Name, func(t *testing.T) {
+			operation := strings.TrimPrefix(tc.Path, "/")
+			frame, _ := json.Marshal(map[string]any{
+				"type": handlers.FrameOperation, "id": tc.Name, "operation": operation, "x": tc.X, "y": tc.Y,
+			})
+			message := exchange(t, conn, string(frame))
+
+			if tc.ExpectError {
+				if text := frameError(t, message); !strings.Contains(text, tc.ErrorContains) {
+					t.Errorf("Expected error containing %q, got %q", tc.ErrorContains, text)
+				}
+				return
+			}
+			if message["type"] != handlers.FrameResult || message["id"] != tc.Name || message["operation"] != operation {
+				t.Fatalf("Expected a result frame for %s, got %v", tc.Name, message)
+			}
+			body, _ := message["body"].(map[string]any)
+			if body["result"] != tc.ExpectedResult {
+				t.Errorf("Expected result %v, got %v", tc.ExpectedResult, body["result"])
+			}
+		})
+	}
+}
+
+// TestStream_ErrorFrames tests that frames that cannot be answered get an
+// error frame, and that the session goes on afterwards.
+func TestStream_ErrorFrames(t *testing.T) {
+	conn := dialStream(t, newStreamServer(t, handlers.DefaultStreamConfig()), nil)
+
+	testCases := []struct {
+		name    string
+		frame   string
+		message string
+	}{
+		{"not JSON", `{"type":`, "frame is not valid JSON"},
+		{"unknown type", `{"type":"subscribe","id":"1"}`, "does not match StreamRequest"},
+		{"operand not a number", `{"type":"operation","id":"2","operation":"add","x":"5","y":3}`, "does not match StreamRequest"},
+		{"missing operation", `{"type":"operation","id":"3","x":5,"y":3}`, "does not match StreamRequest"},
+		{"unknown operation", `{"type":"operation","id":"4","operation":"pow","x":2,"y":3}`, `unknown operation "pow"`},
+		{"missing operand", `{"type":"operation","id":"5","operation":"add","x":5}`, handlers.MissingOperandsMessage(2)},
+		{"division by zero", `{"type":"operation","id":"6","operation":"divide","x":5,"y":0}`, handlers.ErrDivisionByZero.Error()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := exchange(t, conn, tc.frame)
+			if text := frameError(t, message); !strings.Contains(text, tc.message) {
+				t.Errorf("Expected error containing %q, got %q", tc.message, text)
+			}
+		})
+	}
+
+	if message := exchange(t, conn, `{"type":"ping","id":"still there"}`); message["type"] != handlers.FramePong || message["id"] != "still there" {
+		t.Errorf("Expected a pong after the errors, got %v", message)
+	}
+}
+
+// TestStream_Handshake tests the HTTP responses to requests for /ws that do
+// not open a session.
+func TestStream_Handshake(t *testing.T) {
+	config := handlers.DefaultStreamConfig()
+	config.Origins = []string{"https://app.example.com"}
+	server := newStreamServer(t, config)
+
+	resp, err := http.Get(server.URL + handlers.StreamPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("Expected a 426 with Upgrade: websocket, got %d %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + handlers.StreamPath
+	_, resp, err = websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{"Origin": {"https://evil.example.com"}}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a disallowed origin to get a 403, got %v", err)
+	}
+
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{"Origin": {"https://app.example.com"}}})
+	if err != nil {
+		t.Fatalf("Expected an allowed origin to connect: %v", err)
+	}
+	conn.CloseNow()
+}
+
+// TestStream_Heartbeat tests that an idle session gets heartbeat frames.
+func TestStream_Heartbeat(t *testing.T) {
+	config := handlers.DefaultStreamConfig()
+	config.HeartbeatInterval = 20 * time.Millisecond
+	conn := dialStream(t, newStreamServer(t, config), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for range 2 {
+		message := readFrame(t, ctx, conn)
+		if message["type"] != handlers.FrameHeartbeat {
+			t.Fatalf("Expected a heartbeat frame, got %v", message)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, message["time"].(string)); err != nil {
+			t.Errorf("Expected an RFC 3339 time, got %v", message["time"])
+		}
+	}
+}
+
+// TestStream_Backpressure tests that a client that sends without reading is
+// slowed down, and disconnected once results cannot be written in time.
+func TestStream_Backpressure(t *testing.T) {
+	config := handlers.DefaultStreamConfig()
+	config.MaxPending = 1
+	config.WriteTimeout = 200 * time.Millisecond
+	conn := dialStream(t, newStreamServer(t, config), nil)
+
+	// Long ids make long results, which fill the socket buffers quickly
+	frame := `{"type":"operation","id":"` + strings.Repeat("x", 2000) + `","operation":"add","x":1,"y":2}`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(frame)); err != nil {
+			if ctx.Err() != nil {
+				t.Fatal("Expected the producer to disconnect a client that does not read")
+			}
+			return
+		}
+	}
+}
+
+// TestStream_MessageSize tests that frames over MaxMessageSize close the
+// session.
+func TestStream_MessageSize(t *testing.T) {
+	conn := dialStream(t, newStreamServer(t, handlers.DefaultStreamConfig()), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	frame := `{"type":"ping","id":"` + strings.Repeat("x", 8192) + `"}`
+	conn.Write(ctx, websocket.MessageText, []byte(frame))
+
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusMessageTooBig {
+		t.Errorf("Expected close status %v, got %v (%v)", websocket.StatusMessageTooBig, status, err)
+	}
+}
+
+// TestStream_Auth tests that the handshake is authenticated like an
+// operation, and each operation frame checked against the caller's
+// operations.
+func TestStream_Auth(t *testing.T) {
+	keys, err := auth.ParseAPIKeys([]byte("ci key-ci\nwidget key-widget stream add\ndashboard key-dash add subtract\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newStreamServer(t, handlers.DefaultStreamConfig(), keys)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + handlers.StreamPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for key, expectedStatus := range map[string]int{"": http.StatusUnauthorized, "key-dash": http.StatusForbidden} {
+		_, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{auth.APIKeyHeader: {key}}})
+		if err == nil || resp == nil || resp.StatusCode != expectedStatus {
+			t.Errorf("Key %q: expected status %d, got %v", key, expectedStatus, err)
+		}
+	}
+
+	conn := dialStream(t, server, http.Header{auth.APIKeyHeader: {"key-widget"}})
+	if message := exchange(t, conn, `{"type":"operation","operation":"add","x":5,"y":3}`); message["type"] != handlers.FrameResult {
+		t.Errorf("Expected add to be allowed, got %v", message)
+	}
+	message := exchange(t, conn, `{"type":"operation","operation":"divide","x":6,"y":3}`)
+	if text := frameError(t, message); text != "widget may not call divide" {
+		t.Errorf("Expected divide to be forbidden, got %q", text)
+	}
+}
+
+// TestStream_ParseConfig tests the WS_HEARTBEAT_INTERVAL and WS_MAX_PENDING
+// settings.
+func TestStream_ParseConfig(t *testing.T) {
+	config, err := handlers.ParseStreamConfig("", "")
+	if err != nil || config.HeartbeatInterval != 30*time.Second || config.MaxPending != 16 {
+		t.Errorf("Expected the defaults, got %+v, %v", config, err)
+	}
+	config, err = handlers.ParseStreamConfig("5s", "4")
+	if err != nil || config.HeartbeatInterval != 5*time.Second || config.MaxPending != 4 {
+		t.Errorf("Expected 5s and 4, got %+v, %v", config, err)
+	}
+	for _, values := range [][2]string{{"often", ""}, {"0s", ""}, {"", "0"}, {"", "many"}} {
+		if _, err := handlers.ParseStreamConfig(values[0], values[1]); err == nil {
+			t.Errorf("Expected %q, %q to be rejected", values[0], values[1])
+		}
+	}
+}
+
+// TestContract_Validate tests the JSON Schema validation frames go through.
+func TestContract_Validate(t *testing.T) {
+	for _, name := range []string{"calculator-api.yaml", "calculator-api.json"} {
+		spec := loadSpec(t, name)
+
+		testCases := []struct {
+			schema string
+			value  string
+			valid  bool
+		}{
+			{"Result", `{"result":8}`, true},
+			{"Result", `{"result":"8"}`, false},
+			{"Result", `{}`, false},
+			{"Error", `{"error":"division by zero is not allowed","requestId":"abc"}`, true},
+			{handlers.StreamRequestSchema, `{"type":"operation","operation":"add","x":5,"y":3}`, true},
+			{handlers.StreamRequestSchema, `{"type":"ping"}`, true},
+			{handlers.StreamRequestSchema, `{"type":"pong"}`, false},
+			{handlers.StreamRequestSchema, `[]`, false},
+			{handlers.StreamMessageSchema, `{"type":"result","operation":"add","body":{"result":8}}`, true},
+			{handlers.StreamMessageSchema, `{"type":"result","operation":"add","body":{"error":"x"}}`, false},
+			{handlers.StreamMessageSchema, `{"type":"error","body":{"error":"x"}}`, true},
+			{handlers.StreamMessageSchema, `{"type":"heartbeat"}`, false},
+		}
+
+		for _, tc := range testCases {
+			var value any
+			json.Unmarshal([]byte(tc.value), &value)
+			violations := spec.ValidateComponent(tc.schema, value)
+			if valid := len(violations) == 0; valid != tc.valid {
+				t.Errorf("%s: %s against %s: expected valid=%v, got %v", name, tc.value, tc.schema, tc.valid, violations)
+			}
+		}
+	}
+}
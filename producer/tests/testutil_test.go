@@ -4,10 +4,13 @@ package tests
 import (
 	"context"
 	"encoding/json"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/sahina/cvt-demo/producer/tlsconfig"
 	"github.com/sahina/cvt/sdks/go/cvt"
 	"github.com/sahina/cvt/sdks/go/cvt/producer"
 )
@@ -16,9 +19,12 @@ import (
 type TestConfig struct {
 	CVTServerAddr string
 	ProducerURL   string
+	AdminURL      string
 	SchemaPath    string
 	SchemaID      string
 	Environment   string
+	// HTTPClient talks to ProducerURL, over TLS when it is https
+	HTTPClient *http.Client
 }
 
 // GetTestConfig returns test configuration from environment variables.
@@ -35,6 +41,12 @@ func GetTestConfig(t *testing.T) *TestConfig {
 		producerURL = "http://localhost:10001"
 	}
 
+	// The admin listener serves plain HTTP whether or not the API uses TLS
+	adminURL := os.Getenv("PRODUCER_ADMIN_URL")
+	if adminURL == "" {
+		adminURL = "http://127.0.0.1:10003"
+	}
+
 	// Get the schema path relative to the test directory
 	schemaPath := os.Getenv("SCHEMA_PATH")
 	if schemaPath == "" {
@@ -50,10 +62,40 @@ func GetTestConfig(t *testing.T) *TestConfig {
 	return &TestConfig{
 		CVTServerAddr: cvtServerAddr,
 		ProducerURL:   producerURL,
+		AdminURL:      adminURL,
 		SchemaPath:    schemaPath,
 		SchemaID:      "calculator-api",
 		Environment:   environment,
+		HTTPClient:    newProducerClient(t, producerURL),
+	}
+}
+
+// newProducerClient returns a client for producerURL that trusts
+// PRODUCER_TLS_CA_FILE and presents PRODUCER_TLS_CERT_FILE and
+// PRODUCER_TLS_KEY_FILE for mutual TLS, such as the files cmd/gencerts writes.
+func newProducerClient(t *testing.T, producerURL string) *http.Client {
+	t.Helper()
+
+	tlsClient := tlsconfig.ClientConfig{
+		CAFile:   os.Getenv("PRODUCER_TLS_CA_FILE"),
+		CertFile: os.Getenv("PRODUCER_TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("PRODUCER_TLS_KEY_FILE"),
+	}
+	if !tlsClient.Enabled() {
+		return http.DefaultClient
+	}
+
+	u, err := url.Parse(producerURL)
+	if err != nil {
+		t.Fatalf("Invalid PRODUCER_URL: %v", err)
+	}
+	config, err := tlsClient.Load(u.Hostname())
+	if err != nil {
+		t.Fatalf("Invalid producer TLS configuration: %v", err)
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}
 }
 
 // NewTestValidator creates a new CVT validator for testing.
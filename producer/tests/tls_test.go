@@ -0,0 +1,333 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahina/cvt-demo/producer/certgen"
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/tlsconfig"
+)
+
+// writeServerFiles writes a server certificate from ca for localhost to dir
+// and returns the certificate and key paths.
+func writeServerFiles(t *testing.T, ca *certgen.CA, dir string) (string, string) {
+	t.Helper()
+
+	pair, err := ca.Server("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	if err := os.WriteFile(certFile, pair.CertPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pair.KeyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// newTLSServer serves the calculator routes over TLS with the certificates
+// of config.
+func newTLSServer(t *testing.T, config tlsconfig.ServerConfig) (*httptest.Server, *tlsconfig.Reloader) {
+	t.Helper()
+
+	reloader, err := tlsconfig.NewReloader(config)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, reloader
+}
+
+// tlsClient returns a client trusting ca and presenting client, if any, even
+// when the server asks for certificates from other CAs.
+func tlsClient(ca *certgen.CA, client *certgen.Pair) *http.Client {
+	config := &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"}
+	if client != nil {
+		cert, _ := client.TLSCertificate()
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// TestTLS_ParseServerConfig tests the TLS_* settings.
+func TestTLS_ParseServerConfig(t *testing.T) {
+	testCases := []struct {
+		name                            string
+		cert, key, clientCA, clientAuth string
+		expectedAuth                    tls.ClientAuthType
+		wantErr                         bool
+	}{
+		{"off", "", "", "", "", tls.NoClientCert, false},
+		{"TLS", "c.pem", "k.pem", "", "", tls.NoClientCert, false},
+		{"mutual TLS", "c.pem", "k.pem", "ca.pem", "", tls.RequireAndVerifyClientCert, false},
+		{"optional client certificates", "c.pem", "k.pem", "ca.pem", "optional", tls.VerifyClientCertIfGiven, false},
+		{"certificate without key", "c.pem", "", "", "", 0, true},
+		{"client CA without TLS", "", "", "ca.pem", "", 0, true},
+		{"client auth without CA", "c.pem", "k.pem", "", "require", 0, true},
+		{"unknown client auth", "c.pem", "k.pem", "ca.pem", "sometimes", 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := tlsconfig.ParseServerConfig(tc.cert, tc.key, tc.clientCA, tc.clientAuth)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseServerConfig() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err == nil && config.ClientAuth != tc.expectedAuth {
+				t.Errorf("Expected client auth %v, got %v", tc.expectedAuth, config.ClientAuth)
+			}
+		})
+	}
+}
+
+// TestTLS_MutualTLS tests that the producer requires a client certificate
+// from the configured CA, or verifies one only if sent when optional.
+func TestTLS_MutualTLS(t *testing.T) {
+	ca, _ := certgen.NewCA("test CA")
+	otherCA, _ := certgen.NewCA("other CA")
+	client, _ := ca.Client("consumer-4")
+	stranger, _ := otherCA.Client("stranger")
+
+	dir := t.TempDir()
+	certFile, keyFile := writeServerFiles(t, ca, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, ca.CertPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		clientAuth string
+		client     *certgen.Pair
+		succeeds   bool
+	}{
+		{"required, trusted client", "require", &client, true},
+		{"required, no client certificate", "require", nil, false},
+		{"required, untrusted client", "require", &stranger, false},
+		{"optional, no client certificate", "optional", nil, true},
+		{"optional, untrusted client", "optional", &stranger, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := tlsconfig.ParseServerConfig(certFile, keyFile, caFile, tc.clientAuth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, _ := newTLSServer(t, config)
+
+			resp, err := tlsClient(ca, tc.client).Get(server.URL + "/add?x=5&y=3")
+			if !tc.succeeds {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("Expected the handshake to fail, got status %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestTLS_Reload tests that a rotated certificate is served to new
+// connections without restarting, and that a broken one is not.
+func TestTLS_Reload(t *testing.T) {
+	ca, _ := certgen.NewCA("test CA")
+	dir := t.TempDir()
+	certFile, keyFile := writeServerFiles(t, ca, dir)
+	config, _ := tlsconfig.ParseServerConfig(certFile, keyFile, "", "")
+	server, reloader := newTLSServer(t, config)
+
+	servedSerial := func() string {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"})
+		if err != nil {
+			t.Fatalf("Handshake failed: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.String()
+	}
+
+	before := servedSerial()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	// Rotate, and make sure the modification time moves even on coarse clocks
+	writeServerFiles(t, ca, dir)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the rotated certificate to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reloader.Certificate().SerialNumber.String() == before {
+		t.Error("Expected Certificate to report the rotated certificate")
+	}
+
+	rotated := servedSerial()
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reloading a broken certificate to fail")
+	}
+	if servedSerial() != rotated {
+		t.Error("Expected the previous certificate to stay in use after a failed reload")
+	}
+}
+
+// TestTLS_ClientConfig tests the CVT client settings.
+func TestTLS_ClientConfig(t *testing.T) {
+	ca, _ := certgen.NewCA("test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, ca.CertPEM, 0o600)
+
+	if (tlsconfig.ClientConfig{}).Enabled() {
+		t.Error("Expected an empty configuration to leave TLS off")
+	}
+	config, err := tlsconfig.ClientConfig{CAFile: caFile}.Load("cvt:9550")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if config.ServerName != "cvt" || config.RootCAs == nil {
+		t.Errorf("Expected server name cvt and the CA as root, got %q and %v", config.ServerName, config.RootCAs)
+	}
+	if config, _ := (tlsconfig.ClientConfig{CAFile: caFile, ServerName: "cvt.internal"}).Load("10.0.0.1:9550"); config.ServerName != "cvt.internal" {
+		t.Errorf("Expected the server name override, got %q", config.ServerName)
+	}
+
+	for name, c := range map[string]tlsconfig.ClientConfig{
+		"certificate without key": {CertFile: caFile},
+		"missing CA file":         {CAFile: caFile + ".missing"},
+	} {
+		if _, err := c.Load("cvt:9550"); err == nil {
+			t.Errorf("%s: expected Load to fail", name)
+		}
+	}
+}
+
+// TestTLS_Tunnel tests that plaintext connections to the tunnel's private
+// socket reach a TLS server that requires a client certificate.
+func TestTLS_Tunnel(t *testing.T) {
+	ca, _ := certgen.NewCA("test CA")
+	serverPair, _ := ca.Server("localhost", "127.0.0.1")
+	clientPair, _ := ca.Client("producer")
+	serverCert, _ := serverPair.TLSCertificate()
+	clientCert, _ := clientPair.TLSCertificate()
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.Pool(),
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	tunnel, err := tlsconfig.NewTunnel(upstream.Listener.Addr().String(), &tls.Config{
+		RootCAs:      ca.Pool(),
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientCert},
+	})
+	if err != nil {
+		t.Fatalf("NewTunnel failed: %v", err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.Addr() != "unix://"+tunnel.Path() {
+		t.Errorf("Expected a unix target for %s, got %s", tunnel.Path(), tunnel.Addr())
+	}
+	for path, mode := range map[string]os.FileMode{tunnel.Path(): 0o600, filepath.Dir(tunnel.Path()): 0o700} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("Expected %s to have mode %v, got %v", path, mode, info.Mode().Perm())
+		}
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", tunnel.Path())
+		},
+	}}
+	resp, err := client.Get("http://tunnel/")
+	if err != nil {
+		t.Fatalf("Request through the tunnel failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "producer" {
+		t.Errorf("Expected the upstream to see the client certificate, got %q", body)
+	}
+	client.CloseIdleConnections()
+
+	path := tunnel.Path()
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected Close to remove the socket directory, got %v", err)
+	}
+}
+
+// TestTLS_Generate tests the files cmd/gencerts writes.
+func TestTLS_Generate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	if err := certgen.Generate(dir, []string{"localhost", "127.0.0.1"}, "consumer-4"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	config, err := tlsconfig.ParseServerConfig(filepath.Join(dir, certgen.ServerCertFile),
+		filepath.Join(dir, certgen.ServerKeyFile), filepath.Join(dir, certgen.CAFile), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := newTLSServer(t, config)
+
+	clientConfig, err := tlsconfig.ClientConfig{
+		CAFile:   filepath.Join(dir, certgen.CAFile),
+		CertFile: filepath.Join(dir, certgen.ClientCertFile),
+		KeyFile:  filepath.Join(dir, certgen.ClientKeyFile),
+	}.Load("localhost")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,159 @@
+// Package tests contains producer contract tests.
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sahina/cvt-demo/producer/handlers"
+	"github.com/sahina/cvt-demo/producer/tracing"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataValidator records the gRPC metadata a CVT call would carry.
+type metadataValidator struct {
+	traceparents []string
+}
+
+func (v *metadataValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	v.traceparents = append(v.traceparents, md.Get("traceparent")...)
+	return &producer.ValidationResult{Valid: true}, nil
+}
+
+// validateAround validates the request before next runs and the full
+// interaction afterwards.
+func validateAround(validator producer.Validator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		interaction := &producer.Interaction{Method: r.Method, Path: r.URL.Path}
+		validator.Validate(r.Context(), "calculator-api", interaction)
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		interaction.StatusCode = rec.Code
+		validator.Validate(r.Context(), "calculator-api", interaction)
+
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+// tracedRequest serves one request through the traced calculator and returns
+// the finished spans keyed by name.
+func tracedRequest(t *testing.T, req *http.Request, validator producer.Validator) map[string]tracetest.SpanStub {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	tr := tracing.New(loadSpec(t, "calculator-api.yaml"), provider)
+	mux := http.NewServeMux()
+	handlers.NewCalculator().RegisterRoutes(mux)
+	handler := tr.Middleware(validateAround(tr.Validator(validator), tr.Handler(mux)))
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		// Both validation phases contain a CVT call; key those by their parent
+		if span.Name == tracing.SpanCVTCall {
+			for _, parent := range exporter.GetSpans() {
+				if parent.SpanContext.SpanID() == span.Parent.SpanID() {
+					spans[parent.Name+" > "+span.Name] = span
+				}
+			}
+			continue
+		}
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+// TestTracing_SpanStructure tests that a request produces a server span with
+// request validation, handler and response validation as its children, each
+// validation wrapping a CVT client span.
+func TestTracing_SpanStructure(t *testing.T) {
+	spans := tracedRequest(t, httptest.NewRequest("GET", "/add?x=1&y=2", nil), &metadataValidator{})
+
+	server, ok := spans["GET /add"]
+	if !ok {
+		t.Fatalf("Expected a server span named \"GET /add\", got %v", spanNames(spans))
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("Expected server span kind, got %v", server.SpanKind)
+	}
+
+	for _, name := range []string{tracing.SpanValidateRequest, "handler add", tracing.SpanValidateResponse} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a %q span, got %v", name, spanNames(spans))
+			continue
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("Expected %q to be a child of the server span", name)
+		}
+	}
+
+	for _, phase := range []string{tracing.SpanValidateRequest, tracing.SpanValidateResponse} {
+		call, ok := spans[phase+" > "+tracing.SpanCVTCall]
+		if !ok {
+			t.Errorf("Expected a CVT call under %q, got %v", phase, spanNames(spans))
+			continue
+		}
+		if call.SpanKind != trace.SpanKindClient {
+			t.Errorf("Expected the CVT call under %q to be a client span, got %v", phase, call.SpanKind)
+		}
+	}
+
+	for name, span := range spans {
+		if span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("Expected %q to belong to the request's trace", name)
+		}
+	}
+}
+
+// TestTracing_PropagatesTraceContext tests that an incoming traceparent is
+// continued and that each CVT call carries the context of its own span.
+func TestTracing_PropagatesTraceContext(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	req := httptest.NewRequest("GET", "/divide?x=1&y=2", nil)
+	req.Header.Set("traceparent", incoming)
+	validator := &metadataValidator{}
+
+	spans := tracedRequest(t, req, validator)
+
+	server := spans["GET /divide"]
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the incoming trace ID, got %s", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected the incoming span as parent, got %s", got)
+	}
+
+	if len(validator.traceparents) != 2 {
+		t.Fatalf("Expected a traceparent on both CVT calls, got %v", validator.traceparents)
+	}
+	for i, phase := range []string{tracing.SpanValidateRequest, tracing.SpanValidateResponse} {
+		call := spans[phase+" > "+tracing.SpanCVTCall]
+		want := "00-" + call.SpanContext.TraceID().String() + "-" + call.SpanContext.SpanID().String() + "-01"
+		if validator.traceparents[i] != want {
+			t.Errorf("Expected the %s CVT call to carry %s, got %s", phase, want, validator.traceparents[i])
+		}
+	}
+}
+
+func spanNames(spans map[string]tracetest.SpanStub) []string {
+	names := make([]string, 0, len(spans))
+	for name := range spans {
+		names = append(names, name)
+	}
+	return names
+}
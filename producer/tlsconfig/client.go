@@ -0,0 +1,59 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// ClientConfig describes a TLS connection to a server such as CVT, as read
+// from CVT_TLS_CA_FILE, CVT_TLS_CERT_FILE, CVT_TLS_KEY_FILE and
+// CVT_TLS_SERVER_NAME.
+type ClientConfig struct {
+	// CAFile holds the CAs the server's certificate must be issued by; the
+	// system roots are used when it is empty.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate presented for mutual
+	// TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name the server's certificate is verified
+	// against, which is otherwise the host being dialled.
+	ServerName string
+}
+
+// Enabled reports whether any setting asks for TLS.
+func (c ClientConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != ""
+}
+
+// Load builds the configuration for connecting to addr, a host with or
+// without a port.
+func (c ClientConfig) Load(addr string) (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.ServerName}
+	if config.ServerName == "" {
+		config.ServerName = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	if c.CAFile != "" {
+		pool, err := LoadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,218 @@
+// Package tlsconfig builds the producer's TLS configurations: the server
+// side, with certificates reloaded from disk while serving and optional
+// client certificate verification (mutual TLS), and the client side used to
+// reach CVT.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReloadInterval is how often Watch checks the files for changes.
+const DefaultReloadInterval = 10 * time.Second
+
+// ServerConfig locates the producer's certificate and, for mutual TLS, the
+// CAs client certificates must be issued by, as read from TLS_CERT_FILE,
+// TLS_KEY_FILE, TLS_CLIENT_CA_FILE and TLS_CLIENT_AUTH.
+type ServerConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS when set.
+	ClientCAFile string
+	// ClientAuth is how client certificates are verified when ClientCAFile
+	// is set.
+	ClientAuth tls.ClientAuthType
+}
+
+// ParseServerConfig validates the TLS_* settings. TLS is off when certFile
+// is empty. clientAuth is "require" (the default) or "optional", which
+// verifies a client certificate only when one is sent.
+func ParseServerConfig(certFile, keyFile, clientCAFile, clientAuth string) (ServerConfig, error) {
+	config := ServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile}
+	if (certFile == "") != (keyFile == "") {
+		return ServerConfig{}, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if certFile == "" && (clientCAFile != "" || clientAuth != "") {
+		return ServerConfig{}, fmt.Errorf("client certificates need TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	switch clientAuth {
+	case "", "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return ServerConfig{}, fmt.Errorf("unknown client auth %q (want require or optional)", clientAuth)
+	}
+	if clientCAFile == "" {
+		if clientAuth != "" {
+			return ServerConfig{}, fmt.Errorf("TLS_CLIENT_AUTH needs TLS_CLIENT_CA_FILE")
+		}
+		config.ClientAuth = tls.NoClientCert
+	}
+	return config, nil
+}
+
+// Enabled reports whether the producer serves TLS.
+func (c ServerConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// MutualTLS reports whether client certificates are verified.
+func (c ServerConfig) MutualTLS() bool {
+	return c.ClientCAFile != ""
+}
+
+// Reloader serves the certificate and client CAs most recently read from
+// the ServerConfig's files, so they can be rotated without a restart.
+type Reloader struct {
+	config  ServerConfig
+	current atomic.Pointer[serverState]
+}
+
+// serverState is one consistent reading of the files.
+type serverState struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+}
+
+// NewReloader reads the files in config, failing if any is missing or invalid.
+func NewReloader(config ServerConfig) (*Reloader, error) {
+	r := &Reloader{config: config}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. On error the previous certificate and CAs
+// stay in use.
+func (r *Reloader) Reload() error {
+	modTimes, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	state := &serverState{cert: &cert, modTimes: modTimes}
+	if r.config.MutualTLS() {
+		if state.clientCAs, err = LoadCertPool(r.config.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	r.current.Store(state)
+	return nil
+}
+
+// TLSConfig returns a server configuration that uses the latest certificate
+// and client CAs for every handshake.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			state := r.current.Load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*state.cert},
+				ClientAuth:   r.config.ClientAuth,
+				ClientCAs:    state.clientCAs,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// Certificate returns the leaf certificate currently served.
+func (r *Reloader) Certificate() *x509.Certificate {
+	cert := r.current.Load().cert
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// Watch reloads the files whenever their modification times change, checking
+// every interval until ctx is done. Failed reloads are logged and retried at
+// the next change.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTimes, err := r.modTimes()
+		if err != nil || equalTimes(modTimes, r.current.Load().modTimes) {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Warn("Failed to reload TLS certificate; keeping the previous one", "error", err)
+			// Remember the attempt, so a half-written file is not retried
+			// until it changes again
+			previous := r.current.Load()
+			r.current.Store(&serverState{cert: previous.cert, clientCAs: previous.clientCAs, modTimes: modTimes})
+			continue
+		}
+		slog.Info("Reloaded TLS certificate", "not_after", r.Certificate().NotAfter)
+	}
+}
+
+// modTimes returns the modification times of the configured files.
+func (r *Reloader) modTimes() ([]time.Time, error) {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.MutualTLS() {
+		files = append(files, r.config.ClientCAFile)
+	}
+	times := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = info.ModTime()
+	}
+	return times, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadCertPool reads PEM certificates from file into a new pool.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", file)
+	}
+	return pool, nil
+}
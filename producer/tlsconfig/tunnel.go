@@ -0,0 +1,121 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds connecting and handshaking with the server.
+const dialTimeout = 10 * time.Second
+
+// SocketName is the name of the tunnel's socket in its directory.
+const SocketName = "tunnel.sock"
+
+// Tunnel relays plaintext connections from a unix socket to a TLS server.
+// cvt.NewValidator in github.com/sahina/cvt/sdks/go v0.3.0 takes only an
+// address and accepts no transport credentials, so the producer points the
+// SDK at a tunnel to reach CVT over TLS.
+//
+// Whoever can connect to the socket talks to the server with config's
+// client certificate. The socket is therefore created with mode 0600 in a
+// new directory with mode 0700, so only the producer's user can reach it; a
+// process running as that user can still borrow the producer's identity.
+type Tunnel struct {
+	listener net.Listener
+	dir      string
+	addr     string
+	config   *tls.Config
+	wg       sync.WaitGroup
+}
+
+// NewTunnel listens on a unix socket in a new private directory and relays
+// each connection to addr over TLS with config. gRPC servers such as CVT
+// need "h2" in config.NextProtos.
+func NewTunnel(addr string, config *tls.Config) (*Tunnel, error) {
+	dir, err := os.MkdirTemp("", "cvt-tunnel-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, SocketName)
+	listener, err := net.Listen("unix", path)
+	if err == nil {
+		err = os.Chmod(path, 0o600)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	t := &Tunnel{listener: listener, dir: dir, addr: addr, config: config}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// Path is the tunnel's socket.
+func (t *Tunnel) Path() string {
+	return t.listener.Addr().String()
+}
+
+// Addr is the gRPC target to dial instead of the server's address.
+func (t *Tunnel) Addr() string {
+	return "unix://" + t.Path()
+}
+
+// Close stops accepting connections and removes the socket. Relayed
+// connections end with their peers.
+func (t *Tunnel) Close() error {
+	err := t.listener.Close()
+	t.wg.Wait()
+	if rmErr := os.RemoveAll(t.dir); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func (t *Tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.relay(conn)
+	}
+}
+
+// relay copies between conn and a new TLS connection to the server until
+// either side closes.
+func (t *Tunnel) relay(conn net.Conn) {
+	defer conn.Close()
+
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: dialTimeout}, Config: t.config}
+	upstream, err := dialer.Dial("tcp", t.addr)
+	if err != nil {
+		slog.Warn("TLS tunnel failed to connect", "addr", t.addr, "error", err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.(*tls.Conn).CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		conn.(*net.UnixConn).CloseWrite()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
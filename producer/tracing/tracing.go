@@ -0,0 +1,192 @@
+// Package tracing adds OpenTelemetry spans to the Calculator API.
+//
+// A request produces one trace:
+//
+//	GET /add                  server span, continues an incoming traceparent
+//	├── validate request      request-only CVT validation, if the middleware does one
+//	│   └── cvt.Validate      gRPC call to the CVT server, carrying the trace context
+//	├── handler add           the operation handler
+//	└── validate response     CVT validation of the full interaction
+//	    └── cvt.Validate
+//
+// so the cost of contract enforcement can be read off against the handler.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sahina/cvt-demo/producer/contract"
+	"github.com/sahina/cvt-demo/producer/response"
+	"github.com/sahina/cvt/sdks/go/cvt/producer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const instrumentationName = "github.com/sahina/cvt-demo/producer/tracing"
+
+// Span names.
+const (
+	SpanValidateRequest  = "validate request"
+	SpanValidateResponse = "validate response"
+	SpanCVTCall          = "cvt.Validate"
+)
+
+// NewProvider creates the tracer provider for the producer. Spans are exported
+// over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or the traces-specific
+// variant) is set; otherwise they are recorded but not exported, which still
+// lets the trace context propagate to CVT. Call Shutdown to flush on exit.
+func NewProvider(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if Exporting() {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// Exporting reports whether an OTLP endpoint is configured.
+func Exporting() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Tracer creates the producer's spans. Operations are named after the
+// operationId the contract assigns to the request's method and path.
+type Tracer struct {
+	spec       *contract.Spec
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a Tracer for the given contract using provider.
+func New(spec *contract.Spec, provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		spec:       spec,
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Middleware starts the server span for every request, continuing the trace
+// from an incoming traceparent header. It belongs outside the CVT middleware
+// so validation spans become its children.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		}
+		name := r.Method
+		if id, ok := t.spec.OperationID(r.Method, r.URL.Path); ok {
+			name += " " + r.URL.Path
+			attrs = append(attrs, semconv.HTTPRoute(r.URL.Path), attribute.String("calculator.operation", id))
+		}
+
+		ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		defer span.End()
+
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status()))
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
+
+// Handler wraps the routes in a span of their own. It belongs inside the CVT
+// middleware so the span covers the handler alone.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "handler"
+		if id, ok := t.spec.OperationID(r.Method, r.URL.Path); ok {
+			name += " " + id
+		}
+
+		ctx, span := t.tracer.Start(r.Context(), name)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Validator wraps v so each validation gets a span named after its phase, with
+// a client span for the CVT call whose trace context travels as gRPC metadata.
+// An interaction without a status code has not been handled yet and is a
+// request validation; otherwise the response is being validated.
+func (t *Tracer) Validator(v producer.Validator) producer.Validator {
+	return &tracingValidator{next: v, tracer: t}
+}
+
+type tracingValidator struct {
+	next   producer.Validator
+	tracer *Tracer
+}
+
+// Validate implements the producer.Validator interface.
+func (v *tracingValidator) Validate(ctx context.Context, schemaID string, interaction *producer.Interaction) (*producer.ValidationResult, error) {
+	phase := SpanValidateResponse
+	if interaction.StatusCode == 0 {
+		phase = SpanValidateRequest
+	}
+
+	ctx, span := v.tracer.tracer.Start(ctx, phase, trace.WithAttributes(
+		attribute.String("cvt.schema_id", schemaID),
+	))
+	defer span.End()
+
+	ctx, call := v.tracer.tracer.Start(ctx, SpanCVTCall,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCMethod("Validate")),
+	)
+	result, err := v.next.Validate(v.tracer.inject(ctx), schemaID, interaction)
+	if err != nil {
+		call.RecordError(err)
+		call.SetStatus(codes.Error, err.Error())
+	}
+	call.End()
+
+	switch {
+	case err != nil:
+		span.SetStatus(codes.Error, "validation error")
+	case !result.Valid:
+		span.SetAttributes(attribute.Bool("cvt.valid", false), attribute.StringSlice("cvt.errors", result.Errors))
+		span.SetStatus(codes.Error, "contract violation")
+	default:
+		span.SetAttributes(attribute.Bool("cvt.valid", true))
+	}
+	return result, err
+}
+
+// inject adds the span context in ctx to its outgoing gRPC metadata, so the
+// CVT client sends it whichever interceptors it was built with.
+func (t *Tracer) inject(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	for key, value := range carrier {
+		ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+	}
+	return ctx
+}